@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/llm"
+	"github.com/davidleitw/baha/internal/qa"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+	logrus.SetReportCaller(true)
+}
+
+func main() {
+	bsn := flag.Int("bsn", 0, "bsn of the building the question is about")
+	sna := flag.Int("sna", 0, "snA of the building the question is about")
+	rowLimit := flag.Int("rows", qa.DefaultRowLimit, "max rows returned by the generated query")
+	timeout := flag.Duration("timeout", qa.DefaultQueryTimeout, "max execution time of the generated query")
+	flag.Parse()
+
+	question := strings.TrimSpace(strings.Join(flag.Args(), " "))
+	if question == "" {
+		logrus.Fatal("usage: ask [-bsn 60076 -sna 3146926] <question>")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		logrus.Fatalf("Error loading .env file: %v", err)
+	}
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		logrus.WithError(err).Error("buildingDb.Open failed")
+		return
+	}
+
+	provider, err := llm.NewProviderFromEnv()
+	if err != nil {
+		logrus.WithError(err).Error("llm.NewProviderFromEnv failed")
+		return
+	}
+
+	meterOpts, err := llm.MeterOptionsFromEnv()
+	if err != nil {
+		logrus.WithError(err).Error("llm.MeterOptionsFromEnv failed")
+		return
+	}
+	provider = llm.NewMeter(provider, buildingDb, meterOpts...)
+
+	bid := ""
+	var building *db.BuildingRecord
+	if *bsn != 0 && *sna != 0 {
+		if building, err = buildingDb.GetBuildingRecord(*bsn, *sna); err != nil {
+			logrus.WithError(err).Errorf("building bsn=%d snA=%d is not archived", *bsn, *sna)
+			return
+		}
+		bid = building.Id
+	}
+
+	driver, err := db.OpenReadOnly()
+	if err != nil {
+		logrus.WithError(err).Error("db.OpenReadOnly failed")
+		return
+	}
+	defer driver.Close()
+
+	answerer, err := qa.NewSqlAnswerer(provider, qa.NewExecutor(driver, qa.RowLimit(*rowLimit), qa.QueryTimeout(*timeout)))
+	if err != nil {
+		logrus.WithError(err).Error("qa.NewSqlAnswerer failed")
+		return
+	}
+
+	ctx := llm.WithUsageTags(context.Background(), bid, question)
+	answer, err := answerer.Ask(ctx, question, building)
+	if answer != nil && answer.Sql != "" {
+		fmt.Printf("SQL:\n%s\n\n", answer.Sql)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("answerer.Ask failed")
+		return
+	}
+
+	fmt.Printf("Result:\n%s\n", answer.Result)
+	fmt.Printf("Answer:\n%s\n", answer.Answer)
+}
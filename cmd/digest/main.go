@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/digest"
+	"github.com/davidleitw/baha/internal/llm"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+const dateLayout = "2006-01-02 15:04"
+
+func init() {
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+	logrus.SetReportCaller(true)
+}
+
+func parseWindow(fromPage, toPage int, since time.Duration, from, to string) (digest.Window, error) {
+	if fromPage > 0 {
+		if toPage < fromPage {
+			toPage = fromPage
+		}
+		return digest.PageWindow(fromPage, toPage), nil
+	}
+
+	now := time.Now()
+	if since > 0 {
+		return digest.TimeWindow(now.Add(-since), now), nil
+	}
+
+	if from == "" {
+		return digest.Window{}, fmt.Errorf("one of -from-page, -since or -from is required")
+	}
+
+	start, err := time.ParseInLocation(dateLayout, from, craw.BahaTimeZone)
+	if err != nil {
+		return digest.Window{}, err
+	}
+	end := now
+	if to != "" {
+		if end, err = time.ParseInLocation(dateLayout, to, craw.BahaTimeZone); err != nil {
+			return digest.Window{}, err
+		}
+	}
+	return digest.TimeWindow(start, end), nil
+}
+
+func main() {
+	bsn := flag.Int("bsn", 60076, "bsn of the building")
+	sna := flag.Int("sna", 0, "snA of the building")
+	fromPage := flag.Int("from-page", 0, "first page of the digest")
+	toPage := flag.Int("to-page", 0, "last page of the digest, defaults to -from-page")
+	since := flag.Duration("since", 0, "digest the floors posted within this duration, e.g. 24h")
+	from := flag.String("from", "", "digest the floors posted after this time, e.g. \"2024-07-01 00:00\"")
+	to := flag.String("to", "", "digest the floors posted before this time, defaults to now")
+	topN := flag.Int("top", digest.DefaultTopN, "number of floors listed in the rankings")
+	out := flag.String("out", "", "directory to write the digest into, stdout when empty")
+	flag.Parse()
+
+	if *sna == 0 {
+		logrus.Fatal("usage: digest -sna 3146926 (-from-page 1 [-to-page 3] | -since 24h | -from \"2024-07-01 00:00\" [-to ...]) [-out data/digest]")
+	}
+
+	window, err := parseWindow(*fromPage, *toPage, *since, *from, *to)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid digest window")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		logrus.Fatalf("Error loading .env file: %v", err)
+	}
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		logrus.WithError(err).Error("buildingDb.Open failed")
+		return
+	}
+
+	building, err := buildingDb.GetBuildingRecord(*bsn, *sna)
+	if err != nil {
+		logrus.WithError(err).Errorf("building bsn=%d snA=%d is not archived", *bsn, *sna)
+		return
+	}
+
+	provider, err := llm.NewProviderFromEnv()
+	if err != nil {
+		logrus.WithError(err).Error("llm.NewProviderFromEnv failed")
+		return
+	}
+
+	meterOpts, err := llm.MeterOptionsFromEnv()
+	if err != nil {
+		logrus.WithError(err).Error("llm.MeterOptionsFromEnv failed")
+		return
+	}
+
+	generator := digest.NewGenerator(llm.NewMeter(provider, buildingDb, meterOpts...), buildingDb, digest.TopN(*topN))
+	if *out != "" {
+		path, err := generator.WriteFile(context.Background(), building, window, *out)
+		if err != nil {
+			logrus.WithError(err).Error("generator.WriteFile failed")
+			return
+		}
+		logrus.Infof("Digest written to %s", path)
+		return
+	}
+
+	result, err := generator.Generate(context.Background(), building, window)
+	if err != nil {
+		logrus.WithError(err).Error("generator.Generate failed")
+		return
+	}
+	fmt.Fprint(os.Stdout, result.Markdown)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/textutil"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+	logrus.SetReportCaller(true)
+}
+
+func main() {
+	bsn := flag.Int("bsn", 60076, "bsn of the building")
+	sna := flag.Int("sna", 0, "snA of the building")
+	floors := flag.String("floors", "", "floors to fetch as cited, B12 or B12-B20")
+	flag.Parse()
+
+	from, to, err := craw.ParseFloorRange(*floors)
+	if *sna == 0 || err != nil {
+		logrus.Fatal("usage: fetch -sna 3146926 -floors B12[-B20] [-bsn 60076]")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		logrus.Fatalf("Error loading .env file: %v", err)
+	}
+
+	crawler, err := craw.NewCrawler(craw.OptionsFromEnv()...)
+	if err != nil {
+		logrus.WithError(err).Error("craw.NewCrawler failed")
+		return
+	}
+	if err := crawler.LoginAndKeepCookies(os.Getenv("ACCOUNT"), os.Getenv("PASSWORD")); err != nil {
+		logrus.WithError(err).Error("crawler.LoginAndKeepCookies failed")
+		return
+	}
+
+	records, err := crawler.FetchFloors(&craw.TargetInfo{Bsn: *bsn, Sna: *sna}, from, to)
+	if err != nil {
+		logrus.WithError(err).Error("crawler.FetchFloors failed")
+		return
+	}
+
+	for _, floor := range records {
+		fmt.Printf("B%d (page %d) %s (%s)\n", floor.FloorIndex, floor.PageIndex, floor.AuthorName, floor.AuthorId)
+		fmt.Println(textutil.PlainText(floor.Content))
+		for _, reply := range floor.Replies {
+			fmt.Printf(" > %s: %s\n", reply.AuthorName, reply.Content)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d floors of B%d ~ B%d archived\n", len(records), from, to)
+	if missing := to - from + 1 - len(records); missing > 0 {
+		fmt.Printf("%d floors are deleted or beyond the last floor\n", missing)
+	}
+}
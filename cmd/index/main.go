@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/index"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+	logrus.SetReportCaller(true)
+}
+
+func refresh(buildingDb db.BuildingDB, bsn int, full bool, pages int) error {
+	if err := godotenv.Load(); err != nil {
+		logrus.WithError(err).Error("Error loading .env file")
+		return err
+	}
+
+	crawler, err := craw.NewCrawler(craw.OptionsFromEnv()...)
+	if err != nil {
+		logrus.WithError(err).Error("craw.NewCrawler failed")
+		return err
+	}
+	if err := crawler.LoginAndKeepCookies(os.Getenv("ACCOUNT"), os.Getenv("PASSWORD")); err != nil {
+		logrus.WithError(err).Error("crawler.LoginAndKeepCookies failed")
+		return err
+	}
+
+	indexer := index.NewIndexer(crawler, buildingDb, index.MaxPages(pages))
+	result, err := indexer.Refresh(context.Background(), bsn, full)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Read %d pages of board %d: %d new, %d updated, %d unchanged\n\n",
+		result.Pages, bsn, result.New, result.Updated, result.Unchanged)
+	return nil
+}
+
+func main() {
+	bsn := flag.Int("bsn", 60076, "bsn of the board")
+	offline := flag.Bool("offline", false, "search the local index without reading the board first")
+	full := flag.Bool("full", false, "read every page of the board instead of the pages changed since the last refresh")
+	pages := flag.Int("pages", index.DefaultMaxPages, "read at most n pages of the board")
+	title := flag.String("title", "", "search threads whose title contains the text")
+	authorId := flag.String("author", "", "search threads opened by the AuthorId")
+	category := flag.String("category", "", "search threads of the category")
+	minGp := flag.Int("min-gp", 0, "search threads with at least n GP")
+	days := flag.Int("days", 0, "search threads active in the last n days")
+	limit := flag.Int("limit", db.DefaultThreadSearchLimit, "show at most n threads")
+	flag.Parse()
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		logrus.WithError(err).Error("buildingDb.Open failed")
+		return
+	}
+
+	if !*offline {
+		if err := refresh(buildingDb, *bsn, *full, *pages); err != nil {
+			logrus.WithError(err).Error("refresh failed")
+			return
+		}
+	}
+
+	query := &db.ThreadQuery{
+		Bsn:      *bsn,
+		Title:    *title,
+		AuthorId: *authorId,
+		Category: *category,
+		MinGp:    *minGp,
+		Limit:    *limit,
+	}
+	if *days > 0 {
+		query.ActiveSince = time.Now().AddDate(0, 0, -*days)
+	}
+
+	threads, err := buildingDb.SearchThreadRecords(query)
+	if err != nil {
+		logrus.WithError(err).Error("buildingDb.SearchThreadRecords failed")
+		return
+	}
+
+	fmt.Printf("%-10s %-16s %-8s %6s %8s %-16s %s\n", "snA", "author", "category", "GP", "replies", "last reply", "title")
+	for _, thread := range threads {
+		lastReply := "-"
+		if !thread.LastReplyAt.IsZero() {
+			lastReply = thread.LastReplyAt.In(craw.BahaTimeZone).Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-10d %-16s %-8s %6d %8d %-16s %s\n", thread.Sna, thread.AuthorId, thread.Category,
+			thread.Gp, thread.ReplyCount, lastReply, thread.Title)
+	}
+	fmt.Printf("\n%d threads\n", len(threads))
+}
@@ -24,7 +24,7 @@ func main() {
 	account := os.Getenv("ACCOUNT")
 	password := os.Getenv("PASSWORD")
 
-	crawler, err := craw.NewCrawler()
+	crawler, err := craw.NewCrawler(craw.OptionsFromEnv()...)
 	if err != nil {
 		logrus.WithError(err).Error("NewCrawler error")
 		return
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/digest"
+	"github.com/davidleitw/baha/internal/llm"
 	"github.com/davidleitw/baha/internal/monitor"
+	"github.com/davidleitw/baha/internal/notify"
 	"github.com/davidleitw/baha/internal/rule"
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
@@ -12,6 +19,8 @@ import (
 
 const (
 	CsBuildingNo = 3146926
+
+	digestDir = "data/digest"
 )
 
 func init() {
@@ -21,16 +30,82 @@ func init() {
 	logrus.SetReportCaller(true)
 }
 
-func newTrackRule(Id string) *rule.TrackingRule {
+func newTrackRule(Id string, skipReplay bool) *rule.TrackingRule {
 	return rule.NewTrackingRule(
 		rule.Bsn(60076),
 		rule.Sna(CsBuildingNo),
 		rule.Id(Id),
 		rule.PokeInterval(10*time.Second),
+		rule.SkipReplay(skipReplay),
 	)
 }
 
+// digestWindow is the window of a digest run at now.
+func digestWindow(digestRule *rule.DigestRule, building *db.BuildingRecord, now time.Time) digest.Window {
+	if digestRule.LastPages > 0 {
+		from := building.LastPageIndex - digestRule.LastPages + 1
+		if from < 1 {
+			from = 1
+		}
+		return digest.PageWindow(from, building.LastPageIndex)
+	}
+	return digest.TimeWindow(now.Add(-digestRule.Window), now)
+}
+
+// newDigestJobs writes the digest of every digest rule every interval of
+// the rule, each under its own directory.
+func newDigestJobs(digestRules []*rule.DigestRule) ([]*monitor.Job, error) {
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		logrus.WithError(err).Error("buildingDb.Open failed")
+		return nil, err
+	}
+
+	provider, err := llm.NewProviderFromEnv()
+	if err != nil {
+		logrus.WithError(err).Error("llm.NewProviderFromEnv failed")
+		return nil, err
+	}
+
+	meterOpts, err := llm.MeterOptionsFromEnv()
+	if err != nil {
+		logrus.WithError(err).Error("llm.MeterOptionsFromEnv failed")
+		return nil, err
+	}
+	generator := digest.NewGenerator(llm.NewMeter(provider, buildingDb, meterOpts...), buildingDb)
+
+	jobs := make([]*monitor.Job, 0, len(digestRules))
+	for _, digestRule := range digestRules {
+		digestRule := digestRule
+		jobs = append(jobs, &monitor.Job{
+			Name:     "digest:" + digestRule.Name,
+			Interval: digestRule.Interval,
+			Run: func() error {
+				building, err := buildingDb.GetBuildingRecord(digestRule.Bsn, digestRule.Sna)
+				if err != nil {
+					logrus.WithError(err).Errorf("building bsn=%d snA=%d of digest %s is not archived", digestRule.Bsn, digestRule.Sna, digestRule.Name)
+					return err
+				}
+
+				window := digestWindow(digestRule, building, time.Now())
+				path, err := generator.WriteFile(context.Background(), building, window, filepath.Join(digestDir, digestRule.Name))
+				if err != nil {
+					return err
+				}
+				logrus.Infof("Digest %s written to %s", digestRule.Name, path)
+				return nil
+			},
+		})
+	}
+	return jobs, nil
+}
+
 func main() {
+	skipReplay := flag.Bool("skip-replay", false, "do not replay floors posted while the monitor was down")
+	rulesPath := flag.String("rules", "", "YAML or JSON rules file, reloaded on change or SIGHUP")
+	controlAddr := flag.String("http", "", "serve the control API, dashboard and /metrics on this address, e.g. localhost:8080, CONTROL_TOKEN is required on other than loopback, open /?token=CONTROL_TOKEN to log in the dashboard")
+	flag.Parse()
+
 	if err := godotenv.Load(); err != nil {
 		logrus.Fatalf("Error loading .env file: %v", err)
 	}
@@ -39,7 +114,21 @@ func main() {
 	password := os.Getenv("PASSWORD")
 
 	rules := []*rule.TrackingRule{
-		newTrackRule("leichitw"),
+		newTrackRule("leichitw", *skipReplay),
+	}
+
+	// -skip-replay overrides skip_replay of every rule in the file
+	fileOpts := make([]rule.RuleOption, 0)
+	if *skipReplay {
+		fileOpts = append(fileOpts, rule.SkipReplay(true))
+	}
+	if *rulesPath != "" {
+		fileRules, err := rule.LoadRulesFile(*rulesPath, fileOpts...)
+		if err != nil {
+			logrus.WithError(err).Error("rule.LoadRulesFile failed")
+			return
+		}
+		rules = fileRules
 	}
 
 	monitor, err := monitor.NewMonitor(
@@ -52,6 +141,49 @@ func main() {
 		return
 	}
 
+	notifier, err := notify.NewNotifierFromEnv()
+	if err != nil {
+		logrus.WithError(err).Error("notify.NewNotifierFromEnv failed")
+		return
+	}
+	monitor.SetNotifier(notifier)
+	if *rulesPath != "" {
+		boards, err := rule.LoadBoardRulesFile(*rulesPath, fileOpts...)
+		if err != nil {
+			logrus.WithError(err).Error("rule.LoadBoardRulesFile failed")
+			return
+		}
+		for _, board := range boards {
+			monitor.AddBoardRule(board)
+		}
+		monitor.WatchRulesFile(*rulesPath, fileOpts...)
+	}
+	if *controlAddr != "" {
+		monitor.ServeControl(*controlAddr, os.Getenv("CONTROL_TOKEN"), fileOpts...)
+	}
+
+	if os.Getenv("DIGEST_INTERVAL") != "" {
+		logrus.Error("DIGEST_INTERVAL is no longer read, add the building and window to the digests section of the rules file")
+		return
+	}
+	if *rulesPath != "" {
+		digestRules, err := rule.LoadDigestRulesFile(*rulesPath)
+		if err != nil {
+			logrus.WithError(err).Error("rule.LoadDigestRulesFile failed")
+			return
+		}
+		if len(digestRules) > 0 {
+			jobs, err := newDigestJobs(digestRules)
+			if err != nil {
+				logrus.WithError(err).Error("newDigestJobs failed")
+				return
+			}
+			for _, job := range jobs {
+				monitor.AddJob(job)
+			}
+		}
+	}
+
 	if err := monitor.Run(); err != nil {
 		logrus.WithError(err).Error("monitor.Run failed")
 	}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/llm"
+	"github.com/davidleitw/baha/internal/profile"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+	logrus.SetReportCaller(true)
+}
+
+func main() {
+	bsn := flag.Int("bsn", 60076, "bsn of the building")
+	sna := flag.Int("sna", 0, "snA of the building")
+	authorId := flag.String("id", "", "AuthorId of the user to summarize")
+	full := flag.Bool("full", false, "regenerate the whole profile instead of the new floors only")
+	chunkTokens := flag.Int("chunk", profile.DefaultMaxChunkTokens, "max tokens of posts sent in a single call")
+	flag.Parse()
+
+	if *sna == 0 || *authorId == "" {
+		logrus.Fatal("usage: profile -sna 3146926 -id <AuthorId> [-bsn 60076] [-full]")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		logrus.Fatalf("Error loading .env file: %v", err)
+	}
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		logrus.WithError(err).Error("buildingDb.Open failed")
+		return
+	}
+
+	building, err := buildingDb.GetBuildingRecord(*bsn, *sna)
+	if err != nil {
+		logrus.WithError(err).Errorf("building bsn=%d snA=%d is not archived", *bsn, *sna)
+		return
+	}
+
+	provider, err := llm.NewProviderFromEnv()
+	if err != nil {
+		logrus.WithError(err).Error("llm.NewProviderFromEnv failed")
+		return
+	}
+
+	meterOpts, err := llm.MeterOptionsFromEnv()
+	if err != nil {
+		logrus.WithError(err).Error("llm.MeterOptionsFromEnv failed")
+		return
+	}
+	provider = llm.NewMeter(provider, buildingDb, meterOpts...)
+
+	summarizer := profile.NewSummarizer(provider, buildingDb, profile.MaxChunkTokens(*chunkTokens))
+	record, err := summarizer.Summarize(context.Background(), building, *authorId, *full)
+	if err != nil {
+		logrus.WithError(err).Error("summarizer.Summarize failed")
+		return
+	}
+
+	fmt.Printf("Profile of %s in %s (B%d ~ B%d, %d posts)\n\n", record.AuthorId, building.BuildingTitle,
+		record.FirstFloorIndex, record.LastFloorIndex, record.PostCount)
+	fmt.Println(record.Summary)
+	fmt.Printf("\nCitations: %d\n", len(record.Citations))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/raw"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+	logrus.SetReportCaller(true)
+}
+
+type summary struct {
+	pages, floors, replies, failed int
+}
+
+// isBuildingPage tells whether url is a page of a building, s_author pages
+// are numbered by the floors of the author and skipped.
+func isBuildingPage(rawURL string, sna int) bool {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	params := parsedURL.Query()
+	if params.Get("s_author") != "" || params.Get("snA") == "" {
+		return false
+	}
+	return sna == 0 || params.Get("snA") == fmt.Sprint(sna)
+}
+
+// reparse rebuilds the floors and replies of a stored page through the
+// current parser.
+func reparse(crawler craw.Crawler, buildingDb db.BuildingDB, record *db.RawPageRecord, result *summary) error {
+	targetInfo, err := craw.GetTargetInfoFromUrl(record.Url)
+	if err != nil {
+		logrus.WithError(err).Error("craw.GetTargetInfoFromUrl failed")
+		return err
+	}
+
+	page, err := crawler.ParsePage(record.Url)
+	if err != nil {
+		logrus.WithError(err).Error("crawler.ParsePage failed")
+		return err
+	}
+	pageRecord, err := buildingDb.SyncPageRecord(targetInfo.Bsn, targetInfo.Sna, page.PageIndex)
+	if err != nil {
+		logrus.WithError(err).Error("buildingDb.SyncPageRecord failed")
+		return err
+	}
+
+	for _, floor := range page.Floors {
+		floor.Bid, floor.Pid = pageRecord.Bid, pageRecord.Pid
+		if err := buildingDb.RebuildFloorRecord(floor); err != nil {
+			logrus.WithError(err).Errorf("buildingDb.RebuildFloorRecord of B%d failed", floor.FloorIndex)
+			return err
+		}
+		for _, reply := range floor.Replies {
+			reply.Fid = floor.Fid
+			if err := buildingDb.RebuildReplyRecord(reply); err != nil {
+				logrus.WithError(err).Errorf("buildingDb.RebuildReplyRecord under B%d failed", floor.FloorIndex)
+				return err
+			}
+		}
+		if err := buildingDb.SaveFloorNicknames(floor, record.FetchedAt); err != nil {
+			logrus.WithError(err).Errorf("buildingDb.SaveFloorNicknames of B%d failed", floor.FloorIndex)
+			return err
+		}
+		result.floors++
+		result.replies += len(floor.Replies)
+	}
+	result.pages++
+	return nil
+}
+
+func main() {
+	// .env is optional here, it only provides RAW_HTML_DIR
+	_ = godotenv.Load()
+
+	defaultDir := raw.DefaultDir
+	if dir := os.Getenv("RAW_HTML_DIR"); dir != "" {
+		defaultDir = dir
+	}
+	dir := flag.String("dir", defaultDir, "directory of the stored raw responses")
+	sna := flag.Int("sna", 0, "only reparse the pages of this building")
+	flag.Parse()
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		logrus.WithError(err).Error("buildingDb.Open failed")
+		return
+	}
+
+	crawler, err := craw.NewCrawler(craw.Offline(*dir))
+	if err != nil {
+		logrus.WithError(err).Error("craw.NewCrawler failed")
+		return
+	}
+
+	records, err := buildingDb.GetLatestRawPageRecords(craw.BahaBaseUrl)
+	if err != nil {
+		logrus.WithError(err).Error("buildingDb.GetLatestRawPageRecords failed")
+		return
+	}
+	// a page stored under several urls keeps what was fetched last
+	sort.Slice(records, func(i, j int) bool { return records[i].Id < records[j].Id })
+
+	result := &summary{}
+	for _, record := range records {
+		if !isBuildingPage(record.Url, *sna) {
+			continue
+		}
+		if err := reparse(crawler, buildingDb, record, result); err != nil {
+			logrus.WithError(err).Errorf("reparse %s failed", record.Url)
+			result.failed++
+		}
+	}
+	fmt.Printf("Rebuilt %d floors and %d replies from %d pages, %d pages failed\n",
+		result.floors, result.replies, result.pages, result.failed)
+}
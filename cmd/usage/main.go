@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+	logrus.SetReportCaller(true)
+}
+
+func main() {
+	groupBy := flag.String("by", db.UsageGroupByDay, "group spend by day, building, question or model")
+	days := flag.Int("days", 30, "report the usage of the last n days")
+	flag.Parse()
+
+	// .env is optional here, it only provides LLM_MONTHLY_BUDGET
+	_ = godotenv.Load()
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		logrus.WithError(err).Error("buildingDb.Open failed")
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -*days)
+	summaries, err := buildingDb.GetUsageSummary(*groupBy, since)
+	if err != nil {
+		logrus.WithError(err).Error("buildingDb.GetUsageSummary failed")
+		return
+	}
+
+	fmt.Printf("%-40s %8s %8s %12s %12s %12s\n", *groupBy, "calls", "cached", "prompt", "completion", "cost(USD)")
+	var total float64
+	for _, summary := range summaries {
+		key := summary.Key
+		if key == "" {
+			key = "-"
+		}
+		if len([]rune(key)) > 40 {
+			key = string([]rune(key)[:37]) + "..."
+		}
+		fmt.Printf("%-40s %8d %8d %12d %12d %12.4f\n", key,
+			summary.Calls, summary.CacheHits, summary.PromptTokens, summary.CompletionTokens, summary.Cost)
+		total += summary.Cost
+	}
+	fmt.Printf("\nTotal spend of the last %d days: %.4f USD\n", *days, total)
+
+	now := time.Now()
+	monthSpent, err := buildingDb.GetUsageCostSince(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()))
+	if err != nil {
+		logrus.WithError(err).Error("buildingDb.GetUsageCostSince failed")
+		return
+	}
+
+	if budget, err := strconv.ParseFloat(os.Getenv("LLM_MONTHLY_BUDGET"), 64); err == nil && budget > 0 {
+		fmt.Printf("Spend of this month: %.4f / %.4f USD\n", monthSpent, budget)
+	} else {
+		fmt.Printf("Spend of this month: %.4f USD (no budget)\n", monthSpent)
+	}
+}
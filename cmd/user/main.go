@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+const dateLayout = "2006-01-02"
+
+func init() {
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+	logrus.SetReportCaller(true)
+}
+
+func crawl(buildingDb db.BuildingDB, authorId string) error {
+	if err := godotenv.Load(); err != nil {
+		logrus.WithError(err).Error("Error loading .env file")
+		return err
+	}
+
+	crawler, err := craw.NewCrawler(craw.OptionsFromEnv()...)
+	if err != nil {
+		logrus.WithError(err).Error("craw.NewCrawler failed")
+		return err
+	}
+	if err := crawler.LoginAndKeepCookies(os.Getenv("ACCOUNT"), os.Getenv("PASSWORD")); err != nil {
+		logrus.WithError(err).Error("crawler.LoginAndKeepCookies failed")
+		return err
+	}
+
+	record, err := crawler.ParseUserHome(authorId)
+	if err != nil {
+		logrus.WithError(err).Error("crawler.ParseUserHome failed")
+		return err
+	}
+	if err := buildingDb.SyncUserRecord(record); err != nil {
+		logrus.WithError(err).Error("buildingDb.SyncUserRecord failed")
+		return err
+	}
+	return nil
+}
+
+func formatDate(date time.Time) string {
+	if date.IsZero() {
+		return "-"
+	}
+	return date.In(craw.BahaTimeZone).Format(dateLayout)
+}
+
+func printNicknames(nicknames []*db.NicknameRecord) {
+	for _, nickname := range nicknames {
+		fmt.Printf("  %-16s %-24s %s ~ %s", nickname.AuthorId, nickname.Nickname,
+			formatDate(nickname.FirstSeenAt), formatDate(nickname.LastSeenAt))
+		if nickname.FirstBid != "" {
+			fmt.Printf(" (B%d ~ B%d)", nickname.FirstFloorIndex, nickname.LastFloorIndex)
+		}
+		fmt.Println()
+	}
+}
+
+// resolve prints the users who went by the nickname with every nickname of
+// theirs, and how many floors of the archive they posted.
+func resolve(buildingDb db.BuildingDB, nickname string) error {
+	users, err := buildingDb.ResolveNickname(nickname)
+	if err != nil {
+		logrus.WithError(err).Error("buildingDb.ResolveNickname failed")
+		return err
+	}
+	if len(users) == 0 {
+		fmt.Printf("Nobody went by %s, try -sync-nicknames after archiving\n", nickname)
+		return nil
+	}
+
+	for _, user := range users {
+		nicknames, err := buildingDb.GetNicknameRecords(user.AuthorId)
+		if err != nil {
+			logrus.WithError(err).Error("buildingDb.GetNicknameRecords failed")
+			return err
+		}
+		fmt.Printf("%s went by:\n", user.AuthorId)
+		printNicknames(nicknames)
+	}
+
+	floors, err := buildingDb.GetFloorRecordsByNickname(nickname)
+	if err != nil {
+		logrus.WithError(err).Error("buildingDb.GetFloorRecordsByNickname failed")
+		return err
+	}
+	fmt.Printf("\n%d floors archived\n", len(floors))
+	return nil
+}
+
+func main() {
+	authorId := flag.String("id", "", "AuthorId of the user")
+	nickname := flag.String("nickname", "", "resolve a current or past nickname to the users who went by it")
+	offline := flag.Bool("offline", false, "show the stored profile without crawling the home page")
+	syncNicknames := flag.Bool("sync-nicknames", false, "rebuild the nickname history from every archived floor and reply first")
+	flag.Parse()
+
+	if *authorId == "" && *nickname == "" && !*syncNicknames {
+		logrus.Fatal("usage: user -id <AuthorId> [-offline] | -nickname <nickname> | -sync-nicknames")
+	}
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		logrus.WithError(err).Error("buildingDb.Open failed")
+		return
+	}
+
+	if *syncNicknames {
+		count, err := buildingDb.SyncNicknameRecords(time.Now())
+		if err != nil {
+			logrus.WithError(err).Error("buildingDb.SyncNicknameRecords failed")
+			return
+		}
+		fmt.Printf("%d nicknames synced from the archive\n\n", count)
+	}
+
+	if *nickname != "" {
+		if err := resolve(buildingDb, *nickname); err != nil {
+			logrus.WithError(err).Error("resolve failed")
+		}
+		return
+	}
+	if *authorId == "" {
+		return
+	}
+
+	if !*offline {
+		if err := crawl(buildingDb, *authorId); err != nil {
+			logrus.WithError(err).Error("crawl failed")
+			return
+		}
+	}
+
+	record, err := buildingDb.GetUserRecord(*authorId)
+	switch {
+	case err == sql.ErrNoRows:
+		fmt.Printf("%s (profile not crawled yet)\n", *authorId)
+	case err != nil:
+		logrus.WithError(err).Error("buildingDb.GetUserRecord failed")
+		return
+	default:
+		fmt.Printf("%s (%s)\n", record.Nickname, record.AuthorId)
+		fmt.Printf("LV.%d %s %s, GP %d\n", record.Level, record.Race, record.Career, record.Gp)
+		fmt.Printf("Registered %s, last login %s\n", formatDate(record.RegisteredAt), formatDate(record.LastLoginAt))
+	}
+
+	nicknames, err := buildingDb.GetNicknameRecords(*authorId)
+	if err != nil {
+		logrus.WithError(err).Error("buildingDb.GetNicknameRecords failed")
+		return
+	}
+	fmt.Printf("\nNicknames:\n")
+	printNicknames(nicknames)
+
+	activities, err := buildingDb.GetUserActivityRecords(*authorId)
+	if err != nil {
+		logrus.WithError(err).Error("buildingDb.GetUserActivityRecords failed")
+		return
+	}
+	fmt.Printf("\nActivities:\n")
+	for _, activity := range activities {
+		fmt.Printf("  %s %s\n", formatDate(activity.LastSeenAt),
+			craw.TargetInfo{Bsn: activity.Bsn, Sna: activity.Sna}.GetBuildingUrl())
+		fmt.Printf("    %s\n", activity.Title)
+	}
+}
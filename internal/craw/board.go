@@ -0,0 +1,173 @@
+package craw
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	BahaBoardUrl = "https://forum.gamer.com.tw/B.php?"
+
+	// explodedGp is shown as 爆 on the thread list
+	explodedGp = 1000
+)
+
+func GetBoardPageUrl(bsn, page int) string {
+	return fmt.Sprintf("%sbsn=%d&page=%d", BahaBoardUrl, bsn, page)
+}
+
+// parseCount reads a count of the thread list such as 1,234 or 1.2萬.
+func parseCount(text string) int {
+	text = strings.ReplaceAll(strings.TrimSpace(text), ",", "")
+	if text == "爆" {
+		return explodedGp
+	}
+	if number, found := strings.CutSuffix(text, "萬"); found {
+		value, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return 0
+		}
+		return int(value * 10000)
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// parseListTime reads the time of the last reply on the thread list, which
+// is relative for recent replies: 3 分前, 2 小時前, 今日 10:30, 昨日 10:30,
+// 06/14 or 2023/06/14. The zero time is returned for anything else.
+func parseListTime(text string, now time.Time) time.Time {
+	text = strings.Join(strings.Fields(text), " ")
+	now = now.In(BahaTimeZone)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, BahaTimeZone)
+
+	if minutes, found := strings.CutSuffix(text, "分前"); found {
+		if value, err := strconv.Atoi(strings.TrimSpace(minutes)); err == nil {
+			return now.Add(-time.Duration(value) * time.Minute)
+		}
+	}
+	if hours, found := strings.CutSuffix(text, "小時前"); found {
+		if value, err := strconv.Atoi(strings.TrimSpace(hours)); err == nil {
+			return now.Add(-time.Duration(value) * time.Hour)
+		}
+	}
+
+	day, clock, found := strings.Cut(text, " ")
+	if found && (day == "今日" || day == "昨日") {
+		parsed, err := time.Parse("15:04", clock)
+		if err != nil {
+			return time.Time{}
+		}
+		if day == "昨日" {
+			midnight = midnight.AddDate(0, 0, -1)
+		}
+		return midnight.Add(time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute)
+	}
+
+	if parsed, err := time.ParseInLocation("2006/01/02", text, BahaTimeZone); err == nil {
+		return parsed
+	}
+	if parsed, err := time.ParseInLocation("01/02", text, BahaTimeZone); err == nil {
+		// the year is left out within the current year
+		parsed = parsed.AddDate(now.Year(), 0, 0)
+		if parsed.After(now) {
+			parsed = parsed.AddDate(-1, 0, 0)
+		}
+		return parsed
+	}
+	return time.Time{}
+}
+
+func parseThread(selection *goquery.Selection, bsn int, now time.Time) (*db.ThreadRecord, error) {
+	titleSelection := selection.Find(".b-list__main__title").First()
+	href, exist := titleSelection.Attr("href")
+	if !exist {
+		metrics.ParseFailures.WithLabelValues(".b-list__main__title[href]").Inc()
+		logrus.Errorf("selection.Find .b-list__main__title href not found")
+		return nil, errors.New("title link not found")
+	}
+
+	link, err := url.Parse(href)
+	if err != nil {
+		logrus.WithError(err).Errorf("url.Parse %s failed", href)
+		return nil, err
+	}
+	sna, err := strconv.Atoi(link.Query().Get("snA"))
+	if err != nil {
+		metrics.ParseFailures.WithLabelValues(".b-list__main__title[href]").Inc()
+		logrus.WithError(err).Errorf("snA of %s not found", href)
+		return nil, err
+	}
+
+	thread := &db.ThreadRecord{
+		Bsn:               bsn,
+		Sna:               sna,
+		Title:             strings.TrimSpace(titleSelection.Text()),
+		Category:          strings.TrimSpace(selection.Find(".b-list__summary__sort").First().Text()),
+		AuthorId:          strings.TrimSpace(selection.Find(".b-list__count__user a").First().Text()),
+		Sticky:            selection.HasClass("b-list__row--sticky"),
+		Gp:                parseCount(selection.Find(".b-list__summary__gp").First().Text()),
+		FloorCount:        parseCount(link.Query().Get("tnum")),
+		LastReplyAt:       parseListTime(selection.Find(".b-list__time__edittime a").First().Text(), now),
+		LastReplyAuthorId: strings.TrimSpace(selection.Find(".b-list__time__user a").First().Text()),
+	}
+
+	// 互動/人氣
+	counts := selection.Find(".b-list__count__number span")
+	thread.ReplyCount = parseCount(counts.Eq(0).Text())
+	thread.Popularity = parseCount(counts.Eq(1).Text())
+	if counts.Length() == 0 && thread.FloorCount > 0 {
+		thread.ReplyCount = thread.FloorCount - 1
+	}
+	return thread, nil
+}
+
+// ParseBoardPage reads the threads listed on a page of a board, url is a
+// B.php page such as GetBoardPageUrl gives. Deleted threads are skipped.
+func (crawler *crawler) ParseBoardPage(rawURL string) ([]*db.ThreadRecord, error) {
+	targetInfo, err := GetTargetInfoFromUrl(rawURL)
+	if err != nil {
+		logrus.WithError(err).Error("GetTargetInfoFromUrl failed")
+		return nil, err
+	}
+	if targetInfo.Bsn <= 0 {
+		return nil, fmt.Errorf("bsn of %s not found", rawURL)
+	}
+
+	doc, err := crawler.getDocumentFromUrl(rawURL)
+	if err != nil {
+		logrus.WithError(err).Error("crawler.getDocumentFromUrl failed")
+		return nil, err
+	}
+
+	now := time.Now()
+	threads := make([]*db.ThreadRecord, 0)
+	doc.Find("tr.b-list__row").Each(func(i int, s *goquery.Selection) {
+		if s.HasClass("b-list__row--delete") {
+			return
+		}
+		thread, err := parseThread(s, targetInfo.Bsn, now)
+		if err != nil {
+			return
+		}
+		threads = append(threads, thread)
+	})
+
+	if len(threads) == 0 && doc.Find("tr.b-list__row").Length() == 0 {
+		metrics.ParseFailures.WithLabelValues("tr.b-list__row").Inc()
+		logrus.Warnf("No thread found on %s", rawURL)
+	}
+	return threads, nil
+}
@@ -6,13 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/davidleitw/baha/internal/raw"
 	"github.com/go-resty/resty/v2"
 	"github.com/sirupsen/logrus"
 )
@@ -34,12 +38,40 @@ const (
 	ExtendReplyURL = "https://forum.gamer.com.tw/ajax/moreCommend.php?"
 
 	scrapingInterval = 1 * time.Second
+
+	// postTimeLayout is the layout of data-mtime on every floor header
+	postTimeLayout = "2006-01-02 15:04:05"
 )
 
+// ErrSessionExpired is returned for a page read without a login session,
+// either never logged in or sent back to the login page.
+var ErrSessionExpired = errors.New("session is not active")
+
+// ErrUnexpectedStatus is returned for a response other than 2xx, such as an
+// error or rate-limit page, which is neither stored nor parsed.
+var ErrUnexpectedStatus = errors.New("unexpected http status")
+
+// BahaTimeZone is the time zone every time shown on Baha is in.
+var BahaTimeZone = loadBahaTimeZone()
+
+func loadBahaTimeZone() *time.Location {
+	location, err := time.LoadLocation("Asia/Taipei")
+	if err != nil {
+		// Taiwan has no daylight saving time, a fixed zone is exact
+		return time.FixedZone("CST", 8*60*60)
+	}
+	return location
+}
+
 type Crawler interface {
 	LoginAndKeepCookies(account, password string) error
 
 	ParsePage(url string) (*db.PageRecord, error)
+	ParseBoardPage(url string) ([]*db.ThreadRecord, error)
+	ParseUserHome(authorId string) (*db.UserRecord, error)
+
+	FindFloorPage(targetInfo *TargetInfo, floorIndex int) (int, error)
+	FetchFloors(targetInfo *TargetInfo, from, to int) ([]*db.FloorRecord, error)
 }
 
 type crawler struct {
@@ -47,28 +79,93 @@ type crawler struct {
 
 	client *resty.Client
 	db     db.BuildingDB
+
+	// rawStore keeps every response read, or serves them when offline
+	rawStore *raw.Store
+	offline  bool
+
+	// ingested is the number of replies counted of every floor counted by
+	// the ingested metrics, by building
+	ingestedMu sync.Mutex
+	ingested   map[string]map[int]int
+}
+
+type CrawlerOption func(*crawler)
+
+// StoreRawResponses keeps every response read by the crawler under dir, so
+// the archive can be rebuilt by a newer parser without crawling again.
+func StoreRawResponses(dir string) CrawlerOption {
+	return func(crawler *crawler) {
+		crawler.rawStore = raw.NewStore(dir, crawler.db)
+	}
+}
+
+// Offline reads the latest response stored under dir for every url instead
+// of fetching it, no login is needed.
+func Offline(dir string) CrawlerOption {
+	return func(crawler *crawler) {
+		crawler.rawStore = raw.NewStore(dir, crawler.db)
+		crawler.offline = true
+	}
 }
 
-func NewCrawler() (Crawler, error) {
+// OptionsFromEnv stores raw responses under RAW_HTML_DIR when it is set.
+func OptionsFromEnv() []CrawlerOption {
+	opts := make([]CrawlerOption, 0)
+	if dir := os.Getenv("RAW_HTML_DIR"); dir != "" {
+		opts = append(opts, StoreRawResponses(dir))
+	}
+	return opts
+}
+
+func NewCrawler(opts ...CrawlerOption) (Crawler, error) {
 	db := db.NewBuildingDb()
 	if err := db.Open(); err != nil {
 		logrus.WithError(err).Error("db.Open failed")
 		return nil, err
 	}
 
-	return &crawler{
+	crawler := &crawler{
 		isSessionActive: false,
-		client:          resty.New(),
+		client:          newInstrumentedClient(),
 		db:              db,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(crawler)
+	}
+	return crawler, nil
+}
+
+// newInstrumentedClient counts every request sent to Baha by endpoint and
+// status, including those which never got a response.
+func newInstrumentedClient() *resty.Client {
+	client := resty.New()
+	client.OnAfterResponse(func(c *resty.Client, res *resty.Response) error {
+		metrics.ObserveHttpRequest(res.Request.URL, res.StatusCode(), res.Time())
+		return nil
+	})
+	client.OnError(func(req *resty.Request, err error) {
+		var resErr *resty.ResponseError
+		if errors.As(err, &resErr) {
+			// already counted by OnAfterResponse
+			return
+		}
+		metrics.ObserveHttpRequest(req.URL, 0, time.Since(req.Time))
+	})
+	return client
 }
 
 var _ Crawler = (*crawler)(nil)
 
-func (crawler *crawler) getDocumentFromUrl(url string) (*goquery.Document, error) {
-	if !crawler.isSessionActive {
-		logrus.Error("Session is not active, please use LoadAuthCookies to login")
-		return nil, fmt.Errorf("session is not active")
+// get reads the body of url from Baha, or from the raw store when offline.
+func (crawler *crawler) get(url string) ([]byte, error) {
+	if crawler.offline {
+		body, err := crawler.rawStore.LoadLatest(url)
+		if err != nil {
+			logrus.WithError(err).Errorf("rawStore.LoadLatest %s failed", url)
+			return nil, err
+		}
+		return body, nil
 	}
 
 	res, err := crawler.client.R().Get(url)
@@ -76,8 +173,36 @@ func (crawler *crawler) getDocumentFromUrl(url string) (*goquery.Document, error
 		logrus.WithError(err).Errorf("GET %s failed", url)
 		return nil, err
 	}
+	if final := res.RawResponse.Request.URL; final.String() != url && strings.HasPrefix(final.String(), LoginURLPhase1) {
+		logrus.Errorf("GET %s was redirected to the login page", url)
+		return nil, ErrSessionExpired
+	}
+	if !res.IsSuccess() {
+		logrus.Errorf("GET %s returned %s", url, res.Status())
+		return nil, fmt.Errorf("%w: GET %s returned %s", ErrUnexpectedStatus, url, res.Status())
+	}
+
+	if crawler.rawStore != nil {
+		// losing the raw copy must not lose the page
+		if _, err := crawler.rawStore.Save(url, res.Body(), time.Now()); err != nil {
+			logrus.WithError(err).Warnf("rawStore.Save %s failed", url)
+		}
+	}
+	return res.Body(), nil
+}
+
+func (crawler *crawler) getDocumentFromUrl(url string) (*goquery.Document, error) {
+	if !crawler.isSessionActive && !crawler.offline {
+		logrus.Error("Session is not active, please use LoadAuthCookies to login")
+		return nil, ErrSessionExpired
+	}
+
+	body, err := crawler.get(url)
+	if err != nil {
+		return nil, err
+	}
 
-	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(res.Body()))
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
 		logrus.WithError(err).Errorf("goquery.NewDocumentFromReader failed")
 		return nil, err
@@ -137,25 +262,28 @@ func extractExtendAPIParams(onclickValue string) (int, int, error) {
 func (crawler *crawler) parseReplyMessageExtended(selection *goquery.Selection) ([]*db.ReplyRecord, error) {
 	onclickValue, exist := selection.Find("div.nocontent>a.more-reply").Attr("onclick")
 	if !exist {
+		metrics.ParseFailures.WithLabelValues("a.more-reply").Inc()
 		logrus.Errorf("extendSelection.Find a.more-reply id not found")
 		return nil, nil
 	}
 
 	bsn, snb, err := extractExtendAPIParams(onclickValue)
 	if err != nil {
+		metrics.ParseFailures.WithLabelValues("a.more-reply[onclick]").Inc()
 		logrus.WithError(err).Errorf("getExtendRequestId failed")
 		return nil, err
 	}
 
 	extendUrl := fmt.Sprintf("%sbsn=%d&snB=%d&returnHtml=0", ExtendReplyURL, bsn, snb)
-	res, err := crawler.client.R().Get(extendUrl)
+	body, err := crawler.get(extendUrl)
 	if err != nil {
-		logrus.WithError(err).Errorf("GET %s failed", extendUrl)
+		logrus.WithError(err).Errorf("crawler.get %s failed", extendUrl)
 		return nil, err
 	}
 
 	replyRes := map[string]interface{}{}
-	if err := json.Unmarshal(res.Body(), &replyRes); err != nil {
+	if err := json.Unmarshal(body, &replyRes); err != nil {
+		metrics.ParseFailures.WithLabelValues("moreCommend.json").Inc()
 		logrus.WithError(err).Error("Failed to unmarshal JSON")
 		return nil, err
 	}
@@ -198,6 +326,7 @@ func (crawler *crawler) parseReplyMessage(selection *goquery.Selection) ([]*db.R
 		name := contentUserSelection.Text()
 		id, exist := contentUserSelection.Attr("href")
 		if !exist {
+			metrics.ParseFailures.WithLabelValues("a.reply-content__user[href]").Inc()
 			logrus.Errorf("contentUserSelection.Attr href not found")
 			return
 		}
@@ -226,6 +355,7 @@ func (crawler *crawler) parseFloor(selection *goquery.Selection) (*db.FloorRecor
 	authorSelection := mainSelection.Find("div.c-post__header__author")
 	floorIndex, exist := authorSelection.Find("a.floor").Attr("data-floor")
 	if !exist {
+		metrics.ParseFailures.WithLabelValues("a.floor[data-floor]").Inc()
 		logrus.Errorf("authorSelection.Find a.floor data-floor not found")
 		return nil, errors.New("floorIndex not found")
 	}
@@ -242,11 +372,21 @@ func (crawler *crawler) parseFloor(selection *goquery.Selection) (*db.FloorRecor
 
 	content, err := mainSelection.Find("div.c-article__content").Html()
 	if err != nil {
+		metrics.ParseFailures.WithLabelValues("div.c-article__content").Inc()
 		logrus.WithError(err).Errorf("mainSelection.Find div.c-article__content failed")
 		return nil, errors.New("content not found")
 	}
 	record.Content = content
 
+	if mtime, exist := mainSelection.Find("div.c-post__header__info a.edittime").Attr("data-mtime"); exist {
+		if postedAt, err := time.ParseInLocation(postTimeLayout, mtime, BahaTimeZone); err == nil {
+			record.PostedAt = postedAt
+		} else {
+			metrics.ParseFailures.WithLabelValues("a.edittime[data-mtime]").Inc()
+			logrus.WithError(err).Warnf("time.ParseInLocation %s failed", mtime)
+		}
+	}
+
 	replies, err := crawler.parseReplyMessage(mainSelection.Find("div.c-reply"))
 	if err != nil {
 		logrus.WithError(err).Error("crawler.parseReplyMessage failed")
@@ -267,17 +407,64 @@ func (crawler *crawler) ParsePage(url string) (*db.PageRecord, error) {
 		return record, err
 	}
 
+	// The current page is the highlighted button, a single page has none
+	record.PageIndex = 1
+	if pageNow := doc.Find("p.BH-pagebtnA>a.pagenow").First().Text(); pageNow != "" {
+		if pageIndex, err := strconv.Atoi(strings.TrimSpace(pageNow)); err == nil {
+			record.PageIndex = pageIndex
+		} else {
+			metrics.ParseFailures.WithLabelValues("a.pagenow").Inc()
+			logrus.WithError(err).Warnf("strconv.Atoi %s failed", pageNow)
+		}
+	}
+
 	doc.Find("section.c-section[id]").Each(func(i int, s *goquery.Selection) {
 		floorRecord, err := crawler.parseFloor(s)
 		if floorRecord == nil || err != nil {
 			return
 		}
+		floorRecord.PageIndex = record.PageIndex
 		record.Floors = append(record.Floors, floorRecord)
 	})
 
+	crawler.countIngested(url, record.Floors)
 	return record, nil
 }
 
+// countIngested counts the floors and replies not parsed before, the newest
+// page of a building is parsed again every poll.
+func (crawler *crawler) countIngested(url string, floors []*db.FloorRecord) {
+	targetInfo, err := GetTargetInfoFromUrl(url)
+	if err != nil {
+		return
+	}
+	key := fmt.Sprintf("%d-%d", targetInfo.Bsn, targetInfo.Sna)
+
+	crawler.ingestedMu.Lock()
+	defer crawler.ingestedMu.Unlock()
+
+	if crawler.ingested == nil {
+		crawler.ingested = make(map[string]map[int]int)
+	}
+	replies, exist := crawler.ingested[key]
+	if !exist {
+		replies = make(map[int]int)
+		crawler.ingested[key] = replies
+	}
+
+	for _, floor := range floors {
+		counted, seen := replies[floor.FloorIndex]
+		if !seen {
+			metrics.FloorsIngested.Inc()
+		}
+		if len(floor.Replies) > counted {
+			metrics.RepliesIngested.Add(float64(len(floor.Replies) - counted))
+			counted = len(floor.Replies)
+		}
+		replies[floor.FloorIndex] = counted
+	}
+}
+
 func getAlternativeCaptcha(response *resty.Response) string {
 	re := regexp.MustCompile(`<input type="hidden" name="alternativeCaptcha" value="(\w+)"`)
 	match := re.FindStringSubmatch(response.String())
@@ -289,6 +476,12 @@ func getAlternativeCaptcha(response *resty.Response) string {
 }
 
 func (crawler *crawler) LoginAndKeepCookies(account, password string) error {
+	err := crawler.login(account, password)
+	metrics.LoginAttempts.WithLabelValues(metrics.Result(err)).Inc()
+	return err
+}
+
+func (crawler *crawler) login(account, password string) error {
 	if crawler.client == nil {
 		logrus.Error("client is nil, please use NewCrawler to create a new crawler instance")
 		return fmt.Errorf("client is nil")
@@ -0,0 +1,60 @@
+package craw
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/davidleitw/baha/internal/metrics"
+)
+
+func TestParsePageStatus(t *testing.T) {
+	transport := &fixtureTransport{body: loadFixture(t, "page.html"), status: http.StatusServiceUnavailable}
+	client := resty.New()
+	client.SetTransport(transport)
+	crawler := &crawler{isSessionActive: true, client: client}
+
+	url := (&TargetInfo{Bsn: 60076, Sna: 123}).GetBuildingUrl()
+	record, err := crawler.ParsePage(url)
+	if !errors.Is(err, ErrUnexpectedStatus) {
+		t.Fatalf("err = %v, want ErrUnexpectedStatus", err)
+	}
+	if len(record.Floors) != 0 {
+		t.Errorf("floors parsed from an error page = %d", len(record.Floors))
+	}
+}
+
+func TestParsePageCountsNewFloors(t *testing.T) {
+	transport := &fixtureTransport{body: loadFixture(t, "page.html")}
+	client := resty.New()
+	client.SetTransport(transport)
+	crawler := &crawler{isSessionActive: true, client: client}
+
+	url := (&TargetInfo{Bsn: 60076, Sna: 123}).GetBuildingUrl()
+	before := testutil.ToFloat64(metrics.FloorsIngested)
+	// the newest page is parsed again on every poll
+	for i := 0; i < 3; i++ {
+		record, err := crawler.ParsePage(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.PageIndex != 2 || len(record.Floors) != 2 || record.Floors[1].AuthorId != "someone" {
+			t.Fatalf("poll %d: record = %+v", i+1, record)
+		}
+	}
+	if ingested := testutil.ToFloat64(metrics.FloorsIngested) - before; ingested != 2 {
+		t.Errorf("floors ingested = %v, want 2", ingested)
+	}
+
+	// the same floors of another building are new
+	other := (&TargetInfo{Bsn: 60076, Sna: 456}).GetBuildingUrl()
+	if _, err := crawler.ParsePage(other); err != nil {
+		t.Fatal(err)
+	}
+	if ingested := testutil.ToFloat64(metrics.FloorsIngested) - before; ingested != 4 {
+		t.Errorf("floors ingested = %v, want 4", ingested)
+	}
+}
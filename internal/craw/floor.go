@@ -0,0 +1,199 @@
+package craw
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FloorsPerPage is how many floors a page of a building holds, deleted
+	// floors keep their place on the page
+	FloorsPerPage = 20
+
+	// maxFloorPageProbes bounds the pages read to verify the page of a floor
+	maxFloorPageProbes = 5
+)
+
+// ErrFloorNotFound is returned for a floor which is deleted or beyond the
+// last floor of the building.
+var ErrFloorNotFound = errors.New("floor not found")
+
+// GetFloorPage is the page the floor is on by the layout of the building.
+func GetFloorPage(floorIndex int) int {
+	return (floorIndex-1)/FloorsPerPage + 1
+}
+
+// ParseFloorRange reads floors as they are cited, B12 for a single floor or
+// B12-B20 for a range, the B is optional.
+func ParseFloorRange(text string) (int, int, error) {
+	parseFloor := func(text string) (int, error) {
+		text = strings.TrimSpace(text)
+		text = strings.TrimPrefix(strings.TrimPrefix(text, "B"), "b")
+		floorIndex, err := strconv.Atoi(text)
+		if err != nil || floorIndex < 1 {
+			return 0, fmt.Errorf("floor %q is invalid, expect a floor such as B12", text)
+		}
+		return floorIndex, nil
+	}
+
+	fromText, toText, isRange := strings.Cut(text, "-")
+	from, err := parseFloor(fromText)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return from, from, nil
+	}
+	to, err := parseFloor(toText)
+	if err != nil {
+		return 0, 0, err
+	}
+	if to < from {
+		return 0, 0, fmt.Errorf("floor range %s is reversed", text)
+	}
+	return from, to, nil
+}
+
+// locateFloor reads the page the floor is on, or would be on if it were not
+// deleted. It starts from the page the layout gives and walks towards the
+// floor in case the layout is off.
+func (crawler *crawler) locateFloor(targetInfo *TargetInfo, floorIndex int) (*db.PageRecord, int, error) {
+	page, step := GetFloorPage(floorIndex), 0
+	for probe := 0; probe < maxFloorPageProbes; probe++ {
+		if probe > 0 {
+			time.Sleep(scrapingInterval)
+		}
+
+		record, err := crawler.ParsePage(targetInfo.GetPageUrl(page))
+		if err != nil {
+			logrus.WithError(err).Errorf("ParsePage %d failed", page)
+			return nil, 0, err
+		}
+		if len(record.Floors) == 0 {
+			return record, page, nil
+		}
+
+		first, last := record.Floors[0].FloorIndex, record.Floors[len(record.Floors)-1].FloorIndex
+		switch {
+		case floorIndex < first && page > 1 && step <= 0:
+			step = -1
+		// a page past the last one shows the last page
+		case floorIndex > last && record.PageIndex == page && step >= 0:
+			step = 1
+		default:
+			return record, page, nil
+		}
+		logrus.Infof("B%d is not on page %d (B%d ~ B%d), try page %d", floorIndex, page, first, last, page+step)
+		page += step
+	}
+	return nil, 0, fmt.Errorf("page of B%d not found within %d pages", floorIndex, maxFloorPageProbes)
+}
+
+// FindFloorPage returns the page of the building the floor is on, verified
+// by reading it.
+func (crawler *crawler) FindFloorPage(targetInfo *TargetInfo, floorIndex int) (int, error) {
+	if err := targetInfo.validate(); err != nil {
+		return 0, err
+	}
+
+	record, _, err := crawler.locateFloor(targetInfo, floorIndex)
+	if err != nil {
+		return 0, err
+	}
+	for _, floor := range record.Floors {
+		if floor.FloorIndex == floorIndex {
+			return record.PageIndex, nil
+		}
+	}
+	return 0, ErrFloorNotFound
+}
+
+// FetchFloors reads the floors from ~ to of the building with their replies
+// and archives them, deleted floors are left out.
+func (crawler *crawler) FetchFloors(targetInfo *TargetInfo, from, to int) ([]*db.FloorRecord, error) {
+	if err := targetInfo.validate(); err != nil {
+		return nil, err
+	}
+	if from < 1 || to < from {
+		return nil, fmt.Errorf("floor range B%d ~ B%d is invalid", from, to)
+	}
+
+	record, page, err := crawler.locateFloor(targetInfo, from)
+	if err != nil {
+		logrus.WithError(err).Error("crawler.locateFloor failed")
+		return nil, err
+	}
+
+	floors := make([]*db.FloorRecord, 0)
+	for {
+		for _, floor := range record.Floors {
+			if floor.FloorIndex >= from && floor.FloorIndex <= to {
+				floors = append(floors, floor)
+			}
+		}
+
+		// past the last page or the last floor of the range
+		if record.PageIndex != page {
+			break
+		}
+		if len(record.Floors) > 0 && record.Floors[len(record.Floors)-1].FloorIndex >= to {
+			break
+		}
+
+		page++
+		time.Sleep(scrapingInterval)
+		if record, err = crawler.ParsePage(targetInfo.GetPageUrl(page)); err != nil {
+			logrus.WithError(err).Errorf("ParsePage %d failed", page)
+			return nil, err
+		}
+	}
+
+	if err := crawler.archiveFloors(targetInfo, floors); err != nil {
+		logrus.WithError(err).Error("crawler.archiveFloors failed")
+		return nil, err
+	}
+	return floors, nil
+}
+
+func (crawler *crawler) archiveFloors(targetInfo *TargetInfo, floors []*db.FloorRecord) error {
+	now := time.Now()
+	pages := make(map[int]*db.PageRecord)
+	for _, floor := range floors {
+		page, exist := pages[floor.PageIndex]
+		if !exist {
+			var err error
+			if page, err = crawler.db.SyncPageRecord(targetInfo.Bsn, targetInfo.Sna, floor.PageIndex); err != nil {
+				logrus.WithError(err).Errorf("db.SyncPageRecord of page %d failed", floor.PageIndex)
+				return err
+			}
+			pages[floor.PageIndex] = page
+		}
+
+		record := *floor
+		record.Bid, record.Pid = page.Bid, page.Pid
+		if _, err := crawler.db.SyncFloorRecord(&record, now); err != nil {
+			logrus.WithError(err).Errorf("db.SyncFloorRecord of B%d failed", floor.FloorIndex)
+			return err
+		}
+		floor.Bid, floor.Pid, floor.Fid = record.Bid, record.Pid, record.Fid
+
+		for _, reply := range floor.Replies {
+			reply.Fid = record.Fid
+			if err := crawler.db.SyncReplyRecord(reply); err != nil {
+				logrus.WithError(err).Errorf("db.SyncReplyRecord under B%d failed", floor.FloorIndex)
+				return err
+			}
+		}
+		if err := crawler.db.SaveFloorNicknames(&record, now); err != nil {
+			logrus.WithError(err).Errorf("db.SaveFloorNicknames of B%d failed", floor.FloorIndex)
+			return err
+		}
+	}
+	return nil
+}
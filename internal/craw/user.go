@@ -0,0 +1,188 @@
+package craw
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const BahaHomeUrl = "https://home.gamer.com.tw/homeindex.php?owner="
+
+// ErrUserNotFound is returned for a home page without a profile, the user
+// does not exist or hides it.
+var ErrUserNotFound = errors.New("user profile not found")
+
+func GetUserHomeUrl(authorId string) string {
+	return BahaHomeUrl + url.QueryEscape(authorId)
+}
+
+// userLabels are the labels of the profile fields on a home page, listed
+// as 暱稱：名字 or with the label in its own element.
+var userLabels = []string{"帳號", "暱稱", "等級", "種族", "職業", "GP", "註冊日期", "上站日期"}
+
+// profileContainers hold the profile box of a home page, the side column
+// first. Lists elsewhere on the page, such as the friends or the comments
+// of a post, may have items which look like profile fields.
+var profileContainers = []string{"#BH-slave", "body"}
+
+// findProfileItems returns the items of the first list with an 帳號 or 暱稱
+// item, the profile of the owner of the home page.
+func findProfileItems(doc *goquery.Document) *goquery.Selection {
+	for _, container := range profileContainers {
+		var items *goquery.Selection
+		doc.Find(container).Find("ul").EachWithBreak(func(i int, list *goquery.Selection) bool {
+			list.ChildrenFiltered("li").EachWithBreak(func(j int, item *goquery.Selection) bool {
+				label, _, ok := parseProfileItem(item.Text())
+				if ok && (label == "帳號" || label == "暱稱") {
+					items = list.ChildrenFiltered("li")
+				}
+				return items == nil
+			})
+			return items == nil
+		})
+		if items != nil {
+			return items
+		}
+	}
+	return nil
+}
+
+// parseProfileItem splits a profile item into its label and value.
+func parseProfileItem(text string) (string, string, bool) {
+	text = strings.Join(strings.Fields(text), " ")
+	for _, label := range userLabels {
+		if value, found := strings.CutPrefix(text, label); found {
+			value = strings.TrimLeft(value, "：: ")
+			return label, strings.TrimSpace(value), value != ""
+		}
+	}
+	return "", "", false
+}
+
+func parseProfileDate(text string) time.Time {
+	text = strings.ReplaceAll(text, "/", "-")
+	if len(text) > len("2006-01-02") {
+		text = text[:len("2006-01-02")]
+	}
+	date, err := time.ParseInLocation("2006-01-02", text, BahaTimeZone)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+func parseLevel(text string) int {
+	text = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(text)), "LV.")
+	level, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil {
+		return 0
+	}
+	return level
+}
+
+// parseUserActivities reads the buildings the home page links to, the
+// first link of a building gives its title.
+func parseUserActivities(doc *goquery.Document) []*db.UserActivityRecord {
+	activities := make([]*db.UserActivityRecord, 0)
+	seen := make(map[TargetInfo]bool)
+	doc.Find(`a[href*="forum.gamer.com.tw/C.php"]`).Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		link, err := url.Parse(href)
+		if err != nil {
+			return
+		}
+		bsn, errBsn := strconv.Atoi(link.Query().Get("bsn"))
+		sna, errSna := strconv.Atoi(link.Query().Get("snA"))
+		if errBsn != nil || errSna != nil {
+			return
+		}
+
+		target := TargetInfo{Bsn: bsn, Sna: sna}
+		title := strings.TrimSpace(s.Text())
+		if seen[target] || title == "" {
+			return
+		}
+		seen[target] = true
+		activities = append(activities, &db.UserActivityRecord{Bsn: bsn, Sna: sna, Title: title})
+	})
+	return activities
+}
+
+// ParseUserHome reads the public profile of the user from their home page,
+// together with the forum buildings it links to as recent activity.
+func (crawler *crawler) ParseUserHome(authorId string) (*db.UserRecord, error) {
+	if authorId == "" {
+		return nil, fmt.Errorf("authorId is empty")
+	}
+
+	doc, err := crawler.getDocumentFromUrl(GetUserHomeUrl(authorId))
+	if err != nil {
+		logrus.WithError(err).Error("crawler.getDocumentFromUrl failed")
+		return nil, err
+	}
+
+	record, err := parseUserProfile(doc, authorId)
+	if err != nil {
+		return nil, err
+	}
+	record.Activities = parseUserActivities(doc)
+	return record, nil
+}
+
+// parseUserProfile reads the profile box of a home page, the first item of
+// a label wins.
+func parseUserProfile(doc *goquery.Document, authorId string) (*db.UserRecord, error) {
+	record := &db.UserRecord{AuthorId: authorId, UpdatedAt: time.Now()}
+	items := findProfileItems(doc)
+	if items == nil {
+		metrics.ParseFailures.WithLabelValues("home profile").Inc()
+		logrus.Warnf("No profile found on the home page of %s", authorId)
+		return nil, ErrUserNotFound
+	}
+
+	parsed := make(map[string]bool)
+	items.Each(func(i int, s *goquery.Selection) {
+		label, value, ok := parseProfileItem(s.Text())
+		if !ok || parsed[label] {
+			return
+		}
+		parsed[label] = true
+
+		switch label {
+		case "帳號":
+			// keep the case the user registered with
+			if strings.EqualFold(value, authorId) {
+				record.AuthorId = value
+			}
+		case "暱稱":
+			record.Nickname = value
+		case "等級":
+			record.Level = parseLevel(value)
+		case "種族":
+			record.Race = value
+		case "職業":
+			record.Career = value
+		case "GP":
+			record.Gp = parseCount(value)
+		case "註冊日期":
+			record.RegisteredAt = parseProfileDate(value)
+		case "上站日期":
+			record.LastLoginAt = parseProfileDate(value)
+		}
+	})
+	// every user has a nickname, the other fields may be private
+	if record.Nickname == "" {
+		metrics.ParseFailures.WithLabelValues("home profile").Inc()
+		logrus.Warnf("No profile found on the home page of %s", authorId)
+		return nil, ErrUserNotFound
+	}
+	return record, nil
+}
@@ -0,0 +1,113 @@
+package craw
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-resty/resty/v2"
+)
+
+func loadFixture(t *testing.T, name string) []byte {
+	body, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// fixtureTransport answers every request with body and status, 200 if unset,
+// and keeps the url of the last one.
+type fixtureTransport struct {
+	body   []byte
+	status int
+	url    string
+}
+
+func (transport *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.url = req.URL.String()
+	status := transport.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:       io.NopCloser(bytes.NewReader(transport.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestParseUserHome(t *testing.T) {
+	transport := &fixtureTransport{body: loadFixture(t, "home.html")}
+	client := resty.New()
+	client.SetTransport(transport)
+	crawler := &crawler{isSessionActive: true, client: client}
+
+	record, err := crawler.ParseUserHome("bahauser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.url != GetUserHomeUrl("bahauser") {
+		t.Errorf("fetched %s", transport.url)
+	}
+
+	// the profile box only, not the nav bar, guestbook or friends
+	if record.AuthorId != "BahaUser" || record.Nickname != "巴哈勇者" {
+		t.Errorf("id and nickname = %s, %s", record.AuthorId, record.Nickname)
+	}
+	if record.Level != 35 || record.Race != "人類" || record.Career != "騎士" {
+		t.Errorf("level, race and career = %d, %s, %s", record.Level, record.Race, record.Career)
+	}
+	if record.Gp != 1234 {
+		t.Errorf("gp = %d, the first GP item wins", record.Gp)
+	}
+	if want := time.Date(2010, 3, 15, 0, 0, 0, 0, BahaTimeZone); !record.RegisteredAt.Equal(want) {
+		t.Errorf("registered at = %s", record.RegisteredAt)
+	}
+	if want := time.Date(2024, 5, 1, 0, 0, 0, 0, BahaTimeZone); !record.LastLoginAt.Equal(want) {
+		t.Errorf("last login at = %s", record.LastLoginAt)
+	}
+	if len(record.Activities) != 2 {
+		t.Errorf("got %d activities", len(record.Activities))
+	}
+}
+
+func TestParseUserHomeWithoutProfile(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader([]byte(
+		`<html><body><ul class="TOP-nav"><li>巴哈姆特</li></ul></body></html>`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseUserProfile(doc, "nobody"); err != ErrUserNotFound {
+		t.Errorf("err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestParseUserActivities(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(loadFixture(t, "home.html")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activities := parseUserActivities(doc)
+	want := []struct {
+		bsn, sna int
+		title    string
+	}{
+		{60076, 8812345, "【心得】新版本的整理"},
+		{23805, 654321, "【問題】有人知道這個任務嗎"},
+	}
+	if len(activities) != len(want) {
+		t.Fatalf("got %d activities, want %d", len(activities), len(want))
+	}
+	for i, activity := range activities {
+		if activity.Bsn != want[i].bsn || activity.Sna != want[i].sna || activity.Title != want[i].title {
+			t.Errorf("activity %d = %d/%d %s", i, activity.Bsn, activity.Sna, activity.Title)
+		}
+	}
+}
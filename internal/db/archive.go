@@ -0,0 +1,235 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveDB keeps the floors seen by a running monitor in the archive, the
+// ids of new buildings, pages and floors are generated here.
+type ArchiveDB interface {
+	SyncPageRecord(bsn, sna, pageIndex int) (*PageRecord, error)
+	SyncFloorRecord(record *FloorRecord, observedAt time.Time) (bool, error)
+	GetFloorRevisionRecords(fid string) ([]*FloorRevisionRecord, error)
+
+	RebuildFloorRecord(record *FloorRecord) error
+	RebuildReplyRecord(record *ReplyRecord) error
+}
+
+// SyncPageRecord returns the page of the building, creating the building
+// and the page when they are not archived yet.
+func (db *BuildingDb) SyncPageRecord(bsn, sna, pageIndex int) (*PageRecord, error) {
+	building, err := db.GetBuildingRecord(bsn, sna)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("GetBuildingRecord failed")
+			return nil, err
+		}
+
+		// the title is filled by the next full crawl of the building
+		building = &BuildingRecord{Id: uuid.NewString(), Bsn: bsn, Sna: sna, LastPageIndex: pageIndex}
+		if err := db.CreateBuildingRecord(building); err != nil {
+			logrus.WithError(err).Error("CreateBuildingRecord failed")
+			return nil, err
+		}
+	} else if building.LastPageIndex < pageIndex {
+		building.LastPageIndex = pageIndex
+		if err := db.UpdateBuildingRecord(building); err != nil {
+			logrus.WithError(err).Error("UpdateBuildingRecord failed")
+			return nil, err
+		}
+	}
+
+	page, err := db.GetPageRecord(building.Id, pageIndex)
+	if err == nil {
+		page.Bid = building.Id
+		page.PageIndex = pageIndex
+		return page, nil
+	}
+	if err != sql.ErrNoRows {
+		logrus.WithError(err).Error("GetPageRecord failed")
+		return nil, err
+	}
+
+	page = &PageRecord{Bid: building.Id, Pid: uuid.NewString(), PageIndex: pageIndex}
+	if err := db.CreatePageRecord(page); err != nil {
+		logrus.WithError(err).Error("CreatePageRecord failed")
+		return nil, err
+	}
+	return page, nil
+}
+
+func (db *BuildingDb) getLastFloorRevision(fid string) (int, error) {
+	query := `SELECT COALESCE(MAX(revision), 0) FROM floor_revision_record WHERE fid = ?;`
+
+	var revision int
+	if err := db.driver.QueryRow(query, fid).Scan(&revision); err != nil {
+		logrus.WithError(err).Error("db.driver.QueryRow.Scan failed")
+		return 0, err
+	}
+	return revision, nil
+}
+
+func (db *BuildingDb) createFloorRevisionRecord(record *FloorRevisionRecord) error {
+	defer metrics.ObserveDbWrite("create_floor_revision_record", time.Now())
+
+	stat := `INSERT INTO floor_revision_record (fid, revision, content, observed_at) VALUES (?, ?, ?, ?);`
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.Fid, record.Revision,
+		record.Content, nullableUnix(record.ObservedAt)); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
+
+// SyncFloorRecord archives a floor of the page record.Bid and record.Pid
+// point at, record.Fid is filled with the id of the archived floor. A new
+// content of an archived floor is kept as a new revision, it reports
+// whether the floor was edited since it was last archived.
+func (db *BuildingDb) SyncFloorRecord(record *FloorRecord, observedAt time.Time) (bool, error) {
+	archived, err := db.GetFloorRecord(record.Bid, record.FloorIndex)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("GetFloorRecord failed")
+			return false, err
+		}
+
+		record.Fid = uuid.NewString()
+		if err := db.CreateFloorRecord(record); err != nil {
+			logrus.WithError(err).Error("CreateFloorRecord failed")
+			return false, err
+		}
+		if err := db.createFloorRevisionRecord(&FloorRevisionRecord{
+			Fid: record.Fid, Revision: 1, Content: record.Content, ObservedAt: observedAt,
+		}); err != nil {
+			logrus.WithError(err).Error("createFloorRevisionRecord failed")
+			return false, err
+		}
+		return false, nil
+	}
+
+	// the floor keeps the page it was first archived under
+	record.Fid, record.Pid = archived.Fid, archived.Pid
+	if archived.Content == record.Content {
+		return false, nil
+	}
+
+	revision, err := db.getLastFloorRevision(record.Fid)
+	if err != nil {
+		logrus.WithError(err).Error("getLastFloorRevision failed")
+		return false, err
+	}
+	if revision == 0 {
+		// archived by a crawl before revisions were kept, when it was
+		// seen is unknown
+		revision++
+		if err := db.createFloorRevisionRecord(&FloorRevisionRecord{
+			Fid: record.Fid, Revision: revision, Content: archived.Content,
+		}); err != nil {
+			logrus.WithError(err).Error("createFloorRevisionRecord failed")
+			return false, err
+		}
+	}
+
+	if err := db.createFloorRevisionRecord(&FloorRevisionRecord{
+		Fid: record.Fid, Revision: revision + 1, Content: record.Content, ObservedAt: observedAt,
+	}); err != nil {
+		logrus.WithError(err).Error("createFloorRevisionRecord failed")
+		return false, err
+	}
+	if err := db.UpdateFloorRecordContent(record.Fid, record.Content); err != nil {
+		logrus.WithError(err).Error("UpdateFloorRecordContent failed")
+		return false, err
+	}
+	return true, nil
+}
+
+// GetFloorRevisionRecords returns every archived content of the floor,
+// oldest first.
+func (db *BuildingDb) GetFloorRevisionRecords(fid string) ([]*FloorRevisionRecord, error) {
+	query := `SELECT revision, content, observed_at FROM floor_revision_record WHERE fid = ? ORDER BY revision;`
+
+	rows, err := db.driver.Query(query, fid)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*FloorRevisionRecord, 0)
+	for rows.Next() {
+		var observedAt sql.NullInt64
+		record := &FloorRevisionRecord{Fid: fid}
+		if err := rows.Scan(&record.Revision, &record.Content, &observedAt); err != nil {
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		record.ObservedAt = timeFromNullableUnix(observedAt)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// RebuildFloorRecord replaces every parsed field of an archived floor with
+// record, a floor parsed again from the same page by a newer parser. It is
+// not an edit, no revision is kept. record.Fid is filled like
+// SyncFloorRecord does.
+func (db *BuildingDb) RebuildFloorRecord(record *FloorRecord) error {
+	archived, err := db.GetFloorRecord(record.Bid, record.FloorIndex)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("GetFloorRecord failed")
+			return err
+		}
+
+		record.Fid = uuid.NewString()
+		if err := db.CreateFloorRecord(record); err != nil {
+			logrus.WithError(err).Error("CreateFloorRecord failed")
+			return err
+		}
+		return nil
+	}
+
+	defer metrics.ObserveDbWrite("rebuild_floor_record", time.Now())
+
+	record.Fid, record.Pid = archived.Fid, archived.Pid
+	stat := `UPDATE floor_record SET author_name = ?, author_id = ?, content = ?, posted_at = ? WHERE fid = ?;`
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.AuthorName, record.AuthorId, record.Content,
+		nullableUnix(record.PostedAt), record.Fid); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
+
+// RebuildReplyRecord replaces the archived reply like RebuildFloorRecord,
+// when the reply was seen and edited is kept.
+func (db *BuildingDb) RebuildReplyRecord(record *ReplyRecord) error {
+	defer metrics.ObserveDbWrite("rebuild_reply_record", time.Now())
+
+	stat := `INSERT INTO reply_record (fid, reply_index, author_name, author_id, content) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (fid, reply_index) DO UPDATE SET
+			author_name = excluded.author_name, author_id = excluded.author_id, content = excluded.content;`
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.Fid, record.ReplyIndex,
+		record.AuthorName, record.AuthorId, record.Content); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
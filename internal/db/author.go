@@ -0,0 +1,307 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// AuthorDB keeps every nickname an AuthorId has been seen with, on its home
+// page or on the floors and replies of the archive, so a nickname, current
+// or past, resolves to the users who went by it.
+type AuthorDB interface {
+	SaveNicknameRecord(record *NicknameRecord) error
+	SaveFloorNicknames(floor *FloorRecord, observedAt time.Time) error
+	SyncNicknameRecords(observedAt time.Time) (int, error)
+
+	GetNicknameRecords(authorId string) ([]*NicknameRecord, error)
+	ResolveNickname(nickname string) ([]*NicknameRecord, error)
+	GetFloorRecordsByNickname(nickname string) ([]*FloorRecord, error)
+}
+
+const nicknameColumns = `author_id, nickname, first_seen_at, last_seen_at,
+	first_bid, first_floor_index, last_bid, last_floor_index`
+
+// nicknameUpsert merges a row into the history of its nickname, widening the
+// first and last seen time. The first and last floor move along with the
+// time, a row without floor keeps the floor recorded.
+const nicknameUpsert = `ON CONFLICT (author_id, nickname) DO UPDATE SET
+	first_bid = CASE WHEN excluded.first_bid IS NOT NULL AND (first_bid IS NULL OR excluded.first_seen_at < first_seen_at)
+		THEN excluded.first_bid ELSE first_bid END,
+	first_floor_index = CASE WHEN excluded.first_bid IS NOT NULL AND (first_bid IS NULL OR excluded.first_seen_at < first_seen_at)
+		THEN excluded.first_floor_index ELSE first_floor_index END,
+	last_bid = CASE WHEN excluded.last_bid IS NOT NULL AND (last_bid IS NULL OR excluded.last_seen_at >= last_seen_at)
+		THEN excluded.last_bid ELSE last_bid END,
+	last_floor_index = CASE WHEN excluded.last_bid IS NOT NULL AND (last_bid IS NULL OR excluded.last_seen_at >= last_seen_at)
+		THEN excluded.last_floor_index ELSE last_floor_index END,
+	first_seen_at = MIN(first_seen_at, excluded.first_seen_at),
+	last_seen_at = MAX(last_seen_at, excluded.last_seen_at)`
+
+func nullableString(text string) sql.NullString {
+	return sql.NullString{String: text, Valid: text != ""}
+}
+
+func scanNicknameRecord(scanner interface{ Scan(...any) error }) (*NicknameRecord, error) {
+	var (
+		record                  NicknameRecord
+		firstSeenAt, lastSeenAt int64
+		firstBid, lastBid       sql.NullString
+		firstFloor, lastFloor   sql.NullInt64
+	)
+	if err := scanner.Scan(
+		&record.AuthorId, &record.Nickname, &firstSeenAt, &lastSeenAt,
+		&firstBid, &firstFloor, &lastBid, &lastFloor); err != nil {
+		return nil, err
+	}
+
+	record.FirstSeenAt = time.Unix(firstSeenAt, 0)
+	record.LastSeenAt = time.Unix(lastSeenAt, 0)
+	record.FirstBid, record.FirstFloorIndex = firstBid.String, int(firstFloor.Int64)
+	record.LastBid, record.LastFloorIndex = lastBid.String, int(lastFloor.Int64)
+	return &record, nil
+}
+
+// SaveNicknameRecord merges a sighting of the nickname into its history,
+// see nicknameUpsert.
+func (db *BuildingDb) SaveNicknameRecord(record *NicknameRecord) error {
+	defer metrics.ObserveDbWrite("save_nickname_record", time.Now())
+
+	stat := `INSERT INTO user_nickname_record (` + nicknameColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		` + nicknameUpsert + `;`
+
+	// ids are case-insensitive on Baha, reply links give them lower-cased
+	// while floors keep the case the user registered with
+	if _, err := db.driver.Exec(
+		stat,
+		strings.ToLower(record.AuthorId), record.Nickname,
+		record.FirstSeenAt.Unix(), record.LastSeenAt.Unix(),
+		nullableString(record.FirstBid), record.FirstFloorIndex,
+		nullableString(record.LastBid), record.LastFloorIndex); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
+
+// nicknameSighting is a nickname seen on a floor, at the post time of the
+// floor, or when it was observed for a floor without one.
+type nicknameSighting struct {
+	bid        string
+	floorIndex int
+	seenAt     time.Time
+}
+
+func (sighting nicknameSighting) before(other nicknameSighting) bool {
+	if !sighting.seenAt.Equal(other.seenAt) {
+		return sighting.seenAt.Before(other.seenAt)
+	}
+	return sighting.bid == other.bid && sighting.floorIndex < other.floorIndex
+}
+
+func newNicknameSighting(bid string, floorIndex int, postedAt sql.NullInt64, observedAt time.Time) nicknameSighting {
+	sighting := nicknameSighting{bid: bid, floorIndex: floorIndex, seenAt: observedAt}
+	if postedAt.Valid {
+		sighting.seenAt = time.Unix(postedAt.Int64, 0)
+	}
+	return sighting
+}
+
+// nicknameHistory folds sightings into one record per AuthorId and nickname.
+type nicknameHistory map[[2]string]*NicknameRecord
+
+func (history nicknameHistory) add(authorId, nickname string, sighting nicknameSighting) {
+	authorId, nickname = strings.TrimSpace(authorId), strings.TrimSpace(nickname)
+	if authorId == "" || nickname == "" {
+		return
+	}
+
+	record, exist := history[[2]string{authorId, nickname}]
+	if !exist {
+		history[[2]string{authorId, nickname}] = &NicknameRecord{
+			AuthorId: authorId, Nickname: nickname,
+			FirstSeenAt: sighting.seenAt, FirstBid: sighting.bid, FirstFloorIndex: sighting.floorIndex,
+			LastSeenAt: sighting.seenAt, LastBid: sighting.bid, LastFloorIndex: sighting.floorIndex,
+		}
+		return
+	}
+
+	first := nicknameSighting{bid: record.FirstBid, floorIndex: record.FirstFloorIndex, seenAt: record.FirstSeenAt}
+	if sighting.before(first) {
+		record.FirstSeenAt, record.FirstBid, record.FirstFloorIndex = sighting.seenAt, sighting.bid, sighting.floorIndex
+	}
+	last := nicknameSighting{bid: record.LastBid, floorIndex: record.LastFloorIndex, seenAt: record.LastSeenAt}
+	if last.before(sighting) {
+		record.LastSeenAt, record.LastBid, record.LastFloorIndex = sighting.seenAt, sighting.bid, sighting.floorIndex
+	}
+}
+
+// lowerNicknameAuthorIds merges the rows saved under the registered case of
+// an id, by builds which did not lower-case it yet, into the lower-cased
+// row, so every id has a single history.
+func (db *BuildingDb) lowerNicknameAuthorIds() error {
+	tx, err := db.driver.Begin()
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Begin failed")
+		return err
+	}
+	defer tx.Rollback()
+
+	merge := `INSERT INTO user_nickname_record (` + nicknameColumns + `)
+		SELECT lower(author_id), nickname, first_seen_at, last_seen_at,
+			first_bid, first_floor_index, last_bid, last_floor_index
+		FROM user_nickname_record WHERE author_id != lower(author_id)
+		` + nicknameUpsert + `;`
+	if _, err := tx.Exec(merge); err != nil {
+		logrus.WithError(err).Error("tx.Exec failed")
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM user_nickname_record WHERE author_id != lower(author_id);`)
+	if err != nil {
+		logrus.WithError(err).Error("tx.Exec failed")
+		return err
+	}
+	if merged, _ := result.RowsAffected(); merged > 0 {
+		logrus.Infof("Merge %d nickname records into lower-cased ids", merged)
+	}
+	return tx.Commit()
+}
+
+func (db *BuildingDb) saveNicknameHistory(history nicknameHistory) error {
+	for _, record := range history {
+		if err := db.SaveNicknameRecord(record); err != nil {
+			logrus.WithError(err).Error("SaveNicknameRecord failed")
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveFloorNicknames records the nicknames of the author and the repliers
+// of an archived floor, record.Bid must be set. Replies count as seen at
+// the post time of their floor.
+func (db *BuildingDb) SaveFloorNicknames(floor *FloorRecord, observedAt time.Time) error {
+	sighting := newNicknameSighting(floor.Bid, floor.FloorIndex, nullableUnix(floor.PostedAt), observedAt)
+
+	history := make(nicknameHistory)
+	history.add(floor.AuthorId, floor.AuthorName, sighting)
+	for _, reply := range floor.Replies {
+		history.add(reply.AuthorId, reply.AuthorName, sighting)
+	}
+	return db.saveNicknameHistory(history)
+}
+
+// SyncNicknameRecords rebuilds the nickname history from every floor and
+// reply of the archive and returns how many nicknames it holds. Floors
+// archived without a post time count as seen at observedAt.
+func (db *BuildingDb) SyncNicknameRecords(observedAt time.Time) (int, error) {
+	queries := []string{
+		`SELECT bid, floor_index, author_id, author_name, posted_at FROM floor_record;`,
+		`SELECT f.bid, f.floor_index, r.author_id, r.author_name, f.posted_at
+			FROM reply_record r JOIN floor_record f ON r.fid = f.fid;`,
+	}
+
+	history := make(nicknameHistory)
+	for _, query := range queries {
+		rows, err := db.driver.Query(query)
+		if err != nil {
+			logrus.WithError(err).Error("db.driver.Query failed")
+			return 0, err
+		}
+
+		for rows.Next() {
+			var (
+				bid, authorId, authorName string
+				floorIndex                int
+				postedAt                  sql.NullInt64
+			)
+			if err := rows.Scan(&bid, &floorIndex, &authorId, &authorName, &postedAt); err != nil {
+				logrus.WithError(err).Error("rows.Scan failed")
+				rows.Close()
+				return 0, err
+			}
+			history.add(authorId, authorName, newNicknameSighting(bid, floorIndex, postedAt, observedAt))
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			logrus.WithError(err).Error("rows.Err failed")
+			return 0, err
+		}
+	}
+
+	if err := db.saveNicknameHistory(history); err != nil {
+		return 0, err
+	}
+	return len(history), nil
+}
+
+// GetNicknameRecords returns the nicknames of the user, the earliest first.
+func (db *BuildingDb) GetNicknameRecords(authorId string) ([]*NicknameRecord, error) {
+	query := `SELECT ` + nicknameColumns + ` FROM user_nickname_record
+		WHERE author_id = ? COLLATE NOCASE ORDER BY first_seen_at, last_seen_at;`
+	return db.queryNicknameRecords(query, authorId)
+}
+
+// ResolveNickname returns every user who went by the nickname, matched
+// case-insensitively, the most recently seen first.
+func (db *BuildingDb) ResolveNickname(nickname string) ([]*NicknameRecord, error) {
+	query := `SELECT ` + nicknameColumns + ` FROM user_nickname_record
+		WHERE nickname = ? COLLATE NOCASE ORDER BY last_seen_at DESC;`
+	return db.queryNicknameRecords(query, strings.TrimSpace(nickname))
+}
+
+func (db *BuildingDb) queryNicknameRecords(query string, args ...any) ([]*NicknameRecord, error) {
+	rows, err := db.driver.Query(query, args...)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*NicknameRecord, 0)
+	for rows.Next() {
+		record, err := scanNicknameRecord(rows)
+		if err != nil {
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// GetFloorRecordsByNickname returns the floors of every building posted by
+// the users who went by the nickname, under any of their nicknames.
+func (db *BuildingDb) GetFloorRecordsByNickname(nickname string) ([]*FloorRecord, error) {
+	query := `SELECT bid, pid, fid, floor_index, author_name, author_id, content, posted_at FROM floor_record
+		WHERE author_id COLLATE NOCASE IN (SELECT author_id FROM user_nickname_record WHERE nickname = ? COLLATE NOCASE)
+		ORDER BY bid, floor_index;`
+
+	rows, err := db.driver.Query(query, strings.TrimSpace(nickname))
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*FloorRecord, 0)
+	for rows.Next() {
+		var postedAt sql.NullInt64
+		record := &FloorRecord{}
+		if err := rows.Scan(
+			&record.Bid, &record.Pid, &record.Fid, &record.FloorIndex,
+			&record.AuthorName, &record.AuthorId, &record.Content, &postedAt); err != nil {
+
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		record.PostedAt = timeFromNullableUnix(postedAt)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
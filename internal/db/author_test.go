@@ -0,0 +1,62 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLowerNicknameAuthorIds(t *testing.T) {
+	db := openTestDb(t)
+
+	// rows saved by SyncUserRecord under the registered case, next to the
+	// lower-cased row of the same nickname saved from a floor
+	insert := `INSERT INTO user_nickname_record (` + nicknameColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
+	rows := [][]any{
+		{"Baha", "巴哈", 100, 200, nil, nil, nil, nil},
+		{"baha", "巴哈", 150, 300, "bid", 3, "bid", 9},
+		{"BAHA", "舊名", 50, 60, nil, nil, nil, nil},
+		{"other", "巴哈", 10, 20, nil, nil, nil, nil},
+	}
+	for _, row := range rows {
+		if _, err := db.driver.Exec(insert, row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.lowerNicknameAuthorIds(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := db.GetNicknameRecords("BaHa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	old, current := records[0], records[1]
+	if old.AuthorId != "baha" || old.Nickname != "舊名" {
+		t.Errorf("old = %+v", old)
+	}
+	if current.AuthorId != "baha" || current.FirstSeenAt.Unix() != 100 || current.LastSeenAt.Unix() != 300 {
+		t.Errorf("merged = %+v", current)
+	}
+	if current.FirstBid != "bid" || current.FirstFloorIndex != 3 || current.LastFloorIndex != 9 {
+		t.Errorf("floors of merged = %+v", current)
+	}
+
+	// a later save under the registered case lands on the same row
+	if err := db.SaveNicknameRecord(&NicknameRecord{
+		AuthorId: "Baha", Nickname: "巴哈",
+		FirstSeenAt: time.Unix(400, 0), LastSeenAt: time.Unix(400, 0),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	records, err = db.ResolveNickname("巴哈")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].AuthorId != "baha" || records[0].LastSeenAt.Unix() != 400 {
+		t.Errorf("resolved = %+v", records)
+	}
+}
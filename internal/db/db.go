@@ -2,9 +2,12 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/davidleitw/baha/internal/metrics"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 )
@@ -28,6 +31,16 @@ type BuildingDB interface {
 	GetBuildingRecord(bsn, sna int) (*BuildingRecord, error)
 	UpdateBuildingRecord(record *BuildingRecord) error
 	CreateBuildingRecord(record *BuildingRecord) error
+
+	UsageDB
+	ProfileDB
+	DigestDB
+	MonitorDB
+	ArchiveDB
+	ThreadDB
+	UserDB
+	AuthorDB
+	RawDB
 }
 
 type BuildingDb struct {
@@ -46,6 +59,8 @@ var (
 			author_name TEXT NOT NULL,
 			author_id TEXT NOT NULL,
 			content TEXT NOT NULL,
+			observed_at INTEGER,
+			edited_at INTEGER,
 			PRIMARY KEY (fid, reply_index)
 		);`,
 		`CREATE TABLE IF NOT EXISTS floor_record (
@@ -56,6 +71,7 @@ var (
 			author_name TEXT NOT NULL,
 			author_id TEXT NOT NULL,
 			content TEXT NOT NULL,
+			posted_at INTEGER,
 			PRIMARY KEY (bid, pid, fid),
 			FOREIGN KEY (bid) REFERENCES building_record(id)
 		);`,
@@ -73,9 +89,160 @@ var (
 			building_title TEXT NOT NULL,
 			last_page_index INTEGER
 		);`,
+		`CREATE TABLE IF NOT EXISTS llm_usage_record (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at INTEGER NOT NULL,
+			model TEXT NOT NULL,
+			prompt_tokens INTEGER NOT NULL,
+			completion_tokens INTEGER NOT NULL,
+			latency_ms INTEGER NOT NULL,
+			cost REAL NOT NULL,
+			cache_hit INTEGER NOT NULL,
+			bid TEXT NOT NULL,
+			question TEXT NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS llm_cache_record (
+			cache_key TEXT PRIMARY KEY,
+			model TEXT NOT NULL,
+			content TEXT NOT NULL,
+			prompt_tokens INTEGER NOT NULL,
+			completion_tokens INTEGER NOT NULL,
+			created_at INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS profile_record (
+			bid TEXT NOT NULL,
+			author_id TEXT NOT NULL,
+			summary TEXT NOT NULL,
+			citations TEXT NOT NULL,
+			first_floor_index INTEGER NOT NULL,
+			last_floor_index INTEGER NOT NULL,
+			post_count INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			synced_at INTEGER,
+			PRIMARY KEY (bid, author_id),
+			FOREIGN KEY (bid) REFERENCES building_record(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS monitor_cursor_record (
+			rule_key TEXT PRIMARY KEY,
+			last_floor_index INTEGER NOT NULL,
+			last_floor_content TEXT NOT NULL,
+			updated_at INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS floor_revision_record (
+			fid TEXT NOT NULL,
+			revision INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			observed_at INTEGER,
+			PRIMARY KEY (fid, revision)
+		);`,
+		`CREATE TABLE IF NOT EXISTS thread_record (
+			bsn INTEGER NOT NULL,
+			sna INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			category TEXT NOT NULL,
+			author_id TEXT NOT NULL,
+			sticky INTEGER NOT NULL,
+			gp INTEGER NOT NULL,
+			floor_count INTEGER NOT NULL,
+			reply_count INTEGER NOT NULL,
+			popularity INTEGER NOT NULL,
+			last_reply_at INTEGER,
+			last_reply_author_id TEXT NOT NULL,
+			first_seen_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY (bsn, sna)
+		);`,
+		`CREATE INDEX IF NOT EXISTS thread_record_last_reply_at ON thread_record (bsn, last_reply_at);`,
+		`CREATE TABLE IF NOT EXISTS user_record (
+			author_id TEXT PRIMARY KEY,
+			nickname TEXT NOT NULL,
+			level INTEGER NOT NULL,
+			race TEXT NOT NULL,
+			career TEXT NOT NULL,
+			gp INTEGER NOT NULL,
+			registered_at INTEGER,
+			last_login_at INTEGER,
+			updated_at INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS user_nickname_record (
+			author_id TEXT NOT NULL,
+			nickname TEXT NOT NULL,
+			first_seen_at INTEGER NOT NULL,
+			last_seen_at INTEGER NOT NULL,
+			PRIMARY KEY (author_id, nickname)
+		);`,
+		`CREATE TABLE IF NOT EXISTS user_activity_record (
+			author_id TEXT NOT NULL,
+			bsn INTEGER NOT NULL,
+			sna INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			first_seen_at INTEGER NOT NULL,
+			last_seen_at INTEGER NOT NULL,
+			PRIMARY KEY (author_id, bsn, sna)
+		);`,
+		`CREATE TABLE IF NOT EXISTS raw_page_record (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			fetched_at INTEGER NOT NULL,
+			hash TEXT NOT NULL,
+			size INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS raw_page_record_url ON raw_page_record (url, fetched_at);`,
+		`CREATE TABLE IF NOT EXISTS auto_track_record (
+			board_key TEXT NOT NULL,
+			bsn INTEGER NOT NULL,
+			sna INTEGER NOT NULL,
+			author_id TEXT NOT NULL,
+			title TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (board_key, bsn, sna)
+		);`,
 	}
 )
 
+// columnMigrations add columns introduced after a table was first created,
+// they are skipped when the column already exists.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{table: "floor_record", column: "posted_at", definition: "INTEGER"},
+	{table: "reply_record", column: "observed_at", definition: "INTEGER"},
+	{table: "reply_record", column: "edited_at", definition: "INTEGER"},
+	{table: "profile_record", column: "synced_at", definition: "INTEGER"},
+	{table: "user_nickname_record", column: "first_bid", definition: "TEXT"},
+	{table: "user_nickname_record", column: "first_floor_index", definition: "INTEGER"},
+	{table: "user_nickname_record", column: "last_bid", definition: "TEXT"},
+	{table: "user_nickname_record", column: "last_floor_index", definition: "INTEGER"},
+}
+
+func (db *BuildingDb) hasColumn(table, column string) (bool, error) {
+	rows, err := db.driver.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name, typ    string
+			notNull, pk  int
+			defaultValue sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultValue, &pk); err != nil {
+			logrus.WithError(err).Error("rows.Scan failed")
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 func (db *BuildingDb) initialSqliteDb(path string) error {
 	dbPath, err := filepath.Abs(path)
 	if err != nil {
@@ -98,13 +265,41 @@ func (db *BuildingDb) initialSqliteDb(path string) error {
 	}
 	db.driver = driver
 	logrus.WithField("BuildingDbPath", dbPath).Info("sql.Open success")
+	return nil
+}
 
+// createTables is executed on every Open, so tables introduced after a
+// building.db was first created are still added to it.
+func (db *BuildingDb) createTables() error {
 	for _, statement := range tableCreateStatements {
 		if _, err := db.driver.Exec(statement); err != nil {
 			logrus.WithError(err).Error("db.driver.Exec failed")
 			return err
 		}
 	}
+
+	for _, migration := range columnMigrations {
+		exist, err := db.hasColumn(migration.table, migration.column)
+		if err != nil {
+			logrus.WithError(err).Error("hasColumn failed")
+			return err
+		}
+		if exist {
+			continue
+		}
+
+		stat := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", migration.table, migration.column, migration.definition)
+		if _, err := db.driver.Exec(stat); err != nil {
+			logrus.WithError(err).Error("db.driver.Exec failed")
+			return err
+		}
+		logrus.Infof("Add column %s to %s", migration.column, migration.table)
+	}
+
+	if err := db.lowerNicknameAuthorIds(); err != nil {
+		logrus.WithError(err).Error("lowerNicknameAuthorIds failed")
+		return err
+	}
 	return nil
 }
 
@@ -121,6 +316,21 @@ func ensureDirectoryExists(path string) error {
 	return nil
 }
 
+// nullableUnix stores an unknown time as NULL instead of year 1.
+func nullableUnix(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+func timeFromNullableUnix(value sql.NullInt64) time.Time {
+	if !value.Valid {
+		return time.Time{}
+	}
+	return time.Unix(value.Int64, 0)
+}
+
 func (db *BuildingDb) Open() error {
 	if err := ensureDirectoryExists(buildingDbPath); err != nil {
 		logrus.WithError(err).Error("ensureDirectoryExists failed")
@@ -141,13 +351,18 @@ func (db *BuildingDb) Open() error {
 		}
 		db.driver = driver
 	}
+
+	if err := db.createTables(); err != nil {
+		logrus.WithError(err).Error("createTables failed")
+		return err
+	}
 	return nil
 }
 
 func (db *BuildingDb) getReplyRecord(fid string, replyIndex int) (*ReplyRecord, error) {
 	query := `SELECT author_name, author_id, content FROM reply_record WHERE fid = ? AND reply_index = ?;`
 
-	var record ReplyRecord
+	record := ReplyRecord{Fid: fid, ReplyIndex: replyIndex}
 	if err := db.driver.QueryRow(query, fid, replyIndex).Scan(
 		&record.AuthorName, &record.AuthorId, &record.Content); err != nil {
 
@@ -161,11 +376,13 @@ func (db *BuildingDb) getReplyRecord(fid string, replyIndex int) (*ReplyRecord,
 }
 
 func (db *BuildingDb) updateReplyRecordContent(fid string, replyIndex int, content string) error {
-	stat := `UPDATE reply_record SET content = ? WHERE fid = ? AND reply_index = ?;`
+	defer metrics.ObserveDbWrite("update_reply_record_content", time.Now())
+
+	stat := `UPDATE reply_record SET content = ?, edited_at = ? WHERE fid = ? AND reply_index = ?;`
 
 	if _, err := db.driver.Exec(
 		stat,
-		content, replyIndex, fid); err != nil {
+		content, time.Now().Unix(), fid, replyIndex); err != nil {
 
 		logrus.WithError(err).Error("db.driver.Exec failed")
 		return err
@@ -174,12 +391,14 @@ func (db *BuildingDb) updateReplyRecordContent(fid string, replyIndex int, conte
 }
 
 func (db *BuildingDb) createReplyRecord(record *ReplyRecord) error {
-	stat := `INSERT INTO reply_record (fid, reply_index, author_name, author_id, content) VALUES (?, ?, ?, ?, ?);`
+	defer metrics.ObserveDbWrite("create_reply_record", time.Now())
+
+	stat := `INSERT INTO reply_record (fid, reply_index, author_name, author_id, content, observed_at) VALUES (?, ?, ?, ?, ?, ?);`
 
 	if _, err := db.driver.Exec(
 		stat,
 		record.Fid, record.ReplyIndex,
-		record.AuthorName, record.AuthorId, record.Content); err != nil {
+		record.AuthorName, record.AuthorId, record.Content, time.Now().Unix()); err != nil {
 
 		logrus.WithError(err).Error("db.driver.Exec failed")
 		return err
@@ -203,7 +422,7 @@ func (db *BuildingDb) SyncReplyRecord(record *ReplyRecord) error {
 		return nil
 	}
 
-	if rr.Content != record.Content || rr.ReplyIndex != record.ReplyIndex {
+	if rr.Content != record.Content {
 		if err := db.updateReplyRecordContent(record.Fid, record.ReplyIndex, record.Content); err != nil {
 			logrus.WithError(err).Error("updateReplyRecordContent failed")
 			return err
@@ -233,6 +452,8 @@ func (db *BuildingDb) GetFloorRecord(bid string, floorIndex int) (*FloorRecord,
 // Only content has possibility to be updated
 // Another fields are not allowed to be updated
 func (db *BuildingDb) UpdateFloorRecordContent(fid, content string) error {
+	defer metrics.ObserveDbWrite("update_floor_record_content", time.Now())
+
 	stat := `UPDATE floor_record SET content = ? WHERE fid = ?;`
 
 	if _, err := db.driver.Exec(
@@ -246,12 +467,15 @@ func (db *BuildingDb) UpdateFloorRecordContent(fid, content string) error {
 }
 
 func (db *BuildingDb) CreateFloorRecord(record *FloorRecord) error {
-	stat := `INSERT INTO floor_record (bid, pid, fid, floor_index, author_name, author_id, content) VALUES (?, ?, ?, ?, ?, ?, ?);`
+	defer metrics.ObserveDbWrite("create_floor_record", time.Now())
+
+	stat := `INSERT INTO floor_record (bid, pid, fid, floor_index, author_name, author_id, content, posted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
 
 	if _, err := db.driver.Exec(
 		stat,
 		record.Bid, record.Pid, record.Fid, record.FloorIndex,
-		record.AuthorName, record.AuthorId, record.Content); err != nil {
+		record.AuthorName, record.AuthorId, record.Content,
+		nullableUnix(record.PostedAt)); err != nil {
 
 		logrus.WithError(err).Error("db.driver.Exec failed")
 		return err
@@ -276,6 +500,8 @@ func (db *BuildingDb) GetPageRecord(bid string, pageIndex int) (*PageRecord, err
 }
 
 func (db *BuildingDb) CreatePageRecord(record *PageRecord) error {
+	defer metrics.ObserveDbWrite("create_page_record", time.Now())
+
 	stat := `INSERT INTO page_record (bid, pid, page_index) VALUES (?, ?, ?);`
 
 	if _, err := db.driver.Exec(
@@ -308,6 +534,8 @@ func (db *BuildingDb) GetBuildingRecord(bsn, sna int) (*BuildingRecord, error) {
 }
 
 func (db *BuildingDb) UpdateBuildingRecord(record *BuildingRecord) error {
+	defer metrics.ObserveDbWrite("update_building_record", time.Now())
+
 	stat := `UPDATE building_record SET building_title = ?, last_page_index = ? WHERE id = ?;`
 
 	if _, err := db.driver.Exec(
@@ -323,6 +551,8 @@ func (db *BuildingDb) UpdateBuildingRecord(record *BuildingRecord) error {
 }
 
 func (db *BuildingDb) CreateBuildingRecord(record *BuildingRecord) error {
+	defer metrics.ObserveDbWrite("create_building_record", time.Now())
+
 	stat := `INSERT INTO building_record (id, bsn, sna, building_title, last_page_index) VALUES (?, ?, ?, ?, ?);`
 
 	if _, err := db.driver.Exec(
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type DigestDB interface {
+	GetFloorRecordsByPageRange(bid string, fromPage, toPage int) ([]*FloorRecord, error)
+	GetFloorRecordsByTimeRange(bid string, from, to time.Time) ([]*FloorRecord, error)
+	GetReplyRecords(fid string) ([]*ReplyRecord, error)
+}
+
+const floorWithPageQuery = `SELECT f.pid, f.fid, f.floor_index, f.author_name, f.author_id, f.content, f.posted_at, p.page_index
+	FROM floor_record f JOIN page_record p ON f.bid = p.bid AND f.pid = p.pid `
+
+func (db *BuildingDb) queryFloorRecordsWithPage(bid, condition string, args ...interface{}) ([]*FloorRecord, error) {
+	rows, err := db.driver.Query(floorWithPageQuery+condition, args...)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*FloorRecord, 0)
+	for rows.Next() {
+		var postedAt sql.NullInt64
+		record := &FloorRecord{Bid: bid}
+		if err := rows.Scan(
+			&record.Pid, &record.Fid, &record.FloorIndex,
+			&record.AuthorName, &record.AuthorId, &record.Content,
+			&postedAt, &record.PageIndex); err != nil {
+
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		record.PostedAt = timeFromNullableUnix(postedAt)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (db *BuildingDb) GetFloorRecordsByPageRange(bid string, fromPage, toPage int) ([]*FloorRecord, error) {
+	return db.queryFloorRecordsWithPage(bid,
+		`WHERE f.bid = ? AND p.page_index BETWEEN ? AND ? ORDER BY f.floor_index;`,
+		bid, fromPage, toPage)
+}
+
+// GetFloorRecordsByTimeRange only sees floors archived with posted_at.
+func (db *BuildingDb) GetFloorRecordsByTimeRange(bid string, from, to time.Time) ([]*FloorRecord, error) {
+	return db.queryFloorRecordsWithPage(bid,
+		`WHERE f.bid = ? AND f.posted_at >= ? AND f.posted_at < ? ORDER BY f.floor_index;`,
+		bid, from.Unix(), to.Unix())
+}
+
+func (db *BuildingDb) GetReplyRecords(fid string) ([]*ReplyRecord, error) {
+	query := `SELECT reply_index, author_name, author_id, content FROM reply_record WHERE fid = ? ORDER BY reply_index;`
+
+	rows, err := db.driver.Query(query, fid)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*ReplyRecord, 0)
+	for rows.Next() {
+		record := &ReplyRecord{Fid: fid}
+		if err := rows.Scan(
+			&record.ReplyIndex, &record.AuthorName,
+			&record.AuthorId, &record.Content); err != nil {
+
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+type MonitorDB interface {
+	GetMonitorCursorRecord(ruleKey string) (*MonitorCursorRecord, error)
+	SaveMonitorCursorRecord(record *MonitorCursorRecord) error
+	GetAutoTrackRecords(boardKey string) ([]*AutoTrackRecord, error)
+	SaveAutoTrackRecord(record *AutoTrackRecord) error
+	DeleteAutoTrackRecord(boardKey string, bsn, sna int) error
+}
+
+func (db *BuildingDb) GetMonitorCursorRecord(ruleKey string) (*MonitorCursorRecord, error) {
+	query := `SELECT last_floor_index, last_floor_content, updated_at FROM monitor_cursor_record WHERE rule_key = ?;`
+
+	var updatedAt int64
+	record := MonitorCursorRecord{RuleKey: ruleKey}
+	if err := db.driver.QueryRow(query, ruleKey).Scan(
+		&record.LastFloorIndex, &record.LastFloorContent, &updatedAt); err != nil {
+
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.driver.QueryRow.Scan failed")
+		}
+
+		return nil, err
+	}
+	record.UpdatedAt = time.Unix(updatedAt, 0)
+	return &record, nil
+}
+
+func (db *BuildingDb) SaveMonitorCursorRecord(record *MonitorCursorRecord) error {
+	defer metrics.ObserveDbWrite("save_monitor_cursor_record", time.Now())
+
+	stat := `INSERT OR REPLACE INTO monitor_cursor_record (rule_key, last_floor_index, last_floor_content, updated_at) VALUES (?, ?, ?, ?);`
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.RuleKey, record.LastFloorIndex,
+		record.LastFloorContent, record.UpdatedAt.Unix()); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
+
+func (db *BuildingDb) GetAutoTrackRecords(boardKey string) ([]*AutoTrackRecord, error) {
+	query := `SELECT board_key, bsn, sna, author_id, title, created_at FROM auto_track_record
+		WHERE board_key = ? ORDER BY sna;`
+
+	rows, err := db.driver.Query(query, boardKey)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*AutoTrackRecord, 0)
+	for rows.Next() {
+		var createdAt int64
+		record := &AutoTrackRecord{}
+		if err := rows.Scan(
+			&record.BoardKey, &record.Bsn, &record.Sna,
+			&record.AuthorId, &record.Title, &createdAt); err != nil {
+
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		record.CreatedAt = time.Unix(createdAt, 0)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (db *BuildingDb) SaveAutoTrackRecord(record *AutoTrackRecord) error {
+	defer metrics.ObserveDbWrite("save_auto_track_record", time.Now())
+
+	stat := `INSERT OR REPLACE INTO auto_track_record (board_key, bsn, sna, author_id, title, created_at) VALUES (?, ?, ?, ?, ?, ?);`
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.BoardKey, record.Bsn, record.Sna,
+		record.AuthorId, record.Title, record.CreatedAt.Unix()); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
+
+func (db *BuildingDb) DeleteAutoTrackRecord(boardKey string, bsn, sna int) error {
+	defer metrics.ObserveDbWrite("delete_auto_track_record", time.Now())
+
+	stat := `DELETE FROM auto_track_record WHERE board_key = ? AND bsn = ? AND sna = ?;`
+
+	if _, err := db.driver.Exec(stat, boardKey, bsn, sna); err != nil {
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+type ProfileDB interface {
+	GetFloorRecordsByAuthor(bid, authorId string, afterFloorIndex int, changedSince time.Time) ([]*FloorRecord, error)
+	GetReplyRecordsByAuthor(bid, authorId string, afterFloorIndex int, changedSince time.Time) ([]*AuthoredReplyRecord, error)
+
+	GetProfileRecord(bid, authorId string) (*ProfileRecord, error)
+	SaveProfileRecord(record *ProfileRecord) error
+}
+
+// GetFloorRecordsByAuthor returns the floors of authorId after
+// afterFloorIndex, along with the earlier ones edited since changedSince.
+// A zero changedSince selects no edited floor.
+func (db *BuildingDb) GetFloorRecordsByAuthor(bid, authorId string, afterFloorIndex int, changedSince time.Time) ([]*FloorRecord, error) {
+	query := `SELECT pid, fid, floor_index, author_name, author_id, content FROM floor_record
+		WHERE bid = ? AND author_id = ? COLLATE NOCASE AND (floor_index > ?
+			OR fid IN (SELECT fid FROM floor_revision_record WHERE revision > 1 AND observed_at >= ?))
+		ORDER BY floor_index;`
+
+	rows, err := db.driver.Query(query, bid, authorId, afterFloorIndex, nullableUnix(changedSince))
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*FloorRecord, 0)
+	for rows.Next() {
+		record := &FloorRecord{Bid: bid}
+		if err := rows.Scan(
+			&record.Pid, &record.Fid, &record.FloorIndex,
+			&record.AuthorName, &record.AuthorId, &record.Content); err != nil {
+
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// GetReplyRecordsByAuthor returns the replies of authorId under the floors
+// after afterFloorIndex, along with the ones left or edited since
+// changedSince under earlier floors.
+func (db *BuildingDb) GetReplyRecordsByAuthor(bid, authorId string, afterFloorIndex int, changedSince time.Time) ([]*AuthoredReplyRecord, error) {
+	query := `SELECT f.floor_index, r.fid, r.reply_index, r.author_name, r.author_id, r.content, r.observed_at
+		FROM reply_record r JOIN floor_record f ON r.fid = f.fid
+		WHERE f.bid = ? AND r.author_id = ? COLLATE NOCASE
+			AND (f.floor_index > ? OR r.observed_at >= ? OR r.edited_at >= ?)
+		ORDER BY f.floor_index, r.reply_index;`
+
+	since := nullableUnix(changedSince)
+	rows, err := db.driver.Query(query, bid, authorId, afterFloorIndex, since, since)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*AuthoredReplyRecord, 0)
+	for rows.Next() {
+		var observedAt sql.NullInt64
+		record := &AuthoredReplyRecord{ReplyRecord: &ReplyRecord{}}
+		if err := rows.Scan(
+			&record.FloorIndex, &record.Fid, &record.ReplyIndex,
+			&record.AuthorName, &record.AuthorId, &record.Content, &observedAt); err != nil {
+
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		record.ObservedAt = timeFromNullableUnix(observedAt)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (db *BuildingDb) GetProfileRecord(bid, authorId string) (*ProfileRecord, error) {
+	query := `SELECT summary, citations, first_floor_index, last_floor_index, post_count, updated_at, synced_at
+		FROM profile_record WHERE bid = ? AND author_id = ?;`
+
+	var citations string
+	var updatedAt int64
+	var syncedAt sql.NullInt64
+	record := ProfileRecord{Bid: bid, AuthorId: authorId}
+	if err := db.driver.QueryRow(query, bid, authorId).Scan(
+		&record.Summary, &citations,
+		&record.FirstFloorIndex, &record.LastFloorIndex,
+		&record.PostCount, &updatedAt, &syncedAt); err != nil {
+
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.driver.QueryRow.Scan failed")
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(citations), &record.Citations); err != nil {
+		logrus.WithError(err).Error("Failed to unmarshal citations")
+		return nil, err
+	}
+	record.UpdatedAt = time.Unix(updatedAt, 0)
+	record.SyncedAt = timeFromNullableUnix(syncedAt)
+	return &record, nil
+}
+
+func (db *BuildingDb) SaveProfileRecord(record *ProfileRecord) error {
+	defer metrics.ObserveDbWrite("save_profile_record", time.Now())
+
+	stat := `INSERT OR REPLACE INTO profile_record (bid, author_id, summary, citations, first_floor_index, last_floor_index, post_count, updated_at, synced_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+	citations, err := json.Marshal(record.Citations)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal citations")
+		return err
+	}
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.Bid, record.AuthorId,
+		record.Summary, string(citations),
+		record.FirstFloorIndex, record.LastFloorIndex,
+		record.PostCount, record.UpdatedAt.Unix(), nullableUnix(record.SyncedAt)); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
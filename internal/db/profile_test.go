@@ -0,0 +1,111 @@
+package db
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// openTestDb opens a new building.db in a temporary directory, the path of
+// the db is relative to the working directory.
+func openTestDb(t *testing.T) *BuildingDb {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	db := &BuildingDb{}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func syncTestFloor(t *testing.T, db *BuildingDb, page *PageRecord, floorIndex int, authorId, content string, observedAt time.Time) *FloorRecord {
+	floor := &FloorRecord{
+		Bid:        page.Bid,
+		Pid:        page.Pid,
+		FloorIndex: floorIndex,
+		AuthorName: authorId,
+		AuthorId:   authorId,
+		Content:    content,
+	}
+	if _, err := db.SyncFloorRecord(floor, observedAt); err != nil {
+		t.Fatal(err)
+	}
+	return floor
+}
+
+func floorIndexes(floors []*FloorRecord) []int {
+	indexes := make([]int, 0, len(floors))
+	for _, floor := range floors {
+		indexes = append(indexes, floor.FloorIndex)
+	}
+	return indexes
+}
+
+func TestAuthorRecordsSince(t *testing.T) {
+	db := openTestDb(t)
+	page, err := db.SyncPageRecord(60076, 123, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(-time.Hour)
+	floor1 := syncTestFloor(t, db, page, 1, "Baha", "first", before)
+	syncTestFloor(t, db, page, 2, "baha", "second", before)
+	syncTestFloor(t, db, page, 3, "someone", "third", before)
+	for index, fid := range []string{floor1.Fid, floor1.Fid} {
+		if err := db.SyncReplyRecord(&ReplyRecord{Fid: fid, ReplyIndex: index + 1, AuthorName: "baha", AuthorId: "baha", Content: "old"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.driver.Exec(`UPDATE reply_record SET observed_at = ?;`, before.Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the profile read every post above, times are kept in seconds
+	since := time.Now().Truncate(time.Second)
+	syncTestFloor(t, db, page, 2, "baha", "second, edited", since)
+	syncTestFloor(t, db, page, 4, "BAHA", "fourth", since)
+	if err := db.SyncReplyRecord(&ReplyRecord{Fid: floor1.Fid, ReplyIndex: 2, AuthorName: "baha", AuthorId: "baha", Content: "old, edited"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SyncReplyRecord(&ReplyRecord{Fid: floor1.Fid, ReplyIndex: 3, AuthorName: "baha", AuthorId: "BaHa", Content: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	floors, err := db.GetFloorRecordsByAuthor(page.Bid, "baha", 3, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := floorIndexes(floors); len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Errorf("floors = %v, want edited B2 and new B4", got)
+	}
+	if floors[1].AuthorId != "BAHA" {
+		t.Errorf("author id = %s, want the archived case", floors[1].AuthorId)
+	}
+
+	replies, err := db.GetReplyRecordsByAuthor(page.Bid, "baha", 3, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 2 || replies[0].ReplyIndex != 2 || replies[1].ReplyIndex != 3 {
+		t.Fatalf("replies = %d, want edited B1-2 and new B1-3", len(replies))
+	}
+	if !replies[0].ObservedAt.Before(since) || replies[1].ObservedAt.Before(since) {
+		t.Errorf("observed at = %s, %s", replies[0].ObservedAt, replies[1].ObservedAt)
+	}
+
+	all, err := db.GetFloorRecordsByAuthor(page.Bid, "BAHA", 0, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := floorIndexes(all); len(got) != 3 {
+		t.Errorf("every floor = %v, want B1 B2 B4", got)
+	}
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// RawDB indexes the raw responses kept on disk by url and fetch time.
+type RawDB interface {
+	CreateRawPageRecord(record *RawPageRecord) error
+	GetLatestRawPageRecord(url string) (*RawPageRecord, error)
+	GetLatestRawPageRecords(urlPrefix string) ([]*RawPageRecord, error)
+}
+
+func (db *BuildingDb) CreateRawPageRecord(record *RawPageRecord) error {
+	defer metrics.ObserveDbWrite("create_raw_page_record", time.Now())
+
+	stat := `INSERT INTO raw_page_record (url, fetched_at, hash, size) VALUES (?, ?, ?, ?);`
+
+	res, err := db.driver.Exec(
+		stat,
+		record.Url, record.FetchedAt.Unix(),
+		record.Hash, record.Size)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+
+	if record.Id, err = res.LastInsertId(); err != nil {
+		logrus.WithError(err).Error("res.LastInsertId failed")
+		return err
+	}
+	return nil
+}
+
+func (db *BuildingDb) GetLatestRawPageRecord(url string) (*RawPageRecord, error) {
+	query := `SELECT id, fetched_at, hash, size FROM raw_page_record WHERE url = ? ORDER BY id DESC LIMIT 1;`
+
+	var fetchedAt int64
+	record := RawPageRecord{Url: url}
+	if err := db.driver.QueryRow(query, url).Scan(
+		&record.Id, &fetchedAt, &record.Hash, &record.Size); err != nil {
+
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.driver.QueryRow.Scan failed")
+		}
+
+		return nil, err
+	}
+	record.FetchedAt = time.Unix(fetchedAt, 0)
+	return &record, nil
+}
+
+// GetLatestRawPageRecords returns the latest response of every url starting
+// with urlPrefix, ordered by url.
+func (db *BuildingDb) GetLatestRawPageRecords(urlPrefix string) ([]*RawPageRecord, error) {
+	// sqlite fills the bare columns from the row holding the MAX
+	query := `SELECT MAX(id), url, fetched_at, hash, size FROM raw_page_record
+		WHERE substr(url, 1, length(?)) = ? GROUP BY url ORDER BY url;`
+
+	rows, err := db.driver.Query(query, urlPrefix, urlPrefix)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*RawPageRecord, 0)
+	for rows.Next() {
+		var fetchedAt int64
+		record := &RawPageRecord{}
+		if err := rows.Scan(
+			&record.Id, &record.Url, &fetchedAt,
+			&record.Hash, &record.Size); err != nil {
+
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		record.FetchedAt = time.Unix(fetchedAt, 0)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
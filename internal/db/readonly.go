@@ -0,0 +1,51 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OpenReadOnly opens building.db through a connection which sqlite itself
+// refuses to write with, used to run queries we did not write ourselves.
+func OpenReadOnly() (*sql.DB, error) {
+	dbPath, err := filepath.Abs(buildingDbPath)
+	if err != nil {
+		logrus.WithError(err).Error("filepath.Abs failed")
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("file:%s?mode=ro&_query_only=true", dbPath)
+	driver, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		logrus.WithError(err).Error("sql.Open failed")
+		return nil, err
+	}
+
+	if err := driver.Ping(); err != nil {
+		logrus.WithError(err).Error("driver.Ping failed")
+		driver.Close()
+		return nil, err
+	}
+	return driver, nil
+}
+
+// GetTableSchema returns the CREATE TABLE statements of the given tables as
+// they are stored in sqlite_master.
+func GetTableSchema(driver *sql.DB, tables ...string) (string, error) {
+	query := `SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?;`
+
+	statements := make([]string, 0, len(tables))
+	for _, table := range tables {
+		var statement string
+		if err := driver.QueryRow(query, table).Scan(&statement); err != nil {
+			logrus.WithError(err).Errorf("db.driver.QueryRow.Scan %s failed", table)
+			return "", err
+		}
+		statements = append(statements, statement+";")
+	}
+	return strings.Join(statements, "\n"), nil
+}
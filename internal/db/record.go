@@ -1,5 +1,7 @@
 package db
 
+import "time"
+
 type ReplyRecord struct {
 	Fid string `json:"fid"`
 
@@ -16,9 +18,14 @@ type FloorRecord struct {
 
 	FloorIndex int `json:"floor_index"`
 
-	AuthorName string `json:"author_name"`
-	AuthorId   string `json:"author_id"`
-	Content    string `json:"content"`
+	AuthorName string    `json:"author_name"`
+	AuthorId   string    `json:"author_id"`
+	Content    string    `json:"content"`
+	PostedAt   time.Time `json:"posted_at"`
+
+	// PageIndex is not stored in floor_record, it is filled from the page
+	// the floor was parsed from or joined from page_record.
+	PageIndex int `json:"page_index"`
 
 	Replies []*ReplyRecord
 }
@@ -40,3 +47,182 @@ type BuildingRecord struct {
 	BuildingTitle string `json:"building_title"`
 	LastPageIndex int    `json:"last_page_index"`
 }
+
+type UsageRecord struct {
+	Id        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	LatencyMs        int64   `json:"latency_ms"`
+	Cost             float64 `json:"cost"`
+	CacheHit         bool    `json:"cache_hit"`
+
+	Bid      string `json:"bid"`
+	Question string `json:"question"`
+}
+
+type UsageSummary struct {
+	Key string `json:"key"`
+
+	Calls            int     `json:"calls"`
+	CacheHits        int     `json:"cache_hits"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+type LlmCacheRecord struct {
+	CacheKey string `json:"cache_key"`
+
+	Model            string    `json:"model"`
+	Content          string    `json:"content"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// AuthoredReplyRecord is a reply together with the index of the floor it
+// was left under. ObservedAt is when the reply was first archived, zero for
+// a reply archived before it was kept.
+type AuthoredReplyRecord struct {
+	FloorIndex int       `json:"floor_index"`
+	ObservedAt time.Time `json:"observed_at"`
+
+	*ReplyRecord
+}
+
+type Citation struct {
+	FloorIndex int `json:"floor_index"`
+	// ReplyIndex is -1 when the floor itself is cited
+	ReplyIndex int `json:"reply_index"`
+}
+
+type ProfileRecord struct {
+	Bid      string `json:"bid"`
+	AuthorId string `json:"author_id"`
+
+	Summary         string      `json:"summary"`
+	Citations       []*Citation `json:"citations"`
+	FirstFloorIndex int         `json:"first_floor_index"`
+	LastFloorIndex  int         `json:"last_floor_index"`
+	PostCount       int         `json:"post_count"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+
+	// SyncedAt is when the posts of the summary were read, posts left or
+	// edited later are summarized by the next incremental update
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// ThreadRecord is a building as listed on the thread list of its board.
+type ThreadRecord struct {
+	Bsn int `json:"bsn"`
+	Sna int `json:"sna"`
+
+	Title    string `json:"title"`
+	Category string `json:"category"`
+	AuthorId string `json:"author_id"`
+	Sticky   bool   `json:"sticky"`
+
+	Gp         int `json:"gp"`
+	FloorCount int `json:"floor_count"`
+	ReplyCount int `json:"reply_count"`
+	Popularity int `json:"popularity"`
+
+	LastReplyAt       time.Time `json:"last_reply_at"`
+	LastReplyAuthorId string    `json:"last_reply_author_id"`
+
+	// FirstSeenAt and UpdatedAt are kept by the thread index
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// UserRecord is the public profile shown on the home page of a user, fields
+// the user keeps private are left zero.
+type UserRecord struct {
+	AuthorId string `json:"author_id"`
+
+	Nickname     string    `json:"nickname"`
+	Level        int       `json:"level"`
+	Race         string    `json:"race"`
+	Career       string    `json:"career"`
+	Gp           int       `json:"gp"`
+	RegisteredAt time.Time `json:"registered_at"`
+	LastLoginAt  time.Time `json:"last_login_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Activities is not stored in user_record, it is kept in
+	// user_activity_record
+	Activities []*UserActivityRecord
+}
+
+// UserActivityRecord is a building the home page of a user links to, such
+// as a thread the user has recently posted in.
+type UserActivityRecord struct {
+	AuthorId string `json:"author_id"`
+	Bsn      int    `json:"bsn"`
+	Sna      int    `json:"sna"`
+
+	Title       string    `json:"title"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// NicknameRecord is a nickname a user has been seen with, AuthorName drifts
+// while AuthorId stays.
+type NicknameRecord struct {
+	AuthorId string `json:"author_id"`
+	Nickname string `json:"nickname"`
+
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+
+	// the first and last floor the nickname was seen on, either posting it
+	// or replying under it, Bid is empty when only seen on the home page
+	FirstBid        string `json:"first_bid,omitempty"`
+	FirstFloorIndex int    `json:"first_floor_index,omitempty"`
+	LastBid         string `json:"last_bid,omitempty"`
+	LastFloorIndex  int    `json:"last_floor_index,omitempty"`
+}
+
+// FloorRevisionRecord is one content of a floor seen by the monitor, the
+// newest revision is the content kept in floor_record.
+type FloorRevisionRecord struct {
+	Fid      string `json:"fid"`
+	Revision int    `json:"revision"`
+
+	Content    string    `json:"content"`
+	ObservedAt time.Time `json:"observed_at"`
+}
+
+// RawPageRecord is a response fetched by the crawler, its content is kept
+// on disk under Hash.
+type RawPageRecord struct {
+	Id        int64     `json:"id"`
+	Url       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Hash      string    `json:"hash"`
+	Size      int       `json:"size"`
+}
+
+// AutoTrackRecord is a thread a board rule started tracking, the tracking
+// rule is built again from the board rule when the monitor restarts.
+type AutoTrackRecord struct {
+	BoardKey string `json:"board_key"`
+	Bsn      int    `json:"bsn"`
+	Sna      int    `json:"sna"`
+
+	AuthorId  string    `json:"author_id"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MonitorCursorRecord is the last floor a tracking rule has seen.
+type MonitorCursorRecord struct {
+	RuleKey string `json:"rule_key"`
+
+	LastFloorIndex   int       `json:"last_floor_index"`
+	LastFloorContent string    `json:"last_floor_content"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultThreadSearchLimit = 50
+
+// ThreadQuery selects threads of the index, every condition left zero is
+// not applied. Title matches a part of the title, the others match whole.
+type ThreadQuery struct {
+	Bsn         int
+	Title       string
+	AuthorId    string
+	Category    string
+	MinGp       int
+	ActiveSince time.Time
+	Limit       int
+}
+
+// ThreadDB is the index of the threads listed on boards.
+type ThreadDB interface {
+	GetThreadRecord(bsn, sna int) (*ThreadRecord, error)
+	SaveThreadRecord(record *ThreadRecord) error
+	SearchThreadRecords(query *ThreadQuery) ([]*ThreadRecord, error)
+}
+
+const threadColumns = `bsn, sna, title, category, author_id, sticky, gp, floor_count, reply_count, popularity,
+	last_reply_at, last_reply_author_id, first_seen_at, updated_at`
+
+func scanThreadRecord(scanner interface{ Scan(...any) error }) (*ThreadRecord, error) {
+	var (
+		record                 ThreadRecord
+		lastReplyAt            sql.NullInt64
+		firstSeenAt, updatedAt int64
+	)
+	if err := scanner.Scan(
+		&record.Bsn, &record.Sna,
+		&record.Title, &record.Category, &record.AuthorId, &record.Sticky,
+		&record.Gp, &record.FloorCount, &record.ReplyCount, &record.Popularity,
+		&lastReplyAt, &record.LastReplyAuthorId,
+		&firstSeenAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	record.LastReplyAt = timeFromNullableUnix(lastReplyAt)
+	record.FirstSeenAt = time.Unix(firstSeenAt, 0)
+	record.UpdatedAt = time.Unix(updatedAt, 0)
+	return &record, nil
+}
+
+func (db *BuildingDb) GetThreadRecord(bsn, sna int) (*ThreadRecord, error) {
+	query := `SELECT ` + threadColumns + ` FROM thread_record WHERE bsn = ? AND sna = ?;`
+
+	record, err := scanThreadRecord(db.driver.QueryRow(query, bsn, sna))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.driver.QueryRow.Scan failed")
+		}
+
+		return nil, err
+	}
+	return record, nil
+}
+
+// SaveThreadRecord inserts or updates the thread, FirstSeenAt of a thread
+// already indexed is kept.
+func (db *BuildingDb) SaveThreadRecord(record *ThreadRecord) error {
+	defer metrics.ObserveDbWrite("save_thread_record", time.Now())
+
+	stat := `INSERT INTO thread_record (` + threadColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (bsn, sna) DO UPDATE SET
+			title = excluded.title, category = excluded.category, author_id = excluded.author_id,
+			sticky = excluded.sticky, gp = excluded.gp, floor_count = excluded.floor_count,
+			reply_count = excluded.reply_count, popularity = excluded.popularity,
+			last_reply_at = excluded.last_reply_at, last_reply_author_id = excluded.last_reply_author_id,
+			updated_at = excluded.updated_at;`
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.Bsn, record.Sna,
+		record.Title, record.Category, record.AuthorId, record.Sticky,
+		record.Gp, record.FloorCount, record.ReplyCount, record.Popularity,
+		nullableUnix(record.LastReplyAt), record.LastReplyAuthorId,
+		record.FirstSeenAt.Unix(), record.UpdatedAt.Unix()); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
+
+// escapeLike makes text match itself literally in a LIKE pattern.
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+// SearchThreadRecords returns the threads matching query, the most recently
+// active first.
+func (db *BuildingDb) SearchThreadRecords(query *ThreadQuery) ([]*ThreadRecord, error) {
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+	if query.Bsn != 0 {
+		conditions = append(conditions, "bsn = ?")
+		args = append(args, query.Bsn)
+	}
+	if query.Title != "" {
+		conditions = append(conditions, `title LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(query.Title)+"%")
+	}
+	if query.AuthorId != "" {
+		conditions = append(conditions, "author_id = ? COLLATE NOCASE")
+		args = append(args, query.AuthorId)
+	}
+	if query.Category != "" {
+		conditions = append(conditions, "category = ?")
+		args = append(args, query.Category)
+	}
+	if query.MinGp > 0 {
+		conditions = append(conditions, "gp >= ?")
+		args = append(args, query.MinGp)
+	}
+	if !query.ActiveSince.IsZero() {
+		conditions = append(conditions, "last_reply_at >= ?")
+		args = append(args, query.ActiveSince.Unix())
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultThreadSearchLimit
+	}
+
+	stat := `SELECT ` + threadColumns + ` FROM thread_record`
+	if len(conditions) > 0 {
+		stat += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	stat += " ORDER BY last_reply_at DESC, sna DESC LIMIT ?;"
+	args = append(args, limit)
+
+	rows, err := db.driver.Query(stat, args...)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*ThreadRecord, 0)
+	for rows.Next() {
+		record, err := scanThreadRecord(rows)
+		if err != nil {
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
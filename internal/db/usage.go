@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	UsageGroupByDay      = "day"
+	UsageGroupByBuilding = "building"
+	UsageGroupByQuestion = "question"
+	UsageGroupByModel    = "model"
+)
+
+var usageGroupColumns = map[string]string{
+	UsageGroupByDay:      "date(created_at, 'unixepoch', 'localtime')",
+	UsageGroupByBuilding: "bid",
+	UsageGroupByQuestion: "question",
+	UsageGroupByModel:    "model",
+}
+
+type UsageDB interface {
+	CreateUsageRecord(record *UsageRecord) error
+	GetUsageCostSince(since time.Time) (float64, error)
+	GetUsageSummary(groupBy string, since time.Time) ([]*UsageSummary, error)
+
+	GetLlmCacheRecord(cacheKey string) (*LlmCacheRecord, error)
+	CreateLlmCacheRecord(record *LlmCacheRecord) error
+}
+
+func (db *BuildingDb) CreateUsageRecord(record *UsageRecord) error {
+	defer metrics.ObserveDbWrite("create_usage_record", time.Now())
+
+	stat := `INSERT INTO llm_usage_record (created_at, model, prompt_tokens, completion_tokens, latency_ms, cost, cache_hit, bid, question) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+	res, err := db.driver.Exec(
+		stat,
+		record.CreatedAt.Unix(), record.Model,
+		record.PromptTokens, record.CompletionTokens,
+		record.LatencyMs, record.Cost, record.CacheHit,
+		record.Bid, record.Question)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+
+	if record.Id, err = res.LastInsertId(); err != nil {
+		logrus.WithError(err).Error("res.LastInsertId failed")
+		return err
+	}
+	return nil
+}
+
+func (db *BuildingDb) GetUsageCostSince(since time.Time) (float64, error) {
+	query := `SELECT COALESCE(SUM(cost), 0) FROM llm_usage_record WHERE created_at >= ?;`
+
+	var cost float64
+	if err := db.driver.QueryRow(query, since.Unix()).Scan(&cost); err != nil {
+		logrus.WithError(err).Error("db.driver.QueryRow.Scan failed")
+		return 0, err
+	}
+	return cost, nil
+}
+
+func (db *BuildingDb) GetUsageSummary(groupBy string, since time.Time) ([]*UsageSummary, error) {
+	column, exist := usageGroupColumns[groupBy]
+	if !exist {
+		logrus.Errorf("unknown usage group %s", groupBy)
+		return nil, fmt.Errorf("unknown usage group %s", groupBy)
+	}
+
+	query := fmt.Sprintf(`SELECT %s AS k, COUNT(*), SUM(cache_hit), SUM(prompt_tokens), SUM(completion_tokens), SUM(cost)
+		FROM llm_usage_record WHERE created_at >= ? GROUP BY k ORDER BY k;`, column)
+
+	rows, err := db.driver.Query(query, since.Unix())
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make([]*UsageSummary, 0)
+	for rows.Next() {
+		var summary UsageSummary
+		if err := rows.Scan(
+			&summary.Key, &summary.Calls, &summary.CacheHits,
+			&summary.PromptTokens, &summary.CompletionTokens, &summary.Cost); err != nil {
+
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		summaries = append(summaries, &summary)
+	}
+	return summaries, rows.Err()
+}
+
+func (db *BuildingDb) GetLlmCacheRecord(cacheKey string) (*LlmCacheRecord, error) {
+	query := `SELECT model, content, prompt_tokens, completion_tokens, created_at FROM llm_cache_record WHERE cache_key = ?;`
+
+	var createdAt int64
+	record := LlmCacheRecord{CacheKey: cacheKey}
+	if err := db.driver.QueryRow(query, cacheKey).Scan(
+		&record.Model, &record.Content,
+		&record.PromptTokens, &record.CompletionTokens, &createdAt); err != nil {
+
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.driver.QueryRow.Scan failed")
+		}
+
+		return nil, err
+	}
+	record.CreatedAt = time.Unix(createdAt, 0)
+	return &record, nil
+}
+
+func (db *BuildingDb) CreateLlmCacheRecord(record *LlmCacheRecord) error {
+	defer metrics.ObserveDbWrite("create_llm_cache_record", time.Now())
+
+	stat := `INSERT OR REPLACE INTO llm_cache_record (cache_key, model, content, prompt_tokens, completion_tokens, created_at) VALUES (?, ?, ?, ?, ?, ?);`
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.CacheKey, record.Model, record.Content,
+		record.PromptTokens, record.CompletionTokens,
+		record.CreatedAt.Unix()); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
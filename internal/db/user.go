@@ -0,0 +1,144 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// UserDB keeps the profiles crawled from the home pages of users, together
+// with every activity seen over time. The nicknames are kept by AuthorDB.
+type UserDB interface {
+	GetUserRecord(authorId string) (*UserRecord, error)
+	SyncUserRecord(record *UserRecord) error
+
+	GetUserActivityRecords(authorId string) ([]*UserActivityRecord, error)
+}
+
+func (db *BuildingDb) GetUserRecord(authorId string) (*UserRecord, error) {
+	query := `SELECT author_id, nickname, level, race, career, gp, registered_at, last_login_at, updated_at
+		FROM user_record WHERE author_id = ? COLLATE NOCASE;`
+
+	var (
+		registeredAt, lastLoginAt sql.NullInt64
+		updatedAt                 int64
+	)
+	var record UserRecord
+	if err := db.driver.QueryRow(query, authorId).Scan(
+		&record.AuthorId, &record.Nickname, &record.Level,
+		&record.Race, &record.Career, &record.Gp,
+		&registeredAt, &lastLoginAt, &updatedAt); err != nil {
+
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.driver.QueryRow.Scan failed")
+		}
+
+		return nil, err
+	}
+	record.RegisteredAt = timeFromNullableUnix(registeredAt)
+	record.LastLoginAt = timeFromNullableUnix(lastLoginAt)
+	record.UpdatedAt = time.Unix(updatedAt, 0)
+	return &record, nil
+}
+
+func (db *BuildingDb) saveUserRecord(record *UserRecord) error {
+	defer metrics.ObserveDbWrite("save_user_record", time.Now())
+
+	stat := `INSERT OR REPLACE INTO user_record (author_id, nickname, level, race, career, gp, registered_at, last_login_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.AuthorId, record.Nickname, record.Level,
+		record.Race, record.Career, record.Gp,
+		nullableUnix(record.RegisteredAt), nullableUnix(record.LastLoginAt),
+		record.UpdatedAt.Unix()); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
+
+func (db *BuildingDb) saveUserActivityRecord(record *UserActivityRecord) error {
+	defer metrics.ObserveDbWrite("save_user_activity_record", time.Now())
+
+	stat := `INSERT INTO user_activity_record (author_id, bsn, sna, title, first_seen_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (author_id, bsn, sna) DO UPDATE SET
+			title = excluded.title,
+			first_seen_at = MIN(first_seen_at, excluded.first_seen_at),
+			last_seen_at = MAX(last_seen_at, excluded.last_seen_at);`
+
+	if _, err := db.driver.Exec(
+		stat,
+		record.AuthorId, record.Bsn, record.Sna, record.Title,
+		record.FirstSeenAt.Unix(), record.LastSeenAt.Unix()); err != nil {
+
+		logrus.WithError(err).Error("db.driver.Exec failed")
+		return err
+	}
+	return nil
+}
+
+// SyncUserRecord saves the profile crawled at UpdatedAt, recording its
+// nickname and activities as seen at that time.
+func (db *BuildingDb) SyncUserRecord(record *UserRecord) error {
+	if err := db.saveUserRecord(record); err != nil {
+		logrus.WithError(err).Error("saveUserRecord failed")
+		return err
+	}
+
+	if record.Nickname != "" {
+		if err := db.SaveNicknameRecord(&NicknameRecord{
+			AuthorId:    record.AuthorId,
+			Nickname:    record.Nickname,
+			FirstSeenAt: record.UpdatedAt,
+			LastSeenAt:  record.UpdatedAt,
+		}); err != nil {
+			logrus.WithError(err).Error("SaveNicknameRecord failed")
+			return err
+		}
+	}
+
+	for _, activity := range record.Activities {
+		activity.AuthorId = record.AuthorId
+		activity.FirstSeenAt, activity.LastSeenAt = record.UpdatedAt, record.UpdatedAt
+		if err := db.saveUserActivityRecord(activity); err != nil {
+			logrus.WithError(err).Error("saveUserActivityRecord failed")
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserActivityRecords returns the activities of the user, the most
+// recently seen first.
+func (db *BuildingDb) GetUserActivityRecords(authorId string) ([]*UserActivityRecord, error) {
+	query := `SELECT author_id, bsn, sna, title, first_seen_at, last_seen_at FROM user_activity_record
+		WHERE author_id = ? COLLATE NOCASE ORDER BY last_seen_at DESC, sna DESC;`
+
+	rows, err := db.driver.Query(query, authorId)
+	if err != nil {
+		logrus.WithError(err).Error("db.driver.Query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*UserActivityRecord, 0)
+	for rows.Next() {
+		var firstSeenAt, lastSeenAt int64
+		record := &UserActivityRecord{}
+		if err := rows.Scan(
+			&record.AuthorId, &record.Bsn, &record.Sna, &record.Title,
+			&firstSeenAt, &lastSeenAt); err != nil {
+
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+		record.FirstSeenAt = time.Unix(firstSeenAt, 0)
+		record.LastSeenAt = time.Unix(lastSeenAt, 0)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
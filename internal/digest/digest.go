@@ -0,0 +1,323 @@
+package digest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/llm"
+	"github.com/davidleitw/baha/internal/textutil"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultMaxChunkTokens = 4000
+	DefaultTopN           = 5
+
+	floorExcerptLength = 300
+	replyExcerptLength = 80
+	repliesPerFloor    = 3
+
+	mapSystemPrompt = `You read floors of a Bahamut forum thread and cluster them into discussion topics.
+Every floor is prefixed by its citation like [B12]. Reply (留言) excerpts follow their floor with ">".
+Answer in Traditional Chinese Markdown with exactly two sections:
+## 話題
+- **topic title**: one or two sentence summary [B12] [B15]
+## 精選語錄
+- > quote copied verbatim from a floor [B12]
+Only cite floors that appear in the input. Pick at most 3 quotes.`
+
+	reduceSystemPrompt = `You merge partial digests of one Bahamut forum thread into a single digest.
+Merge topics that are the same, keep at most 8 topics ordered by how many floors discuss them,
+and keep at most 5 of the most notable quotes. Keep every citation like [B12] of a kept line.
+Answer in Traditional Chinese Markdown with exactly the sections "## 話題" and "## 精選語錄".`
+)
+
+var (
+	citationRegexp  = regexp.MustCompile(`\[B(\d+)\]`)
+	referenceRegexp = regexp.MustCompile(`(?i)\bB(\d+)\b`)
+)
+
+// Window selects the floors of a digest, either by page or by post time.
+type Window struct {
+	FromPage int
+	ToPage   int
+
+	From time.Time
+	To   time.Time
+}
+
+func PageWindow(fromPage, toPage int) Window {
+	return Window{FromPage: fromPage, ToPage: toPage}
+}
+
+func TimeWindow(from, to time.Time) Window {
+	return Window{From: from, To: to}
+}
+
+func (window Window) IsPageWindow() bool {
+	return window.FromPage > 0
+}
+
+func (window Window) String() string {
+	if window.IsPageWindow() {
+		return fmt.Sprintf("第 %d ~ %d 頁", window.FromPage, window.ToPage)
+	}
+	return fmt.Sprintf("%s ~ %s", window.From.In(craw.BahaTimeZone).Format("2006-01-02 15:04"),
+		window.To.In(craw.BahaTimeZone).Format("2006-01-02 15:04"))
+}
+
+type Option func(*Generator)
+
+func MaxChunkTokens(tokens int) Option {
+	return func(o *Generator) {
+		o.maxChunkTokens = tokens
+	}
+}
+
+// TopN is the number of floors listed in the ranking sections.
+func TopN(n int) Option {
+	return func(o *Generator) {
+		o.topN = n
+	}
+}
+
+type Digest struct {
+	Building *db.BuildingRecord
+	Window   Window
+
+	FloorCount int
+	ReplyCount int
+	Markdown   string
+}
+
+// Generator writes Markdown digests of archived floors. Topics and quotes
+// come from the model, rankings are counted locally.
+type Generator struct {
+	provider llm.Provider
+	db       db.BuildingDB
+
+	maxChunkTokens int
+	topN           int
+}
+
+func NewGenerator(provider llm.Provider, buildingDb db.BuildingDB, opts ...Option) *Generator {
+	generator := &Generator{
+		provider:       provider,
+		db:             buildingDb,
+		maxChunkTokens: DefaultMaxChunkTokens,
+		topN:           DefaultTopN,
+	}
+	for _, opt := range opts {
+		opt(generator)
+	}
+	return generator
+}
+
+type floorStat struct {
+	floor      *db.FloorRecord
+	text       string
+	replies    []*db.ReplyRecord
+	references int
+}
+
+func (g *Generator) loadFloors(bid string, window Window) ([]*floorStat, error) {
+	var floors []*db.FloorRecord
+	var err error
+	if window.IsPageWindow() {
+		floors, err = g.db.GetFloorRecordsByPageRange(bid, window.FromPage, window.ToPage)
+	} else {
+		floors, err = g.db.GetFloorRecordsByTimeRange(bid, window.From, window.To)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("db.GetFloorRecords failed")
+		return nil, err
+	}
+
+	stats := make([]*floorStat, 0, len(floors))
+	byIndex := make(map[int]*floorStat, len(floors))
+	for _, floor := range floors {
+		replies, err := g.db.GetReplyRecords(floor.Fid)
+		if err != nil {
+			logrus.WithError(err).Error("db.GetReplyRecords failed")
+			return nil, err
+		}
+
+		stat := &floorStat{floor: floor, text: textutil.PlainText(floor.Content), replies: replies}
+		stats = append(stats, stat)
+		byIndex[floor.FloorIndex] = stat
+	}
+
+	// A floor mentioning "B12" joins the discussion of floor 12
+	for _, stat := range stats {
+		seen := make(map[int]bool)
+		for _, match := range referenceRegexp.FindAllStringSubmatch(stat.text, -1) {
+			index, _ := strconv.Atoi(match[1])
+			if target, exist := byIndex[index]; exist && index != stat.floor.FloorIndex && !seen[index] {
+				seen[index] = true
+				target.references++
+			}
+		}
+	}
+	return stats, nil
+}
+
+func (stat *floorStat) String() string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("[B%d] %s: %s\n", stat.floor.FloorIndex, stat.floor.AuthorName,
+		strings.ReplaceAll(textutil.Excerpt(stat.text, floorExcerptLength), "\n", " ")))
+	for i, reply := range stat.replies {
+		if i == repliesPerFloor {
+			break
+		}
+		builder.WriteString(fmt.Sprintf("> %s: %s\n", reply.AuthorName, textutil.Excerpt(reply.Content, replyExcerptLength)))
+	}
+	return builder.String()
+}
+
+func (g *Generator) summarizeTopics(ctx context.Context, stats []*floorStat) (string, error) {
+	texts := make([]string, 0, len(stats))
+	for _, stat := range stats {
+		texts = append(texts, stat.String())
+	}
+
+	partials := make([]string, 0)
+	chunks := llm.ChunkTexts(texts, g.maxChunkTokens, 1)
+	for i, chunk := range chunks {
+		logrus.Infof("Clustering chunk %d/%d", i+1, len(chunks))
+		partial, err := llm.CompleteText(ctx, g.provider, mapSystemPrompt, chunk)
+		if err != nil {
+			return "", err
+		}
+		partials = append(partials, partial)
+	}
+
+	for len(partials) > 1 {
+		merged := make([]string, 0)
+		for _, group := range llm.ChunkTexts(partials, g.maxChunkTokens, 2) {
+			summary, err := llm.CompleteText(ctx, g.provider, reduceSystemPrompt, group)
+			if err != nil {
+				return "", err
+			}
+			merged = append(merged, summary)
+		}
+		partials = merged
+	}
+	return partials[0], nil
+}
+
+func linkOf(target craw.TargetInfo, floor *db.FloorRecord) string {
+	return fmt.Sprintf("[B%d](%s)", floor.FloorIndex, target.GetPageUrl(floor.PageIndex))
+}
+
+func (g *Generator) writeRanking(builder *strings.Builder, target craw.TargetInfo, stats []*floorStat, score func(*floorStat) int, describe func(*floorStat) string) {
+	ranked := make([]*floorStat, len(stats))
+	copy(ranked, stats)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return score(ranked[i]) > score(ranked[j])
+	})
+
+	for i, stat := range ranked {
+		if i == g.topN || score(stat) == 0 {
+			break
+		}
+		builder.WriteString(fmt.Sprintf("%d. %s **%s**：%s（%s）\n", i+1, linkOf(target, stat.floor), stat.floor.AuthorName,
+			strings.ReplaceAll(textutil.Excerpt(stat.text, 60), "\n", " "), describe(stat)))
+	}
+	builder.WriteString("\n")
+}
+
+// Generate builds the digest of building within window from the archive.
+func (g *Generator) Generate(ctx context.Context, building *db.BuildingRecord, window Window) (*Digest, error) {
+	ctx = llm.WithUsageTags(ctx, building.Id, "digest:"+window.String())
+
+	stats, err := g.loadFloors(building.Id, window)
+	if err != nil {
+		logrus.WithError(err).Error("loadFloors failed")
+		return nil, err
+	}
+	if len(stats) == 0 {
+		return nil, fmt.Errorf("no archived floors in %s of building %s", window, building.Id)
+	}
+
+	topics, err := g.summarizeTopics(ctx, stats)
+	if err != nil {
+		logrus.WithError(err).Error("summarizeTopics failed")
+		return nil, err
+	}
+
+	target := craw.TargetInfo{Bsn: building.Bsn, Sna: building.Sna}
+	byIndex := make(map[int]*db.FloorRecord, len(stats))
+	authors := make(map[string]bool)
+	replyCount := 0
+	for _, stat := range stats {
+		byIndex[stat.floor.FloorIndex] = stat.floor
+		authors[stat.floor.AuthorId] = true
+		replyCount += len(stat.replies)
+	}
+
+	// Turn citations of the model into links to the page of the floor
+	topics = citationRegexp.ReplaceAllStringFunc(topics, func(citation string) string {
+		index, _ := strconv.Atoi(citationRegexp.FindStringSubmatch(citation)[1])
+		if floor, exist := byIndex[index]; exist {
+			return linkOf(target, floor)
+		}
+		return citation
+	})
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("# %s 摘要\n\n", building.BuildingTitle))
+	builder.WriteString(fmt.Sprintf("- 範圍：%s\n", window))
+	builder.WriteString(fmt.Sprintf("- 樓層：B%d ~ B%d，共 %d 樓，%d 則留言，%d 位發文者\n",
+		stats[0].floor.FloorIndex, stats[len(stats)-1].floor.FloorIndex, len(stats), replyCount, len(authors)))
+	builder.WriteString(fmt.Sprintf("- 大樓：%s\n\n", target.GetBuildingUrl()))
+	builder.WriteString(topics)
+	builder.WriteString("\n\n## 熱門討論串\n\n")
+	g.writeRanking(&builder, target, stats,
+		func(stat *floorStat) int { return stat.references + len(stat.replies) },
+		func(stat *floorStat) string {
+			return fmt.Sprintf("被引用 %d 次，%d 則留言", stat.references, len(stat.replies))
+		})
+	builder.WriteString("## 最多留言樓層\n\n")
+	g.writeRanking(&builder, target, stats,
+		func(stat *floorStat) int { return len(stat.replies) },
+		func(stat *floorStat) string { return fmt.Sprintf("%d 則留言", len(stat.replies)) })
+
+	return &Digest{
+		Building:   building,
+		Window:     window,
+		FloorCount: len(stats),
+		ReplyCount: replyCount,
+		Markdown:   builder.String(),
+	}, nil
+}
+
+// WriteFile generates the digest and stores it as Markdown under dir,
+// returning the path of the file.
+func (g *Generator) WriteFile(ctx context.Context, building *db.BuildingRecord, window Window, dir string) (string, error) {
+	digest, err := g.Generate(ctx, building, window)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logrus.WithError(err).Error("os.MkdirAll failed")
+		return "", err
+	}
+
+	name := fmt.Sprintf("%d-%s.md", building.Sna, time.Now().In(craw.BahaTimeZone).Format("20060102-150405"))
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(digest.Markdown), 0644); err != nil {
+		logrus.WithError(err).Error("os.WriteFile failed")
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,94 @@
+package digest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/llm"
+)
+
+// countProvider counts the chats of every system prompt.
+type countProvider struct {
+	llm.Provider
+	calls map[string]int
+}
+
+func (provider *countProvider) Chat(ctx context.Context, request *llm.ChatRequest) (*llm.Completion, error) {
+	provider.calls[request.Messages[0].Content]++
+	return provider.Provider.Chat(ctx, request)
+}
+
+// openTestDb opens a new building.db in a temporary directory, the path of
+// the db is relative to the working directory.
+func openTestDb(t *testing.T) db.BuildingDB {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return buildingDb
+}
+
+func TestGenerateMapReduce(t *testing.T) {
+	buildingDb := openTestDb(t)
+	page, err := buildingDb.SyncPageRecord(60076, 123, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		content := fmt.Sprintf("floor %d", i)
+		if i > 1 {
+			content = "reply to B1"
+		}
+		floor := &db.FloorRecord{Bid: page.Bid, Pid: page.Pid, FloorIndex: i, AuthorName: "baha", AuthorId: "baha", Content: content}
+		if _, err := buildingDb.SyncFloorRecord(floor, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	building, err := buildingDb.GetBuildingRecord(60076, 123)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &countProvider{
+		Provider: llm.NewFakeProvider(
+			&llm.FakeResponse{Match: `^\[B\d+\]`, Content: "## 話題\n- **partial**: one floor [B1]", Repeat: true},
+			&llm.FakeResponse{Match: `^## 話題`, Content: "## 話題\n- **merged**: every floor [B2]", Repeat: true},
+		),
+		calls: make(map[string]int),
+	}
+
+	// every floor is a chunk of its own, partials are merged two at a time
+	generator := NewGenerator(provider, buildingDb, MaxChunkTokens(30))
+	digest, err := generator.Generate(context.Background(), building, PageWindow(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if provider.calls[mapSystemPrompt] != 4 || provider.calls[reduceSystemPrompt] != 3 {
+		t.Errorf("calls = %d map, %d reduce", provider.calls[mapSystemPrompt], provider.calls[reduceSystemPrompt])
+	}
+	if digest.FloorCount != 4 {
+		t.Errorf("floor count = %d", digest.FloorCount)
+	}
+	if !strings.Contains(digest.Markdown, "**merged**: every floor [B2](") || strings.Contains(digest.Markdown, "partial") {
+		t.Errorf("topics not merged or linked:\n%s", digest.Markdown)
+	}
+	// B1 is referenced by the three later floors
+	if !strings.Contains(digest.Markdown, "被引用 3 次") {
+		t.Errorf("ranking missing:\n%s", digest.Markdown)
+	}
+}
@@ -0,0 +1,223 @@
+package event
+
+import (
+	"sync"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultBufferSize = 64
+
+// Policy decides what Publish does when the buffer of a subscriber is full.
+type Policy int
+
+const (
+	// Block makes the publisher wait for the subscriber, a slow subscriber
+	// slows the rule which published down.
+	Block Policy = iota
+	// DropNewest drops the event being published.
+	DropNewest
+	// DropOldest drops the oldest event waiting in the buffer.
+	DropOldest
+)
+
+type Handler func(event *Event)
+
+type SubscribeOption func(*subscription)
+
+// WithFilter passes only the events every filter wants.
+func WithFilter(filters ...Filter) SubscribeOption {
+	return func(s *subscription) {
+		s.filters = append(s.filters, filters...)
+	}
+}
+
+func BufferSize(size int) SubscribeOption {
+	return func(s *subscription) {
+		s.bufferSize = size
+	}
+}
+
+func OnFull(policy Policy) SubscribeOption {
+	return func(s *subscription) {
+		s.policy = policy
+	}
+}
+
+type subscription struct {
+	name       string
+	handler    Handler
+	filters    []Filter
+	bufferSize int
+	policy     Policy
+
+	eventCh  chan *Event
+	doneCh   chan struct{}
+	stopOnce sync.Once
+	exitCh   chan struct{}
+}
+
+// Bus hands every published event to the subscribers which want it, each
+// subscriber runs its handler on its own goroutine in publishing order.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions map[*subscription]bool
+	closed        bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subscriptions: make(map[*subscription]bool)}
+}
+
+// Subscribe starts handing events to handler until the returned function
+// is called or the bus is closed, name labels the subscriber in logs and
+// metrics.
+func (bus *Bus) Subscribe(name string, handler Handler, opts ...SubscribeOption) func() {
+	s := &subscription{
+		name:       name,
+		handler:    handler,
+		bufferSize: DefaultBufferSize,
+		policy:     Block,
+		doneCh:     make(chan struct{}),
+		exitCh:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.bufferSize < 1 {
+		s.bufferSize = 1
+	}
+	s.eventCh = make(chan *Event, s.bufferSize)
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.closed {
+		logrus.Warnf("Subscriber %s subscribed to a closed bus", name)
+		return func() {}
+	}
+	bus.subscriptions[s] = true
+	go s.run()
+
+	return func() {
+		s.stop()
+		bus.mu.Lock()
+		delete(bus.subscriptions, s)
+		bus.mu.Unlock()
+	}
+}
+
+// Publish returns once every subscriber which wants event has it queued,
+// or dropped it by its Policy.
+func (bus *Bus) Publish(event *Event) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+
+	if bus.closed {
+		return
+	}
+	metrics.EventsPublished.WithLabelValues(string(event.Type)).Inc()
+	for s := range bus.subscriptions {
+		if s.wants(event) {
+			s.push(event)
+		}
+	}
+}
+
+// Close stops every subscriber once the events queued for it are handled,
+// events published afterwards are dropped.
+func (bus *Bus) Close() {
+	bus.mu.Lock()
+	if bus.closed {
+		bus.mu.Unlock()
+		return
+	}
+	bus.closed = true
+	subscriptions := bus.subscriptions
+	bus.subscriptions = make(map[*subscription]bool)
+	bus.mu.Unlock()
+
+	for s := range subscriptions {
+		s.stop()
+		<-s.exitCh
+	}
+}
+
+func (s *subscription) wants(event *Event) bool {
+	for _, filter := range s.filters {
+		if !filter(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *subscription) push(event *Event) {
+	switch s.policy {
+	case DropNewest:
+		select {
+		case s.eventCh <- event:
+		default:
+			s.dropped(event)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.eventCh <- event:
+				return
+			default:
+			}
+			select {
+			case oldest := <-s.eventCh:
+				s.dropped(oldest)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.eventCh <- event:
+		case <-s.doneCh:
+		}
+	}
+}
+
+func (s *subscription) dropped(event *Event) {
+	metrics.EventsDropped.WithLabelValues(s.name).Inc()
+	logrus.Warnf("Subscriber %s is full, %s of %s dropped", s.name, event.Type, event.RuleKey)
+}
+
+func (s *subscription) stop() {
+	s.stopOnce.Do(func() { close(s.doneCh) })
+}
+
+// run handles events until stopped, then the events already queued.
+func (s *subscription) run() {
+	defer close(s.exitCh)
+
+	for {
+		select {
+		case event := <-s.eventCh:
+			s.handle(event)
+		case <-s.doneCh:
+			for {
+				select {
+				case event := <-s.eventCh:
+					s.handle(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle keeps a panicking handler from taking the publisher down.
+func (s *subscription) handle(event *Event) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logrus.Errorf("Subscriber %s panicked on %s: %v", s.name, event.Type, recovered)
+		}
+	}()
+	s.handler(event)
+}
@@ -0,0 +1,83 @@
+package event
+
+import (
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/textutil"
+)
+
+type Type string
+
+const (
+	// NewFloor is a floor posted since the last poll which passed the
+	// filters of the rule.
+	NewFloor Type = "new_floor"
+	// FloorEdited is an edit of the text of the last floor seen by the
+	// rule, Previous holds the floor before the edit and Diff the change.
+	FloorEdited Type = "floor_edited"
+	// FloorDeleted is the last floor seen by the rule gone from its page.
+	FloorDeleted Type = "floor_deleted"
+	NewReply     Type = "new_reply"
+	// RuleFailed is a rule stopped by a terminal error, Err holds it.
+	RuleFailed Type = "rule_failed"
+	// NewThread is a thread opened on a board since the last poll which
+	// passed the filters of a board rule, Thread holds it.
+	NewThread Type = "new_thread"
+	// SessionExpired is a page which could not be read since the login
+	// session of the crawler is gone.
+	SessionExpired Type = "session_expired"
+)
+
+// Event is published by the monitor for every subscriber. The records are
+// shared between subscribers and must not be modified.
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+
+	RuleKey  string `json:"rule_key,omitempty"`
+	RuleName string `json:"rule_name,omitempty"`
+	Bsn      int    `json:"bsn,omitempty"`
+	Sna      int    `json:"sna,omitempty"`
+	Url      string `json:"url,omitempty"`
+
+	Floor    *db.FloorRecord  `json:"floor,omitempty"`
+	Previous *db.FloorRecord  `json:"previous,omitempty"`
+	Reply    *db.ReplyRecord  `json:"reply,omitempty"`
+	Thread   *db.ThreadRecord `json:"thread,omitempty"`
+	// Terms are the keywords and patterns matched by a watch rule
+	Terms []string `json:"terms,omitempty"`
+	// Diff is the change of the plain text of a FloorEdited
+	Diff []textutil.DiffChunk `json:"diff,omitempty"`
+
+	Err error `json:"-"`
+}
+
+// Filter tells whether a subscriber wants an event.
+type Filter func(event *Event) bool
+
+func Types(types ...Type) Filter {
+	wanted := make(map[Type]bool)
+	for _, t := range types {
+		wanted[t] = true
+	}
+	return func(event *Event) bool {
+		return wanted[event.Type]
+	}
+}
+
+func Rules(keys ...string) Filter {
+	wanted := make(map[string]bool)
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	return func(event *Event) bool {
+		return wanted[event.RuleKey]
+	}
+}
+
+func Building(bsn, sna int) Filter {
+	return func(event *Event) bool {
+		return event.Bsn == bsn && event.Sna == sna
+	}
+}
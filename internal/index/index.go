@@ -0,0 +1,168 @@
+package index
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultMaxPages  = 100
+	DefaultPageDelay = time.Second
+)
+
+type Option func(*Indexer)
+
+// MaxPages bounds the pages of the thread list read by a single refresh.
+func MaxPages(pages int) Option {
+	return func(indexer *Indexer) {
+		indexer.maxPages = pages
+	}
+}
+
+// PageDelay is the pause between two pages of the thread list.
+func PageDelay(delay time.Duration) Option {
+	return func(indexer *Indexer) {
+		indexer.pageDelay = delay
+	}
+}
+
+// Indexer keeps the thread index of boards in thread_record.
+type Indexer struct {
+	crawler craw.Crawler
+	db      db.ThreadDB
+
+	maxPages  int
+	pageDelay time.Duration
+}
+
+func NewIndexer(crawler craw.Crawler, threadDb db.ThreadDB, opts ...Option) *Indexer {
+	indexer := &Indexer{
+		crawler:   crawler,
+		db:        threadDb,
+		maxPages:  DefaultMaxPages,
+		pageDelay: DefaultPageDelay,
+	}
+	for _, opt := range opts {
+		opt(indexer)
+	}
+	return indexer
+}
+
+// Result counts the threads a refresh has read.
+type Result struct {
+	Pages     int
+	New       int
+	Updated   int
+	Unchanged int
+}
+
+// Refresh reads the thread list of the board page by page into the index.
+// The list is ordered by the last reply, so the first page holding only
+// threads indexed with the same floor count ends an incremental refresh,
+// while a full one reads up to the last page or MaxPages.
+func (indexer *Indexer) Refresh(ctx context.Context, bsn int, full bool) (*Result, error) {
+	result := &Result{}
+	seen := make(map[int]bool)
+
+	for page := 1; page <= indexer.maxPages; page++ {
+		if page > 1 && !sleep(ctx, indexer.pageDelay) {
+			return result, ctx.Err()
+		}
+
+		threads, err := indexer.crawler.ParseBoardPage(craw.GetBoardPageUrl(bsn, page))
+		if err != nil {
+			logrus.WithError(err).Errorf("ParseBoardPage %d of board %d failed", page, bsn)
+			return result, err
+		}
+		result.Pages++
+
+		changed, repeated := false, true
+		for _, thread := range threads {
+			if thread.Sticky {
+				// sticky threads are listed on the first page whatever their activity is
+				if err := indexer.save(thread, result); err != nil {
+					return result, err
+				}
+				continue
+			}
+			if seen[thread.Sna] {
+				continue
+			}
+			seen[thread.Sna] = true
+			repeated = false
+
+			unchanged := result.Unchanged
+			if err := indexer.save(thread, result); err != nil {
+				return result, err
+			}
+			if result.Unchanged == unchanged {
+				changed = true
+			}
+		}
+
+		// a page past the last one lists nothing new, or the last page again
+		if repeated {
+			break
+		}
+		if !changed && !full {
+			break
+		}
+	}
+
+	logrus.Infof("Index of board %d refreshed from %d pages, %d new, %d updated, %d unchanged",
+		bsn, result.Pages, result.New, result.Updated, result.Unchanged)
+	return result, nil
+}
+
+// save writes the thread unless it is indexed with the same listing.
+func (indexer *Indexer) save(thread *db.ThreadRecord, result *Result) error {
+	now := time.Now()
+	thread.FirstSeenAt, thread.UpdatedAt = now, now
+
+	existing, err := indexer.db.GetThreadRecord(thread.Bsn, thread.Sna)
+	switch {
+	case err == sql.ErrNoRows:
+		result.New++
+	case err != nil:
+		logrus.WithError(err).Error("db.GetThreadRecord failed")
+		return err
+	case sameListing(existing, thread):
+		result.Unchanged++
+		return nil
+	default:
+		thread.FirstSeenAt = existing.FirstSeenAt
+		result.Updated++
+	}
+
+	if err := indexer.db.SaveThreadRecord(thread); err != nil {
+		logrus.WithError(err).Error("db.SaveThreadRecord failed")
+		return err
+	}
+	return nil
+}
+
+// sameListing ignores the last reply time, which the list shows relative to
+// now and drifts between two reads of the same listing.
+func sameListing(a, b *db.ThreadRecord) bool {
+	return a.Title == b.Title && a.Category == b.Category &&
+		a.Sticky == b.Sticky && a.Gp == b.Gp &&
+		a.FloorCount == b.FloorCount && a.ReplyCount == b.ReplyCount &&
+		a.LastReplyAuthorId == b.LastReplyAuthorId
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
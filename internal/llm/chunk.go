@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EstimateTokens counts one token per rune, which overestimates latin text
+// and is close for CJK text, the bulk of Baha posts.
+func EstimateTokens(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// ChunkTexts packs texts into chunks of at most maxTokens for a map-reduce
+// over many calls. A chunk always holds at least minItems texts when
+// available, so repeated reduction ends.
+func ChunkTexts(texts []string, maxTokens, minItems int) []string {
+	chunks := make([]string, 0)
+	current := make([]string, 0)
+	tokens := 0
+
+	for _, text := range texts {
+		size := EstimateTokens(text)
+		if len(current) >= minItems && tokens+size > maxTokens {
+			chunks = append(chunks, strings.Join(current, "\n"))
+			current, tokens = make([]string, 0), 0
+		}
+		current = append(current, text)
+		tokens += size
+	}
+	if len(current) != 0 {
+		chunks = append(chunks, strings.Join(current, "\n"))
+	}
+	return chunks
+}
+
+// CompleteText is a single turn completion of user under the system prompt,
+// it returns the trimmed content only.
+func CompleteText(ctx context.Context, provider Provider, system, user string) (string, error) {
+	completion, err := Complete(ctx, provider, []Message{
+		{Role: RoleSystem, Content: system},
+		{Role: RoleUser, Content: user},
+	})
+	if err != nil {
+		logrus.WithError(err).Error("llm.Complete failed")
+		return "", err
+	}
+	return strings.TrimSpace(completion.Content), nil
+}
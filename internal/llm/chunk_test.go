@@ -0,0 +1,26 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChunkTexts(t *testing.T) {
+	texts := []string{"巴哈姆特", "ab", "cdef", "一二三四五六"}
+
+	chunks := ChunkTexts(texts, 6, 1)
+	want := []string{"巴哈姆特\nab", "cdef", "一二三四五六"}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("chunks = %q, want %q", chunks, want)
+	}
+
+	// a chunk keeps minItems texts even past maxTokens
+	chunks = ChunkTexts(texts, 1, 2)
+	if len(chunks) != 2 || chunks[0] != "巴哈姆特\nab" {
+		t.Errorf("chunks of 2 = %q", chunks)
+	}
+
+	if chunks := ChunkTexts(nil, 10, 1); len(chunks) != 0 {
+		t.Errorf("chunks of nothing = %q", chunks)
+	}
+}
@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FakeModel = "fake"
+
+	fakeEmbeddingDimension = 64
+)
+
+// FakeResponse is one canned reply of the fake provider. When Match is set
+// the reply is only used for requests whose last message matches it.
+type FakeResponse struct {
+	Match     string      `json:"match,omitempty"`
+	Content   string      `json:"content"`
+	ToolCalls []*ToolCall `json:"tool_calls,omitempty"`
+	// Repeat keeps the reply in the script after it was used
+	Repeat bool `json:"repeat,omitempty"`
+
+	matcher *regexp.Regexp
+}
+
+// fakeProvider replays a script of responses without any network, so every
+// LLM feature can run deterministically offline.
+type fakeProvider struct {
+	mu        sync.Mutex
+	responses []*FakeResponse
+}
+
+var _ Provider = (*fakeProvider)(nil)
+
+func NewFakeProvider(responses ...*FakeResponse) Provider {
+	provider := &fakeProvider{}
+	for _, response := range responses {
+		if response.Match != "" {
+			response.matcher = regexp.MustCompile(response.Match)
+		}
+		provider.responses = append(provider.responses, response)
+	}
+	return provider
+}
+
+// LoadFakeProvider reads a JSON array of FakeResponse.
+func LoadFakeProvider(path string) (Provider, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		logrus.WithError(err).Error("os.ReadFile failed")
+		return nil, err
+	}
+
+	responses := make([]*FakeResponse, 0)
+	if err := json.Unmarshal(raw, &responses); err != nil {
+		logrus.WithError(err).Error("Failed to unmarshal JSON")
+		return nil, err
+	}
+
+	for _, response := range responses {
+		if response.Match == "" {
+			continue
+		}
+		if _, err := regexp.Compile(response.Match); err != nil {
+			logrus.WithError(err).Errorf("invalid match %s", response.Match)
+			return nil, err
+		}
+	}
+	return NewFakeProvider(responses...), nil
+}
+
+func (p *fakeProvider) Model() string {
+	return FakeModel
+}
+
+func countTokens(texts ...string) int {
+	tokens := 0
+	for _, text := range texts {
+		tokens += utf8.RuneCountInString(text)
+	}
+	return tokens
+}
+
+func (p *fakeProvider) Chat(ctx context.Context, request *ChatRequest) (*Completion, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	last := ""
+	prompt := make([]string, 0, len(request.Messages))
+	for _, message := range request.Messages {
+		prompt = append(prompt, message.Content)
+		last = message.Content
+	}
+
+	for i, response := range p.responses {
+		if response.matcher != nil && !response.matcher.MatchString(last) {
+			continue
+		}
+		if !response.Repeat {
+			p.responses = append(p.responses[:i:i], p.responses[i+1:]...)
+		}
+		return &Completion{
+			Model:            FakeModel,
+			Content:          response.Content,
+			ToolCalls:        response.ToolCalls,
+			PromptTokens:     countTokens(prompt...),
+			CompletionTokens: countTokens(response.Content),
+		}, nil
+	}
+
+	logrus.Errorf("fake provider has no response for %q", last)
+	return nil, fmt.Errorf("fake provider has no response for %q", last)
+}
+
+func (p *fakeProvider) ChatStream(ctx context.Context, request *ChatRequest, onDelta func(delta string) error) (*Completion, error) {
+	completion, err := p.Chat(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range strings.SplitAfter(completion.Content, " ") {
+		if field == "" {
+			continue
+		}
+		if err := onDelta(field); err != nil {
+			return nil, err
+		}
+	}
+	return completion, nil
+}
+
+// Embed hashes the runes of every input into a normalized vector, equal
+// inputs always get equal vectors and similar inputs similar ones.
+func (p *fakeProvider) Embed(ctx context.Context, inputs []string) (*Embeddings, error) {
+	embeddings := &Embeddings{Model: FakeModel, Vectors: make([][]float64, 0, len(inputs))}
+
+	for _, input := range inputs {
+		vector := make([]float64, fakeEmbeddingDimension)
+		for _, r := range input {
+			hash := fnv.New32a()
+			hash.Write([]byte(string(r)))
+			vector[hash.Sum32()%fakeEmbeddingDimension]++
+		}
+
+		norm := 0.0
+		for _, value := range vector {
+			norm += value * value
+		}
+		if norm = math.Sqrt(norm); norm > 0 {
+			for i := range vector {
+				vector[i] /= norm
+			}
+		}
+		embeddings.Vectors = append(embeddings.Vectors, vector)
+		embeddings.PromptTokens += countTokens(input)
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFakeProviderScript(t *testing.T) {
+	script := `[
+		{"match": "^count", "content": "42", "repeat": true},
+		{"content": "first"},
+		{"content": "second"}
+	]`
+	path := filepath.Join(t.TempDir(), "script.json")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := LoadFakeProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		prompt  string
+		content string
+	}{
+		{"count the floors", "42"},
+		{"hello", "first"},
+		{"count again", "42"},
+		{"hello", "second"},
+	}
+	for _, w := range want {
+		completion, err := provider.Chat(context.Background(), userRequest(w.prompt))
+		if err != nil {
+			t.Fatalf("%s: %v", w.prompt, err)
+		}
+		if completion.Content != w.content {
+			t.Errorf("%s = %q, want %q", w.prompt, completion.Content, w.content)
+		}
+	}
+
+	if _, err := provider.Chat(context.Background(), userRequest("hello")); err == nil {
+		t.Error("expect error once the script is used up")
+	}
+}
+
+func TestLoadFakeProviderInvalidMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.json")
+	if err := os.WriteFile(path, []byte(`[{"match": "(", "content": "x"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFakeProvider(path); err == nil {
+		t.Error("expect error for an invalid match")
+	}
+}
@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ProviderOpenAI = "openai"
+	ProviderFake   = "fake"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+
+	// ToolCalls are set on assistant messages asking for tools, ToolCallId
+	// on the tool message answering one of them.
+	ToolCalls  []*ToolCall `json:"tool_calls,omitempty"`
+	ToolCallId string      `json:"tool_call_id,omitempty"`
+}
+
+// Tool is a function the model may call, Parameters is its JSON schema.
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+type ToolCall struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type ChatRequest struct {
+	Messages []Message
+	Tools    []*Tool
+
+	Temperature float64
+	MaxTokens   int
+}
+
+type Completion struct {
+	Model     string
+	Content   string
+	ToolCalls []*ToolCall
+
+	PromptTokens     int
+	CompletionTokens int
+}
+
+type Embeddings struct {
+	Model   string
+	Vectors [][]float64
+
+	PromptTokens int
+}
+
+// Provider is a chat and embedding model behind any OpenAI compatible
+// endpoint, or the scripted fake used without network.
+type Provider interface {
+	Model() string
+
+	Chat(ctx context.Context, request *ChatRequest) (*Completion, error)
+	// ChatStream calls onDelta with every piece of content as it arrives and
+	// returns the whole completion at the end.
+	ChatStream(ctx context.Context, request *ChatRequest, onDelta func(delta string) error) (*Completion, error)
+
+	Embed(ctx context.Context, inputs []string) (*Embeddings, error)
+}
+
+// Complete is a plain chat completion without tools.
+func Complete(ctx context.Context, provider Provider, messages []Message) (*Completion, error) {
+	return provider.Chat(ctx, &ChatRequest{Messages: messages})
+}
+
+// NewProviderFromEnv builds the provider chosen by LLM_PROVIDER from the
+// environment, which is expected to be loaded from .env by the caller.
+//
+//	LLM_PROVIDER            openai (default) or fake
+//	OPENAI_API_KEY          not required by local servers like llama.cpp or Ollama
+//	OPENAI_BASE_URL         e.g. http://localhost:11434/v1
+//	OPENAI_MODEL            chat model
+//	OPENAI_EMBEDDING_MODEL  embedding model
+//	LLM_FAKE_SCRIPT         JSON script replayed by the fake provider
+func NewProviderFromEnv() (Provider, error) {
+	switch provider := strings.ToLower(os.Getenv("LLM_PROVIDER")); provider {
+	case "", ProviderOpenAI:
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		baseUrl := os.Getenv("OPENAI_BASE_URL")
+		if apiKey == "" && (baseUrl == "" || baseUrl == DefaultBaseUrl) {
+			logrus.Error("OPENAI_API_KEY is not set")
+			return nil, fmt.Errorf("OPENAI_API_KEY is not set")
+		}
+		return NewOpenAIProvider(
+			OpenAIApiKey(apiKey),
+			OpenAIBaseUrl(baseUrl),
+			OpenAIModel(os.Getenv("OPENAI_MODEL")),
+			OpenAIEmbeddingModel(os.Getenv("OPENAI_EMBEDDING_MODEL")),
+		), nil
+	case ProviderFake:
+		path := os.Getenv("LLM_FAKE_SCRIPT")
+		if path == "" {
+			return NewFakeProvider(), nil
+		}
+		return LoadFakeProvider(path)
+	default:
+		logrus.Errorf("unknown LLM_PROVIDER %s", provider)
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %s", provider)
+	}
+}
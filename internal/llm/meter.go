@@ -0,0 +1,402 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrBudgetExceeded = errors.New("monthly llm budget exceeded")
+	ErrUnpricedModel  = errors.New("llm model has no price")
+)
+
+// Price is the USD cost per one million tokens.
+type Price struct {
+	Input  float64
+	Output float64
+}
+
+// DefaultPrices is matched by model name prefix, the longest prefix wins.
+var DefaultPrices = map[string]Price{
+	"gpt-4o-mini":   {Input: 0.15, Output: 0.6},
+	"gpt-4o":        {Input: 2.5, Output: 10},
+	"gpt-4-turbo":   {Input: 10, Output: 30},
+	"gpt-4":         {Input: 30, Output: 60},
+	"gpt-3.5-turbo": {Input: 0.5, Output: 1.5},
+
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+}
+
+func (price Price) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1e6
+}
+
+type usageTagsKey struct{}
+
+type usageTags struct {
+	bid      string
+	question string
+}
+
+// WithUsageTags attaches the building and the question a call is made for,
+// so the usage record of every call below ctx can be grouped by them.
+func WithUsageTags(ctx context.Context, bid, question string) context.Context {
+	return context.WithValue(ctx, usageTagsKey{}, usageTags{bid: bid, question: question})
+}
+
+func getUsageTags(ctx context.Context) usageTags {
+	tags, _ := ctx.Value(usageTagsKey{}).(usageTags)
+	return tags
+}
+
+type MeterOption func(*meter)
+
+// MonthlyBudget refuses calls once the spend of the current month reaches
+// budget USD, 0 means unlimited.
+func MonthlyBudget(budget float64) MeterOption {
+	return func(o *meter) {
+		o.budget = budget
+	}
+}
+
+func ModelPrice(price Price) MeterOption {
+	return func(o *meter) {
+		o.price = &price
+	}
+}
+
+// FallbackPrice prices the models matched by neither ModelPrice nor
+// DefaultPrices. Without it a model without price is refused once a budget
+// is set, as its calls would never count against the budget.
+func FallbackPrice(price Price) MeterOption {
+	return func(o *meter) {
+		o.fallbackPrice = &price
+	}
+}
+
+func DisableCache() MeterOption {
+	return func(o *meter) {
+		o.cache = false
+	}
+}
+
+type meter struct {
+	provider Provider
+	db       db.UsageDB
+
+	budget        float64
+	price         *Price
+	fallbackPrice *Price
+	cache         bool
+
+	// unpriced keeps the models warned about, once each
+	unpriced sync.Map
+}
+
+var _ Provider = (*meter)(nil)
+
+// NewMeter wraps provider so that every call is checked against the monthly
+// budget and written into llm_usage_record, and chats are cached.
+func NewMeter(provider Provider, usageDb db.UsageDB, opts ...MeterOption) Provider {
+	m := &meter{provider: provider, db: usageDb, cache: true}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func priceFromEnv(inputKey, outputKey string) (*Price, error) {
+	input, output := os.Getenv(inputKey), os.Getenv(outputKey)
+	if input == "" && output == "" {
+		return nil, nil
+	}
+
+	var price Price
+	var err error
+	if price.Input, err = strconv.ParseFloat(input, 64); err != nil {
+		logrus.WithError(err).Errorf("%s is invalid", inputKey)
+		return nil, err
+	}
+	if price.Output, err = strconv.ParseFloat(output, 64); err != nil {
+		logrus.WithError(err).Errorf("%s is invalid", outputKey)
+		return nil, err
+	}
+	return &price, nil
+}
+
+// MeterOptionsFromEnv reads LLM_MONTHLY_BUDGET, LLM_PRICE_INPUT,
+// LLM_PRICE_OUTPUT, LLM_FALLBACK_PRICE_INPUT, LLM_FALLBACK_PRICE_OUTPUT and
+// LLM_CACHE.
+func MeterOptionsFromEnv() ([]MeterOption, error) {
+	opts := make([]MeterOption, 0)
+
+	if budget := os.Getenv("LLM_MONTHLY_BUDGET"); budget != "" {
+		value, err := strconv.ParseFloat(budget, 64)
+		if err != nil {
+			logrus.WithError(err).Error("LLM_MONTHLY_BUDGET is invalid")
+			return nil, err
+		}
+		opts = append(opts, MonthlyBudget(value))
+	}
+
+	price, err := priceFromEnv("LLM_PRICE_INPUT", "LLM_PRICE_OUTPUT")
+	if err != nil {
+		return nil, err
+	}
+	if price != nil {
+		opts = append(opts, ModelPrice(*price))
+	}
+
+	fallback, err := priceFromEnv("LLM_FALLBACK_PRICE_INPUT", "LLM_FALLBACK_PRICE_OUTPUT")
+	if err != nil {
+		return nil, err
+	}
+	if fallback != nil {
+		opts = append(opts, FallbackPrice(*fallback))
+	}
+
+	if cache := os.Getenv("LLM_CACHE"); cache != "" {
+		enabled, err := strconv.ParseBool(cache)
+		if err != nil {
+			logrus.WithError(err).Error("LLM_CACHE is invalid")
+			return nil, err
+		}
+		if !enabled {
+			opts = append(opts, DisableCache())
+		}
+	}
+	return opts, nil
+}
+
+func (m *meter) Model() string {
+	return m.provider.Model()
+}
+
+// priceOf returns the price of model, found is false when neither
+// ModelPrice, DefaultPrices nor FallbackPrice prices it.
+func (m *meter) priceOf(model string) (price Price, found bool) {
+	if m.price != nil {
+		return *m.price, true
+	}
+
+	matched := ""
+	for prefix, p := range DefaultPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			matched, price = prefix, p
+		}
+	}
+	if matched != "" {
+		return price, true
+	}
+
+	if m.fallbackPrice != nil {
+		if _, warned := m.unpriced.LoadOrStore(model, true); !warned {
+			logrus.Warnf("llm model %s has no price, its calls are counted at the fallback price", model)
+		}
+		return *m.fallbackPrice, true
+	}
+	if _, warned := m.unpriced.LoadOrStore(model, true); !warned && m.budget > 0 {
+		logrus.Warnf("llm model %s has no price, its calls do not count against the monthly budget, "+
+			"set LLM_PRICE_INPUT/LLM_PRICE_OUTPUT or LLM_FALLBACK_PRICE_INPUT/LLM_FALLBACK_PRICE_OUTPUT", model)
+	}
+	return Price{}, false
+}
+
+func (m *meter) costOf(model string, promptTokens, completionTokens int) float64 {
+	price, _ := m.priceOf(model)
+	return price.Cost(promptTokens, completionTokens)
+}
+
+func beginningOfMonth(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+}
+
+func cacheKeyOf(model string, request *ChatRequest) string {
+	raw, _ := json.Marshal(request)
+	sum := sha256.Sum256(append([]byte(model+"\n"), raw...))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *meter) record(ctx context.Context, record *db.UsageRecord) {
+	tags := getUsageTags(ctx)
+	record.Bid = tags.bid
+	record.Question = tags.question
+	if !record.CacheHit {
+		metrics.LlmTokens.WithLabelValues(record.Model, "prompt").Add(float64(record.PromptTokens))
+		metrics.LlmTokens.WithLabelValues(record.Model, "completion").Add(float64(record.CompletionTokens))
+	}
+	if err := m.db.CreateUsageRecord(record); err != nil {
+		logrus.WithError(err).Error("db.CreateUsageRecord failed")
+	}
+}
+
+// checkBudget refuses a call once the budget is spent. A chat model
+// without price is refused under a budget, the model of embeddings is only
+// known from their response.
+func (m *meter) checkBudget(model string) error {
+	if m.budget <= 0 {
+		return nil
+	}
+	if model != "" {
+		if _, found := m.priceOf(model); !found {
+			logrus.Errorf("llm model %s has no price to check the monthly budget with", model)
+			return fmt.Errorf("%w: %s", ErrUnpricedModel, model)
+		}
+	}
+
+	spent, err := m.db.GetUsageCostSince(beginningOfMonth(time.Now()))
+	if err != nil {
+		logrus.WithError(err).Error("db.GetUsageCostSince failed")
+		return err
+	}
+	if spent >= m.budget {
+		logrus.Errorf("monthly llm budget %.4f USD exceeded, spent %.4f USD", m.budget, spent)
+		return fmt.Errorf("%w: spent %.4f of %.4f USD", ErrBudgetExceeded, spent, m.budget)
+	}
+	return nil
+}
+
+func (m *meter) getCached(ctx context.Context, cacheKey string) *Completion {
+	if !m.cache {
+		return nil
+	}
+
+	cached, err := m.db.GetLlmCacheRecord(cacheKey)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.GetLlmCacheRecord failed")
+		}
+		return nil
+	}
+
+	m.record(ctx, &db.UsageRecord{
+		CreatedAt:        time.Now(),
+		Model:            cached.Model,
+		PromptTokens:     cached.PromptTokens,
+		CompletionTokens: cached.CompletionTokens,
+		CacheHit:         true,
+	})
+
+	completion := &Completion{
+		Model:            cached.Model,
+		PromptTokens:     cached.PromptTokens,
+		CompletionTokens: cached.CompletionTokens,
+	}
+	if err := json.Unmarshal([]byte(cached.Content), completion); err != nil {
+		completion.Content = cached.Content
+	}
+	return completion
+}
+
+func (m *meter) finish(ctx context.Context, cacheKey string, start time.Time, completion *Completion) {
+	model := completion.Model
+	if model == "" {
+		model = m.provider.Model()
+	}
+	m.record(ctx, &db.UsageRecord{
+		CreatedAt:        start,
+		Model:            model,
+		PromptTokens:     completion.PromptTokens,
+		CompletionTokens: completion.CompletionTokens,
+		LatencyMs:        time.Since(start).Milliseconds(),
+		Cost:             m.costOf(model, completion.PromptTokens, completion.CompletionTokens),
+	})
+
+	if !m.cache {
+		return
+	}
+
+	// Content and tool calls are cached together as JSON
+	content, err := json.Marshal(completion)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal completion")
+		return
+	}
+	if err := m.db.CreateLlmCacheRecord(&db.LlmCacheRecord{
+		CacheKey:         cacheKey,
+		Model:            model,
+		Content:          string(content),
+		PromptTokens:     completion.PromptTokens,
+		CompletionTokens: completion.CompletionTokens,
+		CreatedAt:        start,
+	}); err != nil {
+		logrus.WithError(err).Error("db.CreateLlmCacheRecord failed")
+	}
+}
+
+func (m *meter) Chat(ctx context.Context, request *ChatRequest) (*Completion, error) {
+	cacheKey := cacheKeyOf(m.provider.Model(), request)
+	if cached := m.getCached(ctx, cacheKey); cached != nil {
+		return cached, nil
+	}
+
+	if err := m.checkBudget(m.provider.Model()); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	completion, err := m.provider.Chat(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	m.finish(ctx, cacheKey, start, completion)
+	return completion, nil
+}
+
+func (m *meter) ChatStream(ctx context.Context, request *ChatRequest, onDelta func(delta string) error) (*Completion, error) {
+	cacheKey := cacheKeyOf(m.provider.Model(), request)
+	if cached := m.getCached(ctx, cacheKey); cached != nil {
+		if err := onDelta(cached.Content); err != nil {
+			return nil, err
+		}
+		return cached, nil
+	}
+
+	if err := m.checkBudget(m.provider.Model()); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	completion, err := m.provider.ChatStream(ctx, request, onDelta)
+	if err != nil {
+		return nil, err
+	}
+	m.finish(ctx, cacheKey, start, completion)
+	return completion, nil
+}
+
+func (m *meter) Embed(ctx context.Context, inputs []string) (*Embeddings, error) {
+	if err := m.checkBudget(""); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	embeddings, err := m.provider.Embed(ctx, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	m.record(ctx, &db.UsageRecord{
+		CreatedAt:    start,
+		Model:        embeddings.Model,
+		PromptTokens: embeddings.PromptTokens,
+		LatencyMs:    time.Since(start).Milliseconds(),
+		Cost:         m.costOf(embeddings.Model, embeddings.PromptTokens, 0),
+	})
+	return embeddings, nil
+}
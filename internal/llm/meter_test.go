@@ -0,0 +1,151 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+)
+
+// openTestDb opens a new building.db in a temporary directory, the path of
+// the db is relative to the working directory.
+func openTestDb(t *testing.T) db.BuildingDB {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return buildingDb
+}
+
+func userRequest(content string) *ChatRequest {
+	return &ChatRequest{Messages: []Message{{Role: RoleUser, Content: content}}}
+}
+
+func usageByBuilding(t *testing.T, usageDb db.UsageDB) map[string]*db.UsageSummary {
+	summaries, err := usageDb.GetUsageSummary(db.UsageGroupByBuilding, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey := make(map[string]*db.UsageSummary, len(summaries))
+	for _, summary := range summaries {
+		byKey[summary.Key] = summary
+	}
+	return byKey
+}
+
+func TestMeterRecordsUsageAndCaches(t *testing.T) {
+	buildingDb := openTestDb(t)
+	// the script answers once, a second call can only be served by the cache
+	provider := NewMeter(NewFakeProvider(&FakeResponse{Content: "hello"}), buildingDb,
+		ModelPrice(Price{Input: 1e6, Output: 1e6}))
+
+	ctx := WithUsageTags(context.Background(), "bid", "question")
+	for i := 0; i < 2; i++ {
+		completion, err := provider.Chat(ctx, userRequest("hi"))
+		if err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+		if completion.Content != "hello" || completion.PromptTokens != 2 || completion.CompletionTokens != 5 {
+			t.Errorf("call %d = %+v", i+1, completion)
+		}
+	}
+
+	summary := usageByBuilding(t, buildingDb)["bid"]
+	if summary == nil {
+		t.Fatal("usage is not tagged with the building")
+	}
+	// a cache hit is recorded without cost
+	if summary.Calls != 2 || summary.CacheHits != 1 || summary.PromptTokens != 4 || summary.Cost != 7 {
+		t.Errorf("usage = %+v", summary)
+	}
+}
+
+func TestMeterDisableCache(t *testing.T) {
+	buildingDb := openTestDb(t)
+	provider := NewMeter(NewFakeProvider(&FakeResponse{Content: "hello"}), buildingDb, DisableCache())
+
+	if _, err := provider.Chat(context.Background(), userRequest("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Chat(context.Background(), userRequest("hi")); err == nil {
+		t.Error("expect the exhausted script to be called again")
+	}
+}
+
+func TestMeterBudget(t *testing.T) {
+	buildingDb := openTestDb(t)
+	provider := NewMeter(NewFakeProvider(&FakeResponse{Content: "hello", Repeat: true}), buildingDb,
+		MonthlyBudget(5), ModelPrice(Price{Input: 1e6, Output: 1e6}))
+
+	// the first call spends 7 USD of the budget of 5
+	if _, err := provider.Chat(context.Background(), userRequest("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Chat(context.Background(), userRequest("again")); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("chat over budget = %v", err)
+	}
+	if _, err := provider.Embed(context.Background(), []string{"hi"}); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("embed over budget = %v", err)
+	}
+
+	// cached answers cost nothing and are still served
+	if _, err := provider.Chat(context.Background(), userRequest("hi")); err != nil {
+		t.Errorf("cached chat over budget = %v", err)
+	}
+}
+
+func TestMeterUnpricedModel(t *testing.T) {
+	buildingDb := openTestDb(t)
+
+	// the fake model has no price, it would never count against the budget
+	provider := NewMeter(NewFakeProvider(&FakeResponse{Content: "hello", Repeat: true}), buildingDb, MonthlyBudget(5))
+	if _, err := provider.Chat(context.Background(), userRequest("hi")); !errors.Is(err, ErrUnpricedModel) {
+		t.Errorf("unpriced chat under a budget = %v", err)
+	}
+
+	// without a budget nothing is refused
+	provider = NewMeter(NewFakeProvider(&FakeResponse{Content: "hello", Repeat: true}), buildingDb, DisableCache())
+	if _, err := provider.Chat(context.Background(), userRequest("hi")); err != nil {
+		t.Errorf("unpriced chat without a budget = %v", err)
+	}
+
+	provider = NewMeter(NewFakeProvider(&FakeResponse{Content: "hello", Repeat: true}), buildingDb,
+		MonthlyBudget(5), FallbackPrice(Price{Input: 1e6, Output: 1e6}))
+	if _, err := provider.Chat(context.Background(), userRequest("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Chat(context.Background(), userRequest("again")); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("chat over budget at the fallback price = %v", err)
+	}
+}
+
+func TestMeterOptionsFromEnv(t *testing.T) {
+	t.Setenv("LLM_MONTHLY_BUDGET", "5")
+	t.Setenv("LLM_FALLBACK_PRICE_INPUT", "1")
+	t.Setenv("LLM_FALLBACK_PRICE_OUTPUT", "2")
+	opts, err := MeterOptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMeter(NewFakeProvider(), nil, opts...).(*meter)
+	if m.budget != 5 || m.price != nil || m.fallbackPrice == nil || *m.fallbackPrice != (Price{Input: 1, Output: 2}) {
+		t.Errorf("meter = %+v", m)
+	}
+
+	t.Setenv("LLM_FALLBACK_PRICE_OUTPUT", "")
+	if _, err := MeterOptionsFromEnv(); err == nil {
+		t.Error("expect error for a fallback price without output")
+	}
+}
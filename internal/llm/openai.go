@@ -0,0 +1,385 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultBaseUrl        = "https://api.openai.com/v1"
+	DefaultModel          = "gpt-4o-mini"
+	DefaultEmbeddingModel = "text-embedding-3-small"
+
+	requestTimeout = 120 * time.Second
+)
+
+type OpenAIOption func(*openAIProvider)
+
+func OpenAIApiKey(apiKey string) OpenAIOption {
+	return func(o *openAIProvider) {
+		o.apiKey = apiKey
+	}
+}
+
+func OpenAIBaseUrl(baseUrl string) OpenAIOption {
+	return func(o *openAIProvider) {
+		if baseUrl != "" {
+			o.baseUrl = strings.TrimSuffix(baseUrl, "/")
+		}
+	}
+}
+
+func OpenAIModel(model string) OpenAIOption {
+	return func(o *openAIProvider) {
+		if model != "" {
+			o.model = model
+		}
+	}
+}
+
+func OpenAIEmbeddingModel(model string) OpenAIOption {
+	return func(o *openAIProvider) {
+		if model != "" {
+			o.embeddingModel = model
+		}
+	}
+}
+
+// openAIProvider talks to /chat/completions and /embeddings of the OpenAI
+// API or any server compatible with it.
+type openAIProvider struct {
+	apiKey         string
+	baseUrl        string
+	model          string
+	embeddingModel string
+
+	client *resty.Client
+}
+
+var _ Provider = (*openAIProvider)(nil)
+
+func NewOpenAIProvider(opts ...OpenAIOption) Provider {
+	provider := &openAIProvider{
+		baseUrl:        DefaultBaseUrl,
+		model:          DefaultModel,
+		embeddingModel: DefaultEmbeddingModel,
+	}
+	for _, opt := range opts {
+		opt(provider)
+	}
+
+	provider.client = resty.New().
+		SetBaseURL(provider.baseUrl).
+		SetTimeout(requestTimeout).
+		SetHeader("Content-Type", "application/json")
+	if provider.apiKey != "" {
+		provider.client.SetAuthToken(provider.apiKey)
+	}
+	return provider
+}
+
+type openAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Arguments   string          `json:"arguments,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    int            `json:"index"`
+	Id       string         `json:"id,omitempty"`
+	Type     string         `json:"type,omitempty"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIMessage struct {
+	Role       string            `json:"role,omitempty"`
+	Content    string            `json:"content"`
+	ToolCalls  []*openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallId string            `json:"tool_call_id,omitempty"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type openAIError struct {
+	Message string `json:"message"`
+}
+
+type chatCompletionRequest struct {
+	Model         string          `json:"model"`
+	Messages      []openAIMessage `json:"messages"`
+	Tools         []openAITool    `json:"tools,omitempty"`
+	Temperature   float64         `json:"temperature"`
+	MaxTokens     int             `json:"max_tokens,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+}
+
+type chatCompletionResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+	Error *openAIError `json:"error"`
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Model string `json:"model"`
+	Data  []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Usage *openAIUsage `json:"usage"`
+	Error *openAIError `json:"error"`
+}
+
+func (p *openAIProvider) Model() string {
+	return p.model
+}
+
+func (p *openAIProvider) newChatRequest(request *ChatRequest) *chatCompletionRequest {
+	req := &chatCompletionRequest{
+		Model:       p.model,
+		Messages:    make([]openAIMessage, 0, len(request.Messages)),
+		Temperature: request.Temperature,
+		MaxTokens:   request.MaxTokens,
+	}
+
+	for _, message := range request.Messages {
+		m := openAIMessage{Role: message.Role, Content: message.Content, ToolCallId: message.ToolCallId}
+		for _, call := range message.ToolCalls {
+			m.ToolCalls = append(m.ToolCalls, &openAIToolCall{
+				Id:       call.Id,
+				Type:     "function",
+				Function: openAIFunction{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		req.Messages = append(req.Messages, m)
+	}
+
+	for _, tool := range request.Tools {
+		req.Tools = append(req.Tools, openAITool{
+			Type:     "function",
+			Function: openAIFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	return req
+}
+
+func toolCallsOf(calls []*openAIToolCall) []*ToolCall {
+	toolCalls := make([]*ToolCall, 0, len(calls))
+	for _, call := range calls {
+		toolCalls = append(toolCalls, &ToolCall{Id: call.Id, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+	return toolCalls
+}
+
+func errorOf(res *resty.Response, apiError *openAIError) error {
+	if apiError != nil {
+		return fmt.Errorf("%s: %s: %s", res.Request.URL, res.Status(), apiError.Message)
+	}
+	return fmt.Errorf("%s: %s", res.Request.URL, res.Status())
+}
+
+// statusErrorOf reads the error of a non-2xx response, whose body may be a
+// proxy page instead of JSON.
+func statusErrorOf(res *resty.Response, raw []byte) error {
+	var body struct {
+		Error *openAIError `json:"error"`
+	}
+	_ = json.Unmarshal(raw, &body)
+	return errorOf(res, body.Error)
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, request *ChatRequest) (*Completion, error) {
+	res, err := p.client.R().SetContext(ctx).SetBody(p.newChatRequest(request)).Post("/chat/completions")
+	if err != nil {
+		logrus.WithError(err).Error("POST /chat/completions failed")
+		return nil, err
+	}
+
+	if res.IsError() {
+		err := statusErrorOf(res, res.Body())
+		logrus.WithError(err).Error("chat completion failed")
+		return nil, err
+	}
+
+	var body chatCompletionResponse
+	if err := json.Unmarshal(res.Body(), &body); err != nil {
+		logrus.WithError(err).Error("Failed to unmarshal JSON")
+		return nil, err
+	}
+	if body.Error != nil {
+		err := errorOf(res, body.Error)
+		logrus.WithError(err).Error("chat completion failed")
+		return nil, err
+	}
+
+	if len(body.Choices) == 0 {
+		logrus.Error("chat completion returned no choices")
+		return nil, fmt.Errorf("chat completion returned no choices")
+	}
+
+	completion := &Completion{
+		Model:     body.Model,
+		Content:   body.Choices[0].Message.Content,
+		ToolCalls: toolCallsOf(body.Choices[0].Message.ToolCalls),
+	}
+	if body.Usage != nil {
+		completion.PromptTokens = body.Usage.PromptTokens
+		completion.CompletionTokens = body.Usage.CompletionTokens
+	}
+	return completion, nil
+}
+
+func (p *openAIProvider) ChatStream(ctx context.Context, request *ChatRequest, onDelta func(delta string) error) (*Completion, error) {
+	req := p.newChatRequest(request)
+	req.Stream = true
+	req.StreamOptions = &struct {
+		IncludeUsage bool `json:"include_usage"`
+	}{IncludeUsage: true}
+
+	res, err := p.client.R().SetContext(ctx).SetBody(req).SetDoNotParseResponse(true).Post("/chat/completions")
+	if err != nil {
+		logrus.WithError(err).Error("POST /chat/completions failed")
+		return nil, err
+	}
+	defer res.RawBody().Close()
+
+	if res.IsError() {
+		raw, _ := io.ReadAll(res.RawBody())
+		err := statusErrorOf(res, raw)
+		logrus.WithError(err).Error("chat completion stream failed")
+		return nil, err
+	}
+
+	var content strings.Builder
+	completion := &Completion{Model: p.model}
+	calls := make([]*openAIToolCall, 0)
+
+	// Server sent events, one JSON chunk per "data:" line
+	scanner := bufio.NewScanner(res.RawBody())
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk chatCompletionResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			logrus.WithError(err).Error("Failed to unmarshal JSON")
+			return nil, err
+		}
+		if chunk.Error != nil {
+			logrus.Errorf("chat completion stream failed: %s", chunk.Error.Message)
+			return nil, fmt.Errorf("chat completion stream failed: %s", chunk.Error.Message)
+		}
+		if chunk.Model != "" {
+			completion.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			completion.PromptTokens = chunk.Usage.PromptTokens
+			completion.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			if err := onDelta(delta.Content); err != nil {
+				return nil, err
+			}
+		}
+
+		// Tool calls arrive in pieces addressed by index
+		for _, call := range delta.ToolCalls {
+			for len(calls) <= call.Index {
+				calls = append(calls, &openAIToolCall{})
+			}
+			target := calls[call.Index]
+			if call.Id != "" {
+				target.Id = call.Id
+			}
+			target.Function.Name += call.Function.Name
+			target.Function.Arguments += call.Function.Arguments
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.WithError(err).Error("scanner.Scan failed")
+		return nil, err
+	}
+
+	completion.Content = content.String()
+	completion.ToolCalls = toolCallsOf(calls)
+	return completion, nil
+}
+
+func (p *openAIProvider) Embed(ctx context.Context, inputs []string) (*Embeddings, error) {
+	res, err := p.client.R().SetContext(ctx).
+		SetBody(embeddingRequest{Model: p.embeddingModel, Input: inputs}).
+		Post("/embeddings")
+	if err != nil {
+		logrus.WithError(err).Error("POST /embeddings failed")
+		return nil, err
+	}
+
+	if res.IsError() {
+		err := statusErrorOf(res, res.Body())
+		logrus.WithError(err).Error("embedding failed")
+		return nil, err
+	}
+
+	var body embeddingResponse
+	if err := json.Unmarshal(res.Body(), &body); err != nil {
+		logrus.WithError(err).Error("Failed to unmarshal JSON")
+		return nil, err
+	}
+	if body.Error != nil {
+		err := errorOf(res, body.Error)
+		logrus.WithError(err).Error("embedding failed")
+		return nil, err
+	}
+
+	embeddings := &Embeddings{Model: body.Model, Vectors: make([][]float64, len(inputs))}
+	for _, data := range body.Data {
+		if data.Index < len(embeddings.Vectors) {
+			embeddings.Vectors[data.Index] = data.Embedding
+		}
+	}
+	if body.Usage != nil {
+		embeddings.PromptTokens = body.Usage.PromptTokens
+	}
+	return embeddings, nil
+}
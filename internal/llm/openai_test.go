@@ -0,0 +1,181 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newOpenAIServer serves handle on path and fails the test for any other
+// path or a request without the api key.
+func newOpenAIServer(t *testing.T, path string, handle func(w http.ResponseWriter, body map[string]interface{})) Provider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("path = %s, want %s", r.URL.Path, path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer key" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		body := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		handle(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return NewOpenAIProvider(OpenAIApiKey("key"), OpenAIBaseUrl(server.URL+"/v1/"), OpenAIModel("local-model"), OpenAIEmbeddingModel("local-embed"))
+}
+
+func TestOpenAIChatToolCalls(t *testing.T) {
+	provider := newOpenAIServer(t, "/v1/chat/completions", func(w http.ResponseWriter, body map[string]interface{}) {
+		if body["model"] != "local-model" || body["stream"] != nil {
+			t.Errorf("body = %v", body)
+		}
+		if tools, _ := body["tools"].([]interface{}); len(tools) != 1 {
+			t.Errorf("tools = %v", body["tools"])
+		}
+		io.WriteString(w, `{
+			"model": "local-model-0613",
+			"choices": [{"message": {"role": "assistant", "content": "", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "search", "arguments": "{\"q\":\"巴哈\"}"}}
+			]}}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 7}
+		}`)
+	})
+
+	completion, err := provider.Chat(context.Background(), &ChatRequest{
+		Messages: []Message{{Role: RoleUser, Content: "find 巴哈"}},
+		Tools:    []*Tool{{Name: "search", Parameters: json.RawMessage(`{"type":"object"}`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completion.Model != "local-model-0613" || completion.PromptTokens != 12 || completion.CompletionTokens != 7 {
+		t.Errorf("completion = %+v", completion)
+	}
+	if len(completion.ToolCalls) != 1 || completion.ToolCalls[0].Id != "call_1" ||
+		completion.ToolCalls[0].Name != "search" || completion.ToolCalls[0].Arguments != `{"q":"巴哈"}` {
+		t.Errorf("tool calls = %+v", completion.ToolCalls)
+	}
+}
+
+func TestOpenAIChatStream(t *testing.T) {
+	// the events are flushed in pieces, one of them split inside a line
+	pieces := []string{
+		"data: {\"model\":\"local-model-0613\",\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n: keep-alive\n\ndata: {\"choices\":[{\"delta\":{\"tool_",
+		"calls\":[{\"index\":0,\"id\":\"call_1\",\"function\":{\"name\":\"sea\",\"arguments\":\"{\\\"q\\\":\"}}]}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"name\":\"rch\",\"arguments\":\"1}\"}}]}}]}\n\n",
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":3}}\n\n",
+		"data: [DONE]\n\n",
+		"data: not json after done\n\n",
+	}
+	provider := newOpenAIServer(t, "/v1/chat/completions", func(w http.ResponseWriter, body map[string]interface{}) {
+		if body["stream"] != true {
+			t.Errorf("stream = %v", body["stream"])
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, piece := range pieces {
+			io.WriteString(w, piece)
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	deltas := make([]string, 0)
+	completion, err := provider.ChatStream(context.Background(), &ChatRequest{Messages: []Message{{Role: RoleUser, Content: "hi"}}},
+		func(delta string) error {
+			deltas = append(deltas, delta)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" || completion.Content != "Hello" {
+		t.Errorf("deltas = %q, content = %q", deltas, completion.Content)
+	}
+	if completion.Model != "local-model-0613" || completion.PromptTokens != 5 || completion.CompletionTokens != 3 {
+		t.Errorf("completion = %+v", completion)
+	}
+	if len(completion.ToolCalls) != 1 || completion.ToolCalls[0].Name != "search" || completion.ToolCalls[0].Arguments != `{"q":1}` {
+		t.Errorf("tool calls = %+v", completion.ToolCalls)
+	}
+}
+
+func TestOpenAIChatStreamError(t *testing.T) {
+	provider := newOpenAIServer(t, "/v1/chat/completions", func(w http.ResponseWriter, body map[string]interface{}) {
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		io.WriteString(w, "data: {\"error\":{\"message\":\"overloaded\"}}\n\n")
+	})
+
+	_, err := provider.ChatStream(context.Background(), &ChatRequest{Messages: []Message{{Role: RoleUser, Content: "hi"}}},
+		func(delta string) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "overloaded") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestOpenAIEmbed(t *testing.T) {
+	provider := newOpenAIServer(t, "/v1/embeddings", func(w http.ResponseWriter, body map[string]interface{}) {
+		if body["model"] != "local-embed" {
+			t.Errorf("model = %v", body["model"])
+		}
+		io.WriteString(w, `{
+			"model": "local-embed",
+			"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}],
+			"usage": {"prompt_tokens": 4}
+		}`)
+	})
+
+	embeddings, err := provider.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if embeddings.PromptTokens != 4 || len(embeddings.Vectors) != 2 || embeddings.Vectors[0][0] != 1 || embeddings.Vectors[1][1] != 1 {
+		t.Errorf("embeddings = %+v", embeddings)
+	}
+}
+
+func TestOpenAIErrorStatus(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   []string
+	}{
+		{"proxy page", http.StatusBadGateway, "<html><body>Bad Gateway</body></html>", []string{"502"}},
+		{"api error", http.StatusTooManyRequests, `{"error": {"message": "rate limited"}}`, []string{"429", "rate limited"}},
+		{"empty body", http.StatusUnauthorized, "", []string{"401"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+				io.WriteString(w, c.body)
+			}))
+			defer server.Close()
+			provider := NewOpenAIProvider(OpenAIBaseUrl(server.URL))
+			request := &ChatRequest{Messages: []Message{{Role: RoleUser, Content: "hi"}}}
+
+			_, chatErr := provider.Chat(context.Background(), request)
+			_, streamErr := provider.ChatStream(context.Background(), request, func(delta string) error { return nil })
+			_, embedErr := provider.Embed(context.Background(), []string{"hi"})
+			for call, err := range map[string]error{"Chat": chatErr, "ChatStream": streamErr, "Embed": embedErr} {
+				if err == nil {
+					t.Errorf("%s: expect error", call)
+					continue
+				}
+				for _, want := range c.want {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("%s: err = %v, want %q in it", call, err, want)
+					}
+				}
+			}
+		})
+	}
+}
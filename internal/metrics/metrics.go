@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "baha"
+
+var (
+	HttpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Requests sent to Baha by endpoint and status code, status is \"error\" when no response came back.",
+	}, []string{"endpoint", "status"})
+
+	HttpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of requests sent to Baha by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	ParseFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "parse_failures_total",
+		Help:      "Pages or floors which could not be parsed by the selector which failed.",
+	}, []string{"selector"})
+
+	FloorsIngested = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "floors_ingested_total",
+		Help:      "Floors parsed for the first time from fetched pages.",
+	})
+
+	RepliesIngested = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "replies_ingested_total",
+		Help:      "Replies parsed for the first time from fetched pages and the extend API.",
+	})
+
+	DbWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_write_duration_seconds",
+		Help:      "Latency of writes to the building db by operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	MonitorPolls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "monitor_polls_total",
+		Help:      "Polls of the monitor by rule and result.",
+	}, []string{"rule", "result"})
+
+	MonitorCallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "monitor_callbacks_total",
+		Help:      "Rule callbacks fired by the monitor by rule and event.",
+	}, []string{"rule", "event"})
+
+	MonitorSharedPages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "monitor_shared_pages_total",
+		Help:      "Pages handed to a rule from a fetch made for another rule of the same building.",
+	})
+
+	EventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_published_total",
+		Help:      "Events published on the event bus of the monitor by type.",
+	}, []string{"type"})
+
+	EventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_dropped_total",
+		Help:      "Events dropped by a subscriber of the event bus whose buffer was full.",
+	}, []string{"subscriber"})
+
+	NotificationsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_sent_total",
+		Help:      "Notifications sent to a sink by result, retries of a notification are not counted.",
+	}, []string{"sink", "result"})
+
+	NotificationsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_dropped_total",
+		Help:      "Notifications dropped by a sink whose queue was full.",
+	}, []string{"sink"})
+
+	LoginAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_attempts_total",
+		Help:      "Attempts to log in to Baha by result.",
+	}, []string{"result"})
+
+	LlmTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_tokens_total",
+		Help:      "Tokens used by LLM calls by model and kind, cache hits are not counted.",
+	}, []string{"model", "kind"})
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+func Result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// Endpoint labels a request by its path only, query strings carry building
+// and user ids which would make the label unbounded.
+func Endpoint(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Path == "" {
+		return "unknown"
+	}
+	return parsed.Host + parsed.Path
+}
+
+// ObserveHttpRequest records a request to Baha, status is 0 when no
+// response came back.
+func ObserveHttpRequest(rawURL string, status int, duration time.Duration) {
+	endpoint := Endpoint(rawURL)
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	HttpRequests.WithLabelValues(endpoint, label).Inc()
+	HttpRequestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+}
+
+// ObserveDbWrite is deferred by every write of the building db.
+func ObserveDbWrite(operation string, start time.Time) {
+	DbWriteDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
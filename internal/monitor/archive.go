@@ -0,0 +1,84 @@
+package monitor
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+// floorFingerprint changes whenever the content or a reply of the floor
+// does, so a floor polled again unchanged is not written again.
+func floorFingerprint(floor *db.FloorRecord) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(floor.Content))
+	for _, reply := range floor.Replies {
+		fmt.Fprintf(hash, "\x00%d\x00%s\x00%s", reply.ReplyIndex, reply.AuthorId, reply.Content)
+	}
+	return hash.Sum64()
+}
+
+// archiveFloors writes the floors seen by a rule with SyncLocalDb into the
+// archive, edits are kept as revisions of the floor. buildingPages tells
+// whether the floors were read from the pages of the building, the page
+// numbers of s_author pages are not those of the building.
+func (m *monitor) archiveFloors(rule *rule.TrackingRule, floors []*db.FloorRecord, buildingPages bool) {
+	m.archiveMu.Lock()
+	defer m.archiveMu.Unlock()
+
+	now := time.Now()
+	pages := make(map[int]*db.PageRecord)
+	for _, floor := range floors {
+		key := fmt.Sprintf("%d-%d-%d", rule.Bsn, rule.Sna, floor.FloorIndex)
+		fingerprint := floorFingerprint(floor)
+		if m.archived[key] == fingerprint {
+			continue
+		}
+
+		pageIndex := floor.PageIndex
+		if !buildingPages {
+			pageIndex = craw.GetFloorPage(floor.FloorIndex)
+		}
+		page, exist := pages[pageIndex]
+		if !exist {
+			var err error
+			if page, err = m.db.SyncPageRecord(rule.Bsn, rule.Sna, pageIndex); err != nil {
+				logrus.WithError(err).Errorf("db.SyncPageRecord of page %d of %s failed", pageIndex, rule.Key())
+				return
+			}
+			pages[pageIndex] = page
+		}
+
+		// the floor may be shared with the other rules of the building
+		record := *floor
+		record.Bid, record.Pid = page.Bid, page.Pid
+		edited, err := m.db.SyncFloorRecord(&record, now)
+		if err != nil {
+			logrus.WithError(err).Errorf("db.SyncFloorRecord of B%d failed", floor.FloorIndex)
+			continue
+		}
+		if edited {
+			logrus.Infof("B%d of %d-%d edited, revision archived", floor.FloorIndex, rule.Bsn, rule.Sna)
+		}
+		if err := m.db.SaveFloorNicknames(&record, now); err != nil {
+			logrus.WithError(err).Errorf("db.SaveFloorNicknames of B%d failed", floor.FloorIndex)
+		}
+
+		synced := true
+		for _, reply := range floor.Replies {
+			replyRecord := *reply
+			replyRecord.Fid = record.Fid
+			if err := m.db.SyncReplyRecord(&replyRecord); err != nil {
+				logrus.WithError(err).Errorf("db.SyncReplyRecord under B%d failed", floor.FloorIndex)
+				synced = false
+			}
+		}
+		if synced {
+			m.archived[key] = fingerprint
+		}
+	}
+}
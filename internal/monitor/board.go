@@ -0,0 +1,225 @@
+package monitor
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/event"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/davidleitw/baha/internal/notify"
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+// AddBoardRule must be called before Start.
+func (m *monitor) AddBoardRule(rule *rule.BoardRule) {
+	m.boards = append(m.boards, rule)
+}
+
+// restoreBoardCursor loads the newest thread seen by the previous run, the
+// cursor of a board rule is kept as the floor index of a monitor cursor.
+func (m *monitor) restoreBoardCursor(rule *rule.BoardRule) bool {
+	cursor, err := m.db.GetMonitorCursorRecord(rule.Key())
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.GetMonitorCursorRecord failed")
+		}
+		return false
+	}
+
+	logrus.Infof("Replay %s from snA %d seen at %s", rule.Key(), cursor.LastFloorIndex, cursor.UpdatedAt.Format(time.RFC3339))
+	rule.LastSna = cursor.LastFloorIndex
+	return true
+}
+
+func (m *monitor) saveBoardCursor(rule *rule.BoardRule) {
+	if err := m.db.SaveMonitorCursorRecord(&db.MonitorCursorRecord{
+		RuleKey:        rule.Key(),
+		LastFloorIndex: rule.LastSna,
+		UpdatedAt:      time.Now(),
+	}); err != nil {
+		logrus.WithError(err).Error("db.SaveMonitorCursorRecord failed")
+	}
+}
+
+// activateBoardLoop polls the first page of the board, where a new thread
+// shows up, until ctx is done.
+func (m *monitor) activateBoardLoop(ctx context.Context, rule *rule.BoardRule) {
+	baseline := !m.restoreBoardCursor(rule)
+
+	for {
+		threads, err := m.crawler.ParseBoardPage(rule.Url)
+		metrics.MonitorPolls.WithLabelValues(rule.Key(), metrics.Result(err)).Inc()
+		if err != nil {
+			logrus.WithError(err).Errorf("ParseBoardPage of %s failed", rule.Key())
+		} else {
+			m.handleThreads(rule, threads, baseline)
+			baseline = false
+		}
+
+		if !sleep(ctx, rule.GetInterval()) {
+			logrus.Infof("Stop board loop of %s", rule.Key())
+			return
+		}
+	}
+}
+
+// handleThreads passes the threads opened since the last poll to the rule,
+// the first poll without a cursor only records the newest thread.
+func (m *monitor) handleThreads(rule *rule.BoardRule, threads []*db.ThreadRecord, baseline bool) {
+	newThreads := make([]*db.ThreadRecord, 0)
+	for _, thread := range threads {
+		if !thread.Sticky && thread.Sna > rule.LastSna {
+			newThreads = append(newThreads, thread)
+		}
+	}
+	if len(newThreads) == 0 {
+		return
+	}
+	sort.Slice(newThreads, func(i, j int) bool { return newThreads[i].Sna < newThreads[j].Sna })
+
+	if !baseline {
+		for _, thread := range newThreads {
+			if rule.MatchThread(thread) {
+				m.handleNewThread(rule, thread)
+			}
+		}
+	}
+	rule.LastSna = newThreads[len(newThreads)-1].Sna
+	m.saveBoardCursor(rule)
+}
+
+func (m *monitor) handleNewThread(rule *rule.BoardRule, thread *db.ThreadRecord) {
+	url := craw.TargetInfo{Bsn: thread.Bsn, Sna: thread.Sna}.GetBuildingUrl()
+
+	metrics.MonitorCallbacks.WithLabelValues(rule.Key(), notify.EventNewThread).Inc()
+	rule.NewThreadCallback(thread)
+	m.bus.Publish(&event.Event{
+		Type:     event.NewThread,
+		Time:     time.Now(),
+		RuleKey:  rule.Key(),
+		RuleName: rule.Name,
+		Bsn:      thread.Bsn,
+		Sna:      thread.Sna,
+		Url:      url,
+		Thread:   thread,
+	})
+
+	if m.notifier != nil {
+		if err := m.notifier.Notify(rule.Sinks, notify.NewThreadMessage(rule.Key(), thread, url)); err != nil {
+			logrus.WithError(err).Errorf("Notify new thread %d failed", thread.Sna)
+		}
+	}
+
+	if rule.AutoTrack {
+		m.autoTrackThread(rule, thread)
+	}
+}
+
+// autoTrack is a rule started by a board rule for one of its threads.
+type autoTrack struct {
+	record *db.AutoTrackRecord
+	rule   *rule.TrackingRule
+}
+
+// autoTrackThread starts tracking thread and saves it, so the rule is
+// started again on the next run and survives reloads of the rules file.
+func (m *monitor) autoTrackThread(board *rule.BoardRule, thread *db.ThreadRecord) {
+	trackingRule := board.TrackingRule(thread)
+	if trackingRule == nil {
+		logrus.Errorf("Auto-track of thread %d by %q failed", thread.Sna, thread.AuthorId)
+		return
+	}
+	if err := m.checkSinks([]*rule.TrackingRule{trackingRule}); err != nil {
+		logrus.WithError(err).Errorf("Auto-track of thread %d failed", thread.Sna)
+		return
+	}
+
+	record := &db.AutoTrackRecord{
+		BoardKey:  board.Key(),
+		Bsn:       thread.Bsn,
+		Sna:       thread.Sna,
+		AuthorId:  thread.AuthorId,
+		Title:     thread.Title,
+		CreatedAt: time.Now(),
+	}
+	if err := m.db.SaveAutoTrackRecord(record); err != nil {
+		// still tracked until the monitor stops
+		logrus.WithError(err).Errorf("Save auto-track of thread %d failed", thread.Sna)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.autoTracks[trackingRule.Key()] = &autoTrack{record: record, rule: trackingRule}
+	m.addRule(trackingRule)
+}
+
+// restoreAutoTracks adds the rules auto-tracked by the board rules in an
+// earlier run, it is called by Start before the rules are started.
+func (m *monitor) restoreAutoTracks() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, board := range m.boards {
+		if !board.AutoTrack {
+			continue
+		}
+		records, err := m.db.GetAutoTrackRecords(board.Key())
+		if err != nil {
+			logrus.WithError(err).Errorf("Restore auto-tracks of %s failed", board.Key())
+			continue
+		}
+
+		for _, record := range records {
+			trackingRule := board.TrackingRule(&db.ThreadRecord{
+				Bsn:      record.Bsn,
+				Sna:      record.Sna,
+				AuthorId: record.AuthorId,
+				Title:    record.Title,
+			})
+			if trackingRule == nil {
+				logrus.Errorf("Restore auto-track of thread %d by %q failed", record.Sna, record.AuthorId)
+				continue
+			}
+			m.autoTracks[trackingRule.Key()] = &autoTrack{record: record, rule: trackingRule}
+		}
+		logrus.Infof("Restore %d auto-tracked threads of %s", len(records), board.Key())
+	}
+	m.rules = m.mergeAutoTracks(m.rules)
+}
+
+// mergeAutoTracks must be called with m.mu held. It returns rules with the
+// auto-tracked rules added, a rule in rules wins over an auto-tracked rule
+// of the same key.
+func (m *monitor) mergeAutoTracks(rules []*rule.TrackingRule) []*rule.TrackingRule {
+	merged := make([]*rule.TrackingRule, 0, len(rules)+len(m.autoTracks))
+	keys := make(map[string]bool)
+	for _, rule := range rules {
+		merged = append(merged, rule)
+		keys[rule.Key()] = true
+	}
+
+	tracked := make([]*autoTrack, 0, len(m.autoTracks))
+	for _, track := range m.autoTracks {
+		if !keys[track.rule.Key()] {
+			tracked = append(tracked, track)
+		}
+	}
+	sort.Slice(tracked, func(i, j int) bool { return tracked[i].record.CreatedAt.Before(tracked[j].record.CreatedAt) })
+	for _, track := range tracked {
+		merged = append(merged, track.rule)
+	}
+	return merged
+}
+
+// forgetAutoTrack deletes a removed auto-tracked rule, so it is not started
+// again on the next run.
+func (m *monitor) forgetAutoTrack(track *autoTrack) {
+	if err := m.db.DeleteAutoTrackRecord(track.record.BoardKey, track.record.Bsn, track.record.Sna); err != nil {
+		logrus.WithError(err).Errorf("Forget auto-track of thread %d failed", track.record.Sna)
+	}
+}
@@ -0,0 +1,91 @@
+package monitor
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/event"
+	"github.com/davidleitw/baha/internal/rule"
+)
+
+// openTestDb opens a new building.db in a temporary directory, the path of
+// the db is relative to the working directory.
+func openTestDb(t *testing.T) db.BuildingDB {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return buildingDb
+}
+
+func newTestMonitor(buildingDb db.BuildingDB, boards ...*rule.BoardRule) *monitor {
+	return &monitor{
+		db:         buildingDb,
+		runners:    make(map[string]*runner),
+		feeds:      make(map[string]*buildingFeed),
+		archived:   make(map[string]uint64),
+		autoTracks: make(map[string]*autoTrack),
+		boards:     boards,
+		bus:        event.NewBus(),
+		failures:   make(map[string]*RuleError),
+	}
+}
+
+func ruleKeys(rules []*rule.TrackingRule) map[string]bool {
+	keys := make(map[string]bool)
+	for _, rule := range rules {
+		keys[rule.Key()] = true
+	}
+	return keys
+}
+
+func TestAutoTrackSurvivesReloadAndRestart(t *testing.T) {
+	buildingDb := openTestDb(t)
+	board := rule.NewBoardRule("news", 60076, rule.AutoTrack())
+	thread := &db.ThreadRecord{Bsn: 60076, Sna: 4242, AuthorId: "Baha", Title: "新串"}
+
+	m := newTestMonitor(buildingDb, board)
+	m.autoTrackThread(board, thread)
+	autoKey := board.TrackingRule(thread).Key()
+
+	fileRule := rule.NewTrackingRule(rule.Bsn(60076), rule.Sna(123), rule.Id("someone"))
+	if err := m.ReloadRules(fileRule); err != nil {
+		t.Fatal(err)
+	}
+	keys := ruleKeys(m.rules)
+	if !keys[autoKey] || !keys[fileRule.Key()] || len(keys) != 2 {
+		t.Fatalf("rules after reload = %v", keys)
+	}
+
+	restarted := newTestMonitor(buildingDb, board)
+	restarted.restoreAutoTracks()
+	if keys := ruleKeys(restarted.rules); !keys[autoKey] || len(keys) != 1 {
+		t.Fatalf("rules after restart = %v", keys)
+	}
+
+	if err := m.RemoveRule(autoKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ReloadRules(fileRule); err != nil {
+		t.Fatal(err)
+	}
+	if keys := ruleKeys(m.rules); keys[autoKey] {
+		t.Errorf("removed auto-track is back after reload")
+	}
+
+	restarted = newTestMonitor(buildingDb, board)
+	restarted.restoreAutoTracks()
+	if len(restarted.rules) != 0 {
+		t.Errorf("removed auto-track is back after restart")
+	}
+}
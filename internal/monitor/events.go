@@ -0,0 +1,34 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/event"
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+// Subscribe hands the events of every rule to handler until the returned
+// function is called, the subscribers are closed once Wait returns.
+func (m *monitor) Subscribe(name string, handler event.Handler, opts ...event.SubscribeOption) func() {
+	return m.bus.Subscribe(name, handler, opts...)
+}
+
+// publish fills in the rule and time of e before handing it to the
+// subscribers.
+func (m *monitor) publish(rule *rule.TrackingRule, e *event.Event) {
+	e.Time = time.Now()
+	e.RuleKey = rule.Key()
+	e.RuleName = rule.Name
+	e.Bsn = rule.Bsn
+	e.Sna = rule.Sna
+	m.bus.Publish(e)
+}
+
+// handleDeletedFloor reports the last seen floor gone from its page, the
+// cursor of the rule stays on it.
+func (m *monitor) handleDeletedFloor(rule *rule.TrackingRule, floor *db.FloorRecord) {
+	logrus.Infof("B%d of %s is deleted", floor.FloorIndex, rule.Key())
+	m.publish(rule, &event.Event{Type: event.FloorDeleted, Floor: floor, Url: rule.Url})
+}
@@ -0,0 +1,214 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+// sharedPageMaxAge is how long a fetched page is handed to the other rules
+// of the building instead of being fetched again. It is kept below the
+// shortest interval of a rule, the rules woken up by a fetch read the page
+// within milliseconds anyway.
+const sharedPageMaxAge = 500 * time.Millisecond
+
+type cachedPage struct {
+	page      *db.PageRecord
+	err       error
+	fetchedAt time.Time
+}
+
+// buildingFeed groups the rules of one building so a page is fetched once
+// per cycle however many rules read it. Once the newest page is fetched,
+// every other rule reading it is woken up to poll the same page, which
+// lines their polls up behind the fastest rule of the building.
+type buildingFeed struct {
+	// mu is held while a page is fetched, so a rule asking for a page
+	// being fetched waits for it instead of fetching it again
+	mu      sync.Mutex
+	pages   map[string]*cachedPage
+	runners map[string]*runner
+}
+
+func buildingKey(rule *rule.TrackingRule) string {
+	return fmt.Sprintf("%d-%d", rule.Bsn, rule.Sna)
+}
+
+// joinFeed must be called before the goroutine of r starts.
+func (m *monitor) joinFeed(r *runner) {
+	m.feedMu.Lock()
+	defer m.feedMu.Unlock()
+
+	key := buildingKey(r.rule)
+	feed, exist := m.feeds[key]
+	if !exist {
+		feed = &buildingFeed{pages: make(map[string]*cachedPage), runners: make(map[string]*runner)}
+		m.feeds[key] = feed
+	}
+
+	feed.mu.Lock()
+	feed.runners[r.rule.Key()] = r
+	feed.mu.Unlock()
+	r.feed = feed
+}
+
+// leaveFeed is called once the goroutine of r is gone, the feed is dropped
+// with its last rule.
+func (m *monitor) leaveFeed(r *runner) {
+	m.feedMu.Lock()
+	defer m.feedMu.Unlock()
+
+	feed := r.feed
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	// a restarted rule may have joined with a new runner already
+	if feed.runners[r.rule.Key()] == r {
+		delete(feed.runners, r.rule.Key())
+	}
+	if len(feed.runners) == 0 {
+		delete(m.feeds, buildingKey(r.rule))
+	}
+}
+
+// fetch returns the page at url, fetched reports whether it was fetched
+// for this call rather than taken from an earlier fetch.
+func (feed *buildingFeed) fetch(crawler craw.Crawler, url string) (page *db.PageRecord, fetched bool, err error) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	now := time.Now()
+	for cachedUrl, cached := range feed.pages {
+		if now.Sub(cached.fetchedAt) >= sharedPageMaxAge {
+			delete(feed.pages, cachedUrl)
+		}
+	}
+
+	if cached, exist := feed.pages[url]; exist {
+		return cached.page, false, cached.err
+	}
+
+	page, err = crawler.ParsePage(url)
+	feed.pages[url] = &cachedPage{page: page, err: err, fetchedAt: time.Now()}
+	return page, true, err
+}
+
+// wake pokes every rule reading the newest page but from, rules within their
+// quiet hours are left asleep.
+func (feed *buildingFeed) wake(from *runner) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	now := time.Now()
+	for _, r := range feed.runners {
+		if r == from || !r.shared.Load() || !r.rule.QuietUntil(now).IsZero() {
+			continue
+		}
+		select {
+		case r.pokeCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// fetchShared fetches url through the feed of the rule. The pages of a
+// building are shared between its rules, so they must not be modified.
+func (m *monitor) fetchShared(r *runner, url string) (*db.PageRecord, error) {
+	page, fetched, err := r.feed.fetch(m.crawler, url)
+	if !fetched {
+		metrics.MonitorSharedPages.Inc()
+		return page, err
+	}
+
+	if err == nil && url == r.rule.BuildingLastPageUrl() {
+		r.feed.wake(r)
+	}
+	return page, err
+}
+
+// pollPages fetches the page the rule reads in this poll, and the newest
+// page of the building when the rule has a use for it. A watch rule reads
+// the newest page of the building as it is. An author rule is handed the
+// floors of its author from it while it covers every floor after the last
+// one checked, otherwise it falls back to its own s_author page. Replies under
+// older floors of the author are only on the s_author pages, so a rule
+// tracking them always reads its own page.
+func (m *monitor) pollPages(r *runner, firstTime bool) (pageRecord, buildingPage *db.PageRecord, err error) {
+	rule := r.rule
+	if rule.IsWatch() {
+		r.shared.Store(true)
+		pageRecord, err = m.fetchShared(r, rule.BuildingLastPageUrl())
+		return pageRecord, pageRecord, err
+	}
+
+	if !rule.TracksReplies() || rule.TracksAuthorReplies() {
+		buildingPage, err = m.fetchShared(r, rule.BuildingLastPageUrl())
+		if err != nil {
+			logrus.WithError(err).Errorf("ParsePage of the newest page of %s failed", rule.Key())
+			buildingPage = nil
+		}
+	}
+
+	if buildingPage != nil && !rule.TracksReplies() {
+		if authorPage := filterAuthorFloors(rule, buildingPage, r.checkedFloorIndex, firstTime); authorPage != nil {
+			r.shared.Store(true)
+			r.checkedFloorIndex = lastFloorIndexOf(buildingPage, r.checkedFloorIndex)
+			return authorPage, buildingPage, nil
+		}
+	}
+
+	r.shared.Store(false)
+	pageRecord, err = m.fetchShared(r, rule.LastPageUrl)
+	if err == nil && buildingPage != nil {
+		// the s_author page was read after the building page
+		r.checkedFloorIndex = lastFloorIndexOf(buildingPage, r.checkedFloorIndex)
+	}
+	return pageRecord, buildingPage, err
+}
+
+func lastFloorIndexOf(page *db.PageRecord, floorIndex int) int {
+	if len(page.Floors) != 0 && page.Floors[len(page.Floors)-1].FloorIndex > floorIndex {
+		return page.Floors[len(page.Floors)-1].FloorIndex
+	}
+	return floorIndex
+}
+
+// filterAuthorFloors picks the floors of the author of the rule out of the
+// newest page of the building. It returns nil when the page may miss a
+// floor of the author, which is the case when the page starts past the
+// floor after both the last floor of the building checked for the author
+// and the last seen floor of the author, or when the first poll finds no
+// floor of the author on it. The page may hold no floor of the author at
+// all, and the last seen floor is only checked for edits while it is on
+// the page.
+func filterAuthorFloors(rule *rule.TrackingRule, buildingPage *db.PageRecord, checkedFloorIndex int, firstTime bool) *db.PageRecord {
+	floors := make([]*db.FloorRecord, 0)
+	for _, floor := range buildingPage.Floors {
+		if strings.EqualFold(floor.AuthorId, rule.AimId) {
+			floors = append(floors, floor)
+		}
+	}
+
+	if firstTime && len(floors) == 0 {
+		return nil
+	}
+	if checkedFloorIndex < rule.LastFloorIndex {
+		checkedFloorIndex = rule.LastFloorIndex
+	}
+	if !firstTime && (len(buildingPage.Floors) == 0 || buildingPage.Floors[0].FloorIndex > checkedFloorIndex+1) {
+		return nil
+	}
+	return &db.PageRecord{
+		Bid:       buildingPage.Bid,
+		Pid:       buildingPage.Pid,
+		PageIndex: buildingPage.PageIndex,
+		Floors:    floors,
+	}
+}
@@ -0,0 +1,169 @@
+package monitor
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/rule"
+)
+
+func testBuildingPage(authors map[int]string, from, to int) *db.PageRecord {
+	page := &db.PageRecord{Bid: "60076-123", PageIndex: 3}
+	for index := from; index <= to; index++ {
+		author := authors[index]
+		if author == "" {
+			author = "someone"
+		}
+		page.Floors = append(page.Floors, &db.FloorRecord{FloorIndex: index, AuthorId: author})
+	}
+	return page
+}
+
+func TestFilterAuthorFloors(t *testing.T) {
+	authors := map[int]string{45: "Baha", 48: "baha"}
+	cases := []struct {
+		name      string
+		last      int
+		checked   int
+		firstTime bool
+		from, to  int
+		want      []int
+	}{
+		{"cursor on page", 45, 0, false, 41, 50, []int{45, 48}},
+		{"page starts after cursor", 40, 0, false, 41, 50, []int{45, 48}},
+		{"floors missed before page", 39, 0, false, 41, 50, nil},
+		{"page starts after checked floor", 30, 40, false, 41, 50, []int{45, 48}},
+		{"floors missed after checked floor", 30, 39, false, 41, 50, nil},
+		{"no floor of author", 50, 0, false, 51, 55, []int{}},
+		{"first poll", 0, 0, true, 41, 50, []int{45, 48}},
+		{"first poll without author", 0, 0, true, 51, 55, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			trackingRule := &rule.TrackingRule{AimId: "baha", LastFloorIndex: c.last}
+			page := filterAuthorFloors(trackingRule, testBuildingPage(authors, c.from, c.to), c.checked, c.firstTime)
+			if c.want == nil {
+				if page != nil {
+					t.Fatalf("want fallback, got %d floors", len(page.Floors))
+				}
+				return
+			}
+			if page == nil {
+				t.Fatal("unexpected fallback")
+			}
+			if len(page.Floors) != len(c.want) {
+				t.Fatalf("got %d floors, want %v", len(page.Floors), c.want)
+			}
+			for i, floor := range page.Floors {
+				if floor.FloorIndex != c.want[i] {
+					t.Errorf("floor %d = B%d, want B%d", i, floor.FloorIndex, c.want[i])
+				}
+			}
+		})
+	}
+}
+
+// stubCrawler serves pages by url and counts how often each was fetched.
+type stubCrawler struct {
+	craw.Crawler
+	pages   map[string]*db.PageRecord
+	fetches map[string]int
+}
+
+func (crawler *stubCrawler) ParsePage(url string) (*db.PageRecord, error) {
+	crawler.fetches[url]++
+	page, exist := crawler.pages[url]
+	if !exist {
+		return nil, fmt.Errorf("no page at %s", url)
+	}
+	return page, nil
+}
+
+func newTestRunner(trackingRule *rule.TrackingRule) *runner {
+	return &runner{
+		rule:   trackingRule,
+		pokeCh: make(chan struct{}, 1),
+		feed:   &buildingFeed{pages: make(map[string]*cachedPage), runners: make(map[string]*runner)},
+	}
+}
+
+func TestPollPagesAuthorQuiet(t *testing.T) {
+	trackingRule := rule.NewTrackingRule(rule.Bsn(60076), rule.Sna(123), rule.Id("baha"))
+	crawler := &stubCrawler{pages: make(map[string]*db.PageRecord), fetches: make(map[string]int)}
+	m := &monitor{crawler: crawler}
+	r := newTestRunner(trackingRule)
+
+	// the author posted B5, then the building grows a page per poll
+	authors := map[int]string{5: "baha"}
+	for poll, from := range []int{1, 21, 41, 61} {
+		crawler.pages[trackingRule.BuildingLastPageUrl()] = testBuildingPage(authors, from, from+19)
+		r.feed.pages = make(map[string]*cachedPage)
+
+		page, _, err := m.pollPages(r, poll == 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.shared.Load() {
+			t.Fatalf("poll %d fell back to the s_author page", poll+1)
+		}
+		if poll == 0 {
+			trackingRule.LastFloorIndex = page.Floors[0].FloorIndex
+		} else if len(page.Floors) != 0 {
+			t.Errorf("poll %d found floors %v", poll+1, page.Floors)
+		}
+		if newFloors := m.collectNewFloors(r, page, maxWalkBackPages); len(newFloors) != 0 {
+			t.Errorf("poll %d found new floors", poll+1)
+		}
+	}
+	if crawler.fetches[trackingRule.LastPageUrl] != 0 {
+		t.Errorf("s_author page fetched %d times", crawler.fetches[trackingRule.LastPageUrl])
+	}
+
+	// two pages are posted between polls, the floors of the author on the
+	// one skipped are on the s_author page only
+	crawler.pages[trackingRule.BuildingLastPageUrl()] = testBuildingPage(authors, 101, 120)
+	crawler.pages[trackingRule.LastPageUrl] = &db.PageRecord{PageIndex: 1, Floors: []*db.FloorRecord{
+		{FloorIndex: 5, AuthorId: "baha"}, {FloorIndex: 90, AuthorId: "baha"},
+	}}
+	r.feed.pages = make(map[string]*cachedPage)
+	page, _, err := m.pollPages(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.shared.Load() || crawler.fetches[trackingRule.LastPageUrl] != 1 {
+		t.Fatal("expect a fallback to the s_author page")
+	}
+	if newFloors := m.collectNewFloors(r, page, maxWalkBackPages); len(newFloors) != 1 || newFloors[0].FloorIndex != 90 {
+		t.Errorf("new floors = %v", newFloors)
+	}
+	if r.checkedFloorIndex != 120 {
+		t.Errorf("checked floor = B%d", r.checkedFloorIndex)
+	}
+}
+
+func TestCollectNewFloorsWatchWalksBack(t *testing.T) {
+	trackingRule := rule.NewTrackingRule(rule.Bsn(60076), rule.Sna(123), rule.Name("news"), rule.Keywords("更新"))
+	trackingRule.LastFloorIndex = 35
+	crawler := &stubCrawler{pages: make(map[string]*db.PageRecord), fetches: make(map[string]int)}
+	m := &monitor{crawler: crawler}
+	r := newTestRunner(trackingRule)
+
+	newest := testBuildingPage(nil, 41, 50)
+	newest.PageIndex = 5
+	crawler.pages[trackingRule.BuildingLastPageUrl()] = newest
+	previous := testBuildingPage(nil, 31, 40)
+	previous.PageIndex = 4
+	crawler.pages[trackingRule.GetPageUrl(4)] = previous
+
+	page, _, err := m.pollPages(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFloors := m.collectNewFloors(r, page, maxWalkBackPages)
+	if len(newFloors) != 15 || newFloors[0].FloorIndex != 36 || newFloors[14].FloorIndex != 50 {
+		t.Errorf("got %d new floors, want B36 to B50", len(newFloors))
+	}
+}
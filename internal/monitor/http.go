@@ -0,0 +1,380 @@
+package monitor
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	shutdownTimeout = 5 * time.Second
+
+	// controlCookie keeps the token of a browser which logged in through
+	// the token query parameter
+	controlCookie = "baha_control_token"
+)
+
+type controlServer struct {
+	monitor  Monitor
+	token    string
+	ruleOpts []rule.RuleOption
+}
+
+// NewControlHandler serves the control API of the live rules of monitor,
+// ruleOpts are applied to every rule added through it. Every request must
+// carry "Authorization: Bearer token", or the cookie a browser gets by
+// opening any page with "?token=token", unless token is empty. Requests
+// changing a rule must be JSON and same-origin so a page on another site
+// cannot make a browser send them.
+//
+//	GET    /                          dashboard
+//	GET    /?token={token}            log in and go to the dashboard
+//	GET    /api/rules                 rule list and status
+//	POST   /api/rules                 add a rule, the body is a rule.RuleConfig
+//	DELETE /api/rules/{key}           remove a rule
+//	POST   /api/rules/{key}/pause     pause a rule
+//	POST   /api/rules/{key}/resume    resume a paused or failed rule
+//	POST   /api/rules/{key}/poll      poll a rule now
+//	GET    /metrics                   Prometheus metrics
+func NewControlHandler(monitor Monitor, token string, ruleOpts ...rule.RuleOption) http.Handler {
+	server := &controlServer{monitor: monitor, token: token, ruleOpts: ruleOpts}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", server.handleDashboard)
+	mux.HandleFunc("/api/rules", server.handleRules)
+	mux.HandleFunc("/api/rules/", server.handleRule)
+	mux.Handle("/metrics", metrics.Handler())
+	return server.guard(mux)
+}
+
+// ServeControl must be called before Start, the control API is then served
+// on addr while the monitor runs. Start refuses an addr other than loopback
+// without a token.
+func (m *monitor) ServeControl(addr, token string, ruleOpts ...rule.RuleOption) {
+	m.controlAddr = addr
+	m.controlToken = token
+	m.controlHandler = NewControlHandler(m, token, ruleOpts...)
+}
+
+// isLoopback reports whether addr only accepts connections from the local
+// host, an empty host listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func checkControlAddr(addr, token string) error {
+	if token == "" && !isLoopback(addr) {
+		return fmt.Errorf("control API on %s is reachable from other hosts, a token is required", addr)
+	}
+	return nil
+}
+
+// sameOrigin reports whether a request sent by a browser comes from a page
+// of the control API itself, requests without Origin are not from a page
+// of another site.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, r.Host)
+}
+
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+func (server *controlServer) authorized(r *http.Request) bool {
+	if server.token == "" {
+		return true
+	}
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		return server.validToken(token)
+	}
+	if cookie, err := r.Cookie(controlCookie); err == nil {
+		return server.validToken(cookie.Value)
+	}
+	return false
+}
+
+func (server *controlServer) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(server.token)) == 1
+}
+
+// login keeps the token of the query in a cookie and redirects to the page
+// without it, so the token does not stay in the address bar and history.
+// The cookie is never sent along with a request from another site.
+func (server *controlServer) login(w http.ResponseWriter, r *http.Request, token string) {
+	if !server.validToken(token) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     controlCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	target := *r.URL
+	query := target.Query()
+	query.Del("token")
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.RequestURI(), http.StatusSeeOther)
+}
+
+// guard checks the token of every request. A request which is not a GET
+// must also be JSON, which a cross-site form cannot send and a cross-site
+// fetch must ask for first, and must not come from a page of another
+// origin.
+func (server *controlServer) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" && server.token != "" && r.Method == http.MethodGet {
+			server.login(w, r, token)
+			return
+		}
+		if !server.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid token"})
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if !sameOrigin(r) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "cross-origin request"})
+				return
+			}
+			if !isJSON(r) {
+				writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "Content-Type must be application/json"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenAndServeControl serves handler on addr until ctx is done.
+func listenAndServeControl(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logrus.Infof("Control API listening on %s", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logrus.WithError(err).Error("server.ListenAndServe failed")
+		return err
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logrus.WithError(err).Error("json.Encode failed")
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, ErrRuleNotFound) {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (server *controlServer) handleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, server.monitor.Rules())
+	case http.MethodPost:
+		var config rule.RuleConfig
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		newRule, err := config.NewRule(server.ruleOpts...)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := server.monitor.AddRule(newRule); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"key": newRule.Key()})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRule serves /api/rules/{key} and /api/rules/{key}/{action}, the key
+// is path escaped since a watch rule is keyed by its name.
+func (server *controlServer) handleRule(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/api/rules/")
+	escapedKey, action, _ := strings.Cut(path, "/")
+	key, err := url.PathUnescape(escapedKey)
+	if err != nil || key == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if action == "" {
+		if r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := server.monitor.RemoveRule(key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch action {
+	case "pause":
+		err = server.monitor.DisableRule(key)
+	case "resume":
+		err = server.monitor.EnableRule(key)
+	case "poll":
+		err = server.monitor.PollNow(key)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"since": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return time.Since(t).Round(time.Second).String() + " ago"
+	},
+	"until": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return "in " + time.Until(t).Round(time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>BahaMaster monitor</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.running { color: green; } .paused { color: gray; } .failed { color: red; }
+</style>
+</head>
+<body>
+<h1>BahaMaster monitor</h1>
+<table>
+<tr><th>Rule</th><th>State</th><th>Interval</th><th>Last poll</th><th>Next poll</th><th>Last floor</th><th>Failures</th><th>Last error</th><th></th></tr>
+{{range .}}
+<tr>
+<td><a href="{{.Url}}">{{if .Name}}{{.Name}}{{else}}{{.Key}}{{end}}</a></td>
+<td class="{{.State}}">{{.State}}</td>
+<td>{{.Interval}}</td>
+<td>{{since .LastPoll}}</td>
+<td>{{until .NextPoll}}</td>
+<td>B{{.LastFloorIndex}}</td>
+<td>{{.FailureCount}}</td>
+<td>{{.LastError}}</td>
+<td>
+<button onclick="act('{{.Key}}', 'poll')">Poll now</button>
+{{if eq .State "running"}}<button onclick="act('{{.Key}}', 'pause')">Pause</button>{{else}}<button onclick="act('{{.Key}}', 'resume')">Resume</button>{{end}}
+<button onclick="remove('{{.Key}}')">Remove</button>
+</td>
+</tr>
+{{end}}
+</table>
+<h2>Add rule</h2>
+<textarea id="rule" rows="6" cols="60">{"bsn": 60076, "sna": 3146926, "author": "", "interval": "30s"}</textarea><br>
+<button onclick="add()">Add</button>
+<script>
+const headers = {"Content-Type": "application/json"};
+async function check(res) {
+  if (!res.ok) { alert((await res.json()).error); }
+  location.reload();
+}
+function act(key, action) {
+  fetch("/api/rules/" + encodeURIComponent(key) + "/" + action, {method: "POST", headers: headers}).then(check);
+}
+function remove(key) {
+  if (confirm("Remove " + key + "?")) {
+    fetch("/api/rules/" + encodeURIComponent(key), {method: "DELETE", headers: headers}).then(check);
+  }
+}
+function add() {
+  fetch("/api/rules", {method: "POST", headers: headers, body: document.getElementById("rule").value}).then(check);
+}
+</script>
+</body>
+</html>
+`))
+
+func (server *controlServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, server.monitor.Rules()); err != nil {
+		logrus.WithError(err).Error("dashboardTemplate.Execute failed")
+	}
+}
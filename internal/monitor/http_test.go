@@ -0,0 +1,148 @@
+package monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubMonitor records the rules paused through the control API, the other
+// methods of Monitor are not used by these tests.
+type stubMonitor struct {
+	Monitor
+	paused []string
+}
+
+func (stub *stubMonitor) DisableRule(key string) error {
+	stub.paused = append(stub.paused, key)
+	return nil
+}
+
+func (stub *stubMonitor) Rules() []*RuleStatus {
+	return []*RuleStatus{}
+}
+
+func TestControlGuard(t *testing.T) {
+	cases := []struct {
+		name   string
+		token  string
+		method string
+		header map[string]string
+		status int
+	}{
+		{"json same origin", "", http.MethodPost, map[string]string{"Content-Type": "application/json", "Origin": "http://example.com"}, http.StatusNoContent},
+		{"json without origin", "", http.MethodPost, map[string]string{"Content-Type": "application/json; charset=utf-8"}, http.StatusNoContent},
+		{"form post", "", http.MethodPost, map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType},
+		{"no content type", "", http.MethodPost, nil, http.StatusUnsupportedMediaType},
+		{"cross origin", "", http.MethodPost, map[string]string{"Content-Type": "application/json", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"null origin", "", http.MethodPost, map[string]string{"Content-Type": "application/json", "Origin": "null"}, http.StatusForbidden},
+		{"missing token", "secret", http.MethodPost, map[string]string{"Content-Type": "application/json"}, http.StatusUnauthorized},
+		{"wrong token", "secret", http.MethodPost, map[string]string{"Content-Type": "application/json", "Authorization": "Bearer nope"}, http.StatusUnauthorized},
+		{"token", "secret", http.MethodPost, map[string]string{"Content-Type": "application/json", "Authorization": "Bearer secret"}, http.StatusNoContent},
+		{"get without token", "secret", http.MethodGet, nil, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stub := &stubMonitor{}
+			handler := NewControlHandler(stub, c.token)
+
+			path := "/api/rules/author%3Abaha/pause"
+			if c.method == http.MethodGet {
+				path = "/api/rules"
+			}
+			req := httptest.NewRequest(c.method, "http://example.com"+path, strings.NewReader("{}"))
+			for key, value := range c.header {
+				req.Header.Set(key, value)
+			}
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != c.status {
+				t.Fatalf("status = %d, want %d: %s", res.Code, c.status, res.Body.String())
+			}
+			if c.method == http.MethodPost && c.status == http.StatusNoContent {
+				if len(stub.paused) != 1 || stub.paused[0] != "author:baha" {
+					t.Errorf("paused = %v", stub.paused)
+				}
+			} else if len(stub.paused) != 0 {
+				t.Errorf("rule paused by a rejected request")
+			}
+		})
+	}
+}
+
+func TestCheckControlAddr(t *testing.T) {
+	cases := []struct {
+		addr  string
+		token string
+		ok    bool
+	}{
+		{"localhost:8080", "", true},
+		{"127.0.0.1:8080", "", true},
+		{"[::1]:8080", "", true},
+		{":8080", "", false},
+		{"0.0.0.0:8080", "", false},
+		{"192.168.1.2:8080", "", false},
+		{":8080", "secret", true},
+	}
+	for _, c := range cases {
+		if err := checkControlAddr(c.addr, c.token); (err == nil) != c.ok {
+			t.Errorf("checkControlAddr(%q, %q) = %v", c.addr, c.token, err)
+		}
+	}
+}
+
+func TestControlDashboardLogin(t *testing.T) {
+	stub := &stubMonitor{}
+	handler := NewControlHandler(stub, "secret")
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	// a browser opening the dashboard sends no Authorization header
+	if res := serve(httptest.NewRequest(http.MethodGet, "http://example.com/", nil)); res.Code != http.StatusUnauthorized {
+		t.Fatalf("dashboard without login = %d", res.Code)
+	}
+	if res := serve(httptest.NewRequest(http.MethodGet, "http://example.com/?token=nope", nil)); res.Code != http.StatusUnauthorized {
+		t.Fatalf("login with a wrong token = %d", res.Code)
+	}
+
+	res := serve(httptest.NewRequest(http.MethodGet, "http://example.com/?token=secret", nil))
+	if res.Code != http.StatusSeeOther || res.Header().Get("Location") != "/" {
+		t.Fatalf("login = %d to %q", res.Code, res.Header().Get("Location"))
+	}
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode || cookies[0].Path != "/" {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.AddCookie(cookies[0])
+	if res := serve(req); res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "<html") {
+		t.Fatalf("dashboard after login = %d", res.Code)
+	}
+
+	// the fetch calls of the dashboard carry the cookie
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/api/rules", nil)
+	req.AddCookie(cookies[0])
+	if res := serve(req); res.Code != http.StatusOK {
+		t.Fatalf("rules after login = %d", res.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "http://example.com/api/rules/author%3Abaha/pause", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "http://example.com")
+	req.AddCookie(cookies[0])
+	if res := serve(req); res.Code != http.StatusNoContent || len(stub.paused) != 1 {
+		t.Fatalf("pause after login = %d", res.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: cookies[0].Name, Value: "nope"})
+	if res := serve(req); res.Code != http.StatusUnauthorized {
+		t.Errorf("dashboard with a wrong cookie = %d", res.Code)
+	}
+}
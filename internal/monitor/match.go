@@ -0,0 +1,52 @@
+package monitor
+
+import (
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/event"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/davidleitw/baha/internal/notify"
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+const matchExcerptLength = rule.DefaultHighlightLength
+
+// matchFloor matches a new floor of a watch rule, its replies are matched
+// by matchReply as they are found.
+func (m *monitor) matchFloor(rule *rule.TrackingRule, floor *db.FloorRecord) {
+	if match := rule.MatchFloor(floor, nil); match != nil {
+		m.handleMatch(rule, match)
+	}
+}
+
+func (m *monitor) matchReply(rule *rule.TrackingRule, floor *db.FloorRecord, reply *db.ReplyRecord) {
+	if match := rule.MatchFloor(floor, reply); match != nil {
+		m.handleMatch(rule, match)
+	}
+}
+
+func (m *monitor) handleMatch(rule *rule.TrackingRule, match *rule.Match) {
+	floor := match.Floor
+	url := rule.GetPageUrl(floor.PageIndex)
+
+	metrics.MonitorCallbacks.WithLabelValues(rule.Key(), notify.EventMatch).Inc()
+	rule.MatchCallback(match)
+	e := &event.Event{Type: event.NewFloor, Floor: floor, Url: url, Terms: match.Terms}
+	if match.Reply != nil {
+		e.Type, e.Reply = event.NewReply, match.Reply
+	}
+	m.publish(rule, e)
+	if m.notifier == nil {
+		return
+	}
+
+	message := notify.NewFloorMessage(notify.EventMatch, rule.Key(), rule.Bsn, rule.Sna, floor, url)
+	message.AuthorName = match.AuthorName()
+	message.AuthorId = match.AuthorId()
+	message.Excerpt = match.Highlight(matchExcerptLength)
+	message.Matches = match.Terms
+	message.IsReply = match.Reply != nil
+	if err := m.notifier.Notify(rule.Sinks, message); err != nil {
+		logrus.WithError(err).Errorf("Notify match of B%d failed", floor.FloorIndex)
+	}
+}
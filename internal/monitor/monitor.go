@@ -1,31 +1,166 @@
 package monitor
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/event"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/davidleitw/baha/internal/notify"
 	"github.com/davidleitw/baha/internal/rule"
+	"github.com/davidleitw/baha/internal/textutil"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// maxWalkBackPages bounds how many pages before the newest one are
+	// fetched to catch up on floors posted between two polls.
+	maxWalkBackPages = 10
+
+	// maxReplayPages bounds the walk back when replaying the floors posted
+	// while the monitor was down.
+	maxReplayPages = 50
+)
+
 type Monitor interface {
+	// Run starts the monitor and blocks until SIGINT or SIGTERM.
 	Run() error
+
+	// Start runs every rule and job in the background until ctx is done or
+	// Stop is called, Wait then returns once all of them are gone.
+	Start(ctx context.Context) error
+	Stop()
+	Wait() error
+
+	// DisableRule pauses a single rule until EnableRule, the others keep
+	// running.
+	DisableRule(key string) error
+	EnableRule(key string) error
+	Failures() []*RuleError
+
+	Rules() []*RuleStatus
+	AddRule(rule *rule.TrackingRule) error
+	RemoveRule(key string) error
+	PollNow(key string) error
+
+	// Subscribe hands the typed events of every rule to handler, which
+	// runs on its own goroutine.
+	Subscribe(name string, handler event.Handler, opts ...event.SubscribeOption) func()
+
+	AddJob(job *Job)
+	// AddBoardRule watches a board for new threads, a thread auto-tracked
+	// by it is added as a rule like AddRule does.
+	AddBoardRule(rule *rule.BoardRule)
+	SetNotifier(notifier *notify.Notifier)
+	WatchRulesFile(path string, opts ...rule.RuleOption)
+	ServeControl(addr, token string, ruleOpts ...rule.RuleOption)
+	ReloadRules(rules ...*rule.TrackingRule) error
+}
+
+// Job is a periodic task run alongside the tracking rules, such as
+// generating a digest of a building.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+var (
+	// ErrMaxFailure stops a rule whose pages failed to load MaxFailure times.
+	ErrMaxFailure = errors.New("max failure reached")
+
+	ErrRuleNotFound = errors.New("rule not found")
+)
+
+// RuleError is the terminal error of a rule, only that rule is stopped.
+type RuleError struct {
+	RuleKey string
+	Time    time.Time
+	Err     error
+}
+
+func (ruleErr *RuleError) Error() string {
+	return fmt.Sprintf("rule %s: %v", ruleErr.RuleKey, ruleErr.Err)
+}
+
+func (ruleErr *RuleError) Unwrap() error {
+	return ruleErr.Err
+}
+
+// runner is the goroutine of one rule, stopped on its own when the rule is
+// removed or changed by a reload.
+type runner struct {
+	rule   *rule.TrackingRule
+	cancel context.CancelFunc
+	doneCh chan struct{}
+	pokeCh chan struct{}
+	status *ruleStatus
+	feed   *buildingFeed
+
+	// shared tells whether the rule read the newest page of its building
+	// in its last poll, only then it is woken up by the fetches of others
+	shared atomic.Bool
+	// checkedFloorIndex is the last floor of the building the author of the
+	// rule was looked for, only the goroutine of the rule touches it
+	checkedFloorIndex int
+
+	paused bool
 }
 
 type monitor struct {
 	crawler craw.Crawler
+	db      db.BuildingDB
+
+	mu      sync.Mutex
+	rules   []*rule.TrackingRule
+	runners map[string]*runner
+
+	feedMu sync.Mutex
+	feeds  map[string]*buildingFeed
+
+	// archiveMu serializes the writes of rules with SyncLocalDb, archived
+	// is the fingerprint of every floor last written
+	archiveMu sync.Mutex
+	archived  map[string]uint64
+
+	jobs      []*Job
+	boards    []*rule.BoardRule
+	bus       *event.Bus
+	notifier  *notify.Notifier
+	rulesFile *rulesFile
+
+	// autoTracks are the rules started by board rules, guarded by mu. They
+	// are kept apart from the rules file and merged into every reload.
+	autoTracks map[string]*autoTrack
 
-	rules  []*rule.TrackingRule
-	stopCh chan struct{}
+	controlAddr    string
+	controlToken   string
+	controlHandler http.Handler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	failureMu sync.Mutex
+	failures  map[string]*RuleError
 }
 
 var _ Monitor = &monitor{}
 
 func NewMonitor(account, password string, rules ...*rule.TrackingRule) (Monitor, error) {
-	crawler, err := craw.NewCrawler()
+	crawler, err := craw.NewCrawler(craw.OptionsFromEnv()...)
 	if err != nil {
 		logrus.WithError(err).Error("NewCrawler error")
 		return nil, err
@@ -36,65 +171,668 @@ func NewMonitor(account, password string, rules ...*rule.TrackingRule) (Monitor,
 		return nil, err
 	}
 
-	return &monitor{rules: rules, crawler: crawler, stopCh: make(chan struct{})}, nil
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		logrus.WithError(err).Error("buildingDb.Open failed")
+		return nil, err
+	}
+
+	return &monitor{
+		rules:      rules,
+		runners:    make(map[string]*runner),
+		feeds:      make(map[string]*buildingFeed),
+		archived:   make(map[string]uint64),
+		autoTracks: make(map[string]*autoTrack),
+		bus:        event.NewBus(),
+		crawler:    crawler,
+		db:         buildingDb,
+		failures:   make(map[string]*RuleError),
+	}, nil
+}
+
+// collectNewFloors returns every floor after rule.LastFloorIndex in order.
+// The newest page may start after the last seen floor when several floors
+// were posted between polls, then the previous pages are walked as well.
+func (m *monitor) collectNewFloors(r *runner, lastPage *db.PageRecord, maxPages int) []*db.FloorRecord {
+	rule := r.rule
+	newFloors := floorsAfter(lastPage.Floors, rule.LastFloorIndex)
+	if r.shared.Load() && !rule.IsWatch() {
+		// the floors of the author were filtered from a newest page of the
+		// building starting no later than the floor after the last checked
+		// one, and its previous pages are not pages of the rule
+		return newFloors
+	}
+
+	page := lastPage
+	for walked := 0; walked < maxPages; walked++ {
+		if page.PageIndex <= 1 || len(page.Floors) == 0 || page.Floors[0].FloorIndex <= rule.LastFloorIndex {
+			break
+		}
+
+		previous, err := m.fetchShared(r, rule.GetPageUrl(page.PageIndex-1))
+		if err != nil {
+			logrus.WithError(err).Errorf("ParsePage of page %d failed, floors before B%d may be missed",
+				page.PageIndex-1, page.Floors[0].FloorIndex)
+			break
+		}
+
+		newFloors = append(floorsAfter(previous.Floors, rule.LastFloorIndex), newFloors...)
+		page = previous
+	}
+	return newFloors
+}
+
+func floorsAfter(floors []*db.FloorRecord, floorIndex int) []*db.FloorRecord {
+	result := make([]*db.FloorRecord, 0)
+	for _, floor := range floors {
+		if floor.FloorIndex > floorIndex {
+			result = append(result, floor)
+		}
+	}
+	return result
+}
+
+func findFloor(floors []*db.FloorRecord, floorIndex int) *db.FloorRecord {
+	for _, floor := range floors {
+		if floor.FloorIndex == floorIndex {
+			return floor
+		}
+	}
+	return nil
 }
 
-func (m *monitor) activateTrackLoop(rule *rule.TrackingRule) {
-	firstTimeFlag := true
+// restoreCursor loads the last floor seen by the previous run, it returns
+// false when there is nothing to replay from. A rule restarted by a reload
+// resumes from its cursor even with SkipReplay.
+func (m *monitor) restoreCursor(rule *rule.TrackingRule, resume bool) bool {
+	if rule.SkipReplay && !resume {
+		return false
+	}
+
+	cursor, err := m.db.GetMonitorCursorRecord(rule.Key())
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.GetMonitorCursorRecord failed")
+		}
+		return false
+	}
+
+	logrus.Infof("Replay %s from B%d seen at %s", rule.Key(), cursor.LastFloorIndex, cursor.UpdatedAt.Format(time.RFC3339))
+	rule.LastFloorIndex = cursor.LastFloorIndex
+	rule.LastFloorRecord = &db.FloorRecord{FloorIndex: cursor.LastFloorIndex, Content: cursor.LastFloorContent}
+	return true
+}
+
+func (m *monitor) saveCursor(rule *rule.TrackingRule) {
+	if err := m.db.SaveMonitorCursorRecord(&db.MonitorCursorRecord{
+		RuleKey:          rule.Key(),
+		LastFloorIndex:   rule.LastFloorIndex,
+		LastFloorContent: rule.LastFloorRecord.Content,
+		UpdatedAt:        time.Now(),
+	}); err != nil {
+		logrus.WithError(err).Error("db.SaveMonitorCursorRecord failed")
+	}
+}
+
+// sleep waits for d, it returns false when ctx is done in the meantime.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// wait sleeps until the next poll of the rule, PollNow cuts it short.
+func (r *runner) wait(ctx context.Context, d time.Duration) bool {
+	r.status.scheduled(time.Now().Add(d))
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-r.pokeCh:
+		return true
+	case <-timer.C:
+		return true
+	}
+}
+
+// activateTrackLoop polls the pages of the rule until ctx is done, it
+// returns an error only when the rule cannot go on.
+func (m *monitor) activateTrackLoop(ctx context.Context, r *runner, resume bool) error {
+	rule := r.rule
+	replay := m.restoreCursor(rule, resume)
+	firstTimeFlag := !replay
 	maxFailure := rule.GetMaxFailure()
-	interval := rule.GetInterval()
+	schedule := newScheduler(rule)
+	buildingTarget := craw.TargetInfo{Bsn: rule.Bsn, Sna: rule.Sna}
+	replies := newReplyTracker()
+	authorReplies := newReplyTracker()
+	// deletedFloorIndex keeps a deleted floor from being reported every poll
+	deletedFloorIndex := 0
+	sessionExpired := false
+
+	if delay := schedule.initialDelay(time.Now()); delay > 0 {
+		r.wait(ctx, delay)
+	}
 
 	for {
 		select {
-		case <-m.stopCh:
-			logrus.Info("Stop aim loop")
-			return
+		case <-ctx.Done():
+			logrus.Infof("Stop aim loop of %s", rule.Key())
+			return nil
 		default:
-			pageRecord, err := m.crawler.ParsePage(rule.LastPageUrl)
-			if err != nil || pageRecord.Floors == nil {
+			pageRecord, buildingPage, err := m.pollPages(r, firstTimeFlag)
+			// the newest page of the building may hold no floor of the author,
+			// but it is never empty itself
+			if err == nil && len(pageRecord.Floors) == 0 && (!r.shared.Load() || rule.IsWatch()) {
+				err = errors.New("no floor found")
+			}
+			metrics.MonitorPolls.WithLabelValues(rule.Key(), metrics.Result(err)).Inc()
+			if err != nil {
 				logrus.WithError(err).Error("ParsePage error")
+				r.status.polled(err, rule.LastFloorIndex)
+				if errors.Is(err, craw.ErrSessionExpired) && !sessionExpired {
+					m.publish(rule, &event.Event{Type: event.SessionExpired, Url: rule.LastPageUrl, Err: err})
+				}
+				sessionExpired = errors.Is(err, craw.ErrSessionExpired)
 
 				maxFailure--
 				if maxFailure == 0 {
-					logrus.Error("Max failure reached")
-					m.stopCh <- struct{}{}
+					logrus.Errorf("Max failure of %s reached", rule.Key())
+					return ErrMaxFailure
 				}
 
-				time.Sleep(interval)
+				r.wait(ctx, schedule.next(time.Now(), false))
 				continue
 			}
 
-			lastFloor := pageRecord.Floors[len(pageRecord.Floors)-1]
-			// Mean new floor
-			if !firstTimeFlag && lastFloor.FloorIndex != rule.LastFloorIndex {
-				rule.NewPostCallback(lastFloor)
+			r.status.polled(nil, rule.LastFloorIndex)
+			sessionExpired = false
+			if rule.SyncLocalDb {
+				if buildingPage != nil {
+					m.archiveFloors(rule, buildingPage.Floors, true)
+				}
+				if !r.shared.Load() {
+					m.archiveFloors(rule, pageRecord.Floors, false)
+				}
+			}
+
+			active := false
+			if rule.TracksAuthorReplies() && buildingPage != nil {
+				active = m.pollAuthorReplies(rule, buildingPage, authorReplies, firstTimeFlag)
 			}
 
-			// Mean update content
-			if !firstTimeFlag && lastFloor.Content != rule.LastFloorRecord.Content {
-				rule.UpdateLastCallback(lastFloor)
+			// floors read from the newest page of the building are on its
+			// pages, not on the s_author pages of the rule
+			pageUrl := rule.GetPageUrl
+			if r.shared.Load() {
+				pageUrl = buildingTarget.GetPageUrl
+			}
+
+			if firstTimeFlag {
+				if rule.TracksReplies() {
+					for _, floor := range pageRecord.Floors {
+						replies.observe(floor, false)
+					}
+				}
+				lastFloor := pageRecord.Floors[len(pageRecord.Floors)-1]
+				rule.LastFloorIndex = lastFloor.FloorIndex
+				rule.LastFloorRecord = lastFloor
+				firstTimeFlag = false
+				m.saveCursor(rule)
+				r.status.polled(nil, rule.LastFloorIndex)
+				r.wait(ctx, schedule.next(time.Now(), true))
+				continue
 			}
 
-			rule.LastFloorIndex = lastFloor.FloorIndex
-			rule.LastFloorRecord = lastFloor
-			firstTimeFlag = false
-			time.Sleep(interval)
+			changed := false
+			lastFloor := findFloor(pageRecord.Floors, rule.LastFloorIndex)
+			// Mean update content of the last seen floor
+			if lastFloor != nil && lastFloor.Content != rule.LastFloorRecord.Content {
+				m.handleUpdatedFloor(rule, lastFloor, pageUrl(lastFloor.PageIndex))
+				rule.LastFloorRecord = lastFloor
+				changed = true
+			}
+			// Mean the last seen floor is gone from the page holding it
+			if lastFloor == nil && !rule.IsWatch() && deletedFloorIndex != rule.LastFloorIndex &&
+				len(pageRecord.Floors) > 0 && pageRecord.Floors[0].FloorIndex < rule.LastFloorIndex {
+				m.handleDeletedFloor(rule, rule.LastFloorRecord)
+				deletedFloorIndex = rule.LastFloorIndex
+			}
+
+			maxPages := maxWalkBackPages
+			if replay {
+				maxPages = maxReplayPages
+				replay = false
+			}
+
+			// Mean new floors, oldest first
+			newFloors := m.collectNewFloors(r, pageRecord, maxPages)
+			if rule.SyncLocalDb {
+				m.archiveFloors(rule, newFloors, r.shared.Load())
+			}
+			if rule.TracksReplies() {
+				// Mean new replies under floors seen before
+				for _, floor := range pageRecord.Floors {
+					if floor.FloorIndex <= rule.LastFloorIndex {
+						newReplies := replies.observe(floor, false)
+						m.handleNewReplies(rule, floor, newReplies)
+						active = active || len(newReplies) > 0
+					}
+				}
+			}
+			for _, floor := range newFloors {
+				m.handleNewFloor(rule, floor, pageUrl(floor.PageIndex))
+				if rule.TracksReplies() {
+					m.handleNewReplies(rule, floor, replies.observe(floor, true))
+				}
+				rule.LastFloorIndex = floor.FloorIndex
+				rule.LastFloorRecord = floor
+				changed = true
+			}
+			replies.retain(pageRecord.Floors)
+
+			if changed {
+				m.saveCursor(rule)
+				r.status.polled(nil, rule.LastFloorIndex)
+			}
+			r.wait(ctx, schedule.next(time.Now(), active || changed))
 		}
 	}
 }
 
-func (m *monitor) Run() error {
+// SetNotifier must be called before Start, every floor passed to the rule
+// callbacks is then also sent to the sinks of the rule.
+func (m *monitor) SetNotifier(notifier *notify.Notifier) {
+	m.notifier = notifier
+}
+
+func (m *monitor) notify(rule *rule.TrackingRule, message *notify.Message) {
+	if m.notifier == nil {
+		return
+	}
+
+	if err := m.notifier.Notify(rule.Sinks, message); err != nil {
+		logrus.WithError(err).Errorf("Notify %s of B%d failed", message.Event, message.FloorIndex)
+	}
+}
+
+// handleNewFloor passes a floor seen for the first time to the rule, url is
+// the page the floor was found on.
+func (m *monitor) handleNewFloor(rule *rule.TrackingRule, floor *db.FloorRecord, url string) {
+	if rule.IsWatch() {
+		m.matchFloor(rule, floor)
+		return
+	}
+
+	if rule.MatchText(textutil.PlainText(floor.Content)) {
+		metrics.MonitorCallbacks.WithLabelValues(rule.Key(), notify.EventNewPost).Inc()
+		rule.NewPostCallback(floor)
+		m.notify(rule, notify.NewFloorMessage(notify.EventNewPost, rule.Key(), rule.Bsn, rule.Sna, floor, url))
+		m.publish(rule, &event.Event{Type: event.NewFloor, Floor: floor, Url: url})
+	}
+}
+
+// handleUpdatedFloor passes an edit of the last seen floor to the rule,
+// watch rules only look at new posts. An edit leaving the text as it was,
+// such as a change of markup or of an image, is ignored.
+func (m *monitor) handleUpdatedFloor(rule *rule.TrackingRule, floor *db.FloorRecord, url string) {
+	if rule.IsWatch() {
+		return
+	}
+
+	previous, text := textutil.PlainText(rule.LastFloorRecord.Content), textutil.PlainText(floor.Content)
+	if previous == text {
+		logrus.Debugf("Edit of B%d of %s only changed the markup", floor.FloorIndex, rule.Key())
+		return
+	}
+
+	if rule.MatchText(text) {
+		diff := textutil.Diff(previous, text)
+		metrics.MonitorCallbacks.WithLabelValues(rule.Key(), notify.EventUpdateLast).Inc()
+		rule.UpdateLastCallback(floor)
+		m.notify(rule, notify.NewEditMessage(rule.Key(), rule.Bsn, rule.Sna, floor, diff, url))
+		m.publish(rule, &event.Event{Type: event.FloorEdited, Floor: floor, Previous: rule.LastFloorRecord, Url: url, Diff: diff})
+	}
+}
+
+// AddJob must be called before Start.
+func (m *monitor) AddJob(job *Job) {
+	m.jobs = append(m.jobs, job)
+}
+
+func (m *monitor) activateJobLoop(ctx context.Context, job *Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Infof("Stop job %s", job.Name)
+			return
+		case <-ticker.C:
+			if err := job.Run(); err != nil {
+				logrus.WithError(err).Errorf("Job %s failed", job.Name)
+			}
+		}
+	}
+}
+
+// startRule must be called with m.mu held. The status of the rule is kept
+// when it is restarted.
+func (m *monitor) startRule(rule *rule.TrackingRule, resume bool) {
+	status := newRuleStatus(rule)
+	previous, restarted := m.runners[rule.Key()]
+	if restarted {
+		status = previous.status
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	r := &runner{
+		rule:   rule,
+		cancel: cancel,
+		doneCh: make(chan struct{}),
+		pokeCh: make(chan struct{}, 1),
+		status: status,
+	}
+	m.runners[rule.Key()] = r
+	m.joinFeed(r)
+
+	m.failureMu.Lock()
+	delete(m.failures, rule.Key())
+	m.failureMu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(r.doneCh)
+		defer m.leaveFeed(r)
+		defer cancel()
+
+		// the cursor of a restarted rule is saved by its previous goroutine
+		// on the way out, which is not waited for under m.mu
+		if restarted {
+			<-previous.doneCh
+		}
+
+		if err := m.activateTrackLoop(ctx, r, resume); err != nil {
+			m.failureMu.Lock()
+			m.failures[rule.Key()] = &RuleError{RuleKey: rule.Key(), Time: time.Now(), Err: err}
+			m.failureMu.Unlock()
+			m.publish(rule, &event.Event{Type: event.RuleFailed, Url: rule.Url, Err: err})
+			logrus.WithError(err).Errorf("Rule %s stopped, the other rules keep running", rule.Key())
+		}
+	}()
+}
+
+// pauseRule must be called with m.mu held. It only cancels the goroutine of
+// the rule, the returned channel is closed once the goroutine is gone and
+// must be waited on after m.mu is released, a fetch in flight may take a
+// while to give up.
+func (m *monitor) pauseRule(r *runner) <-chan struct{} {
+	r.cancel()
+	r.paused = true
+
+	m.failureMu.Lock()
+	delete(m.failures, r.rule.Key())
+	m.failureMu.Unlock()
+	return r.doneCh
+}
+
+// stopRule must be called with m.mu held, the rule is forgotten. As with
+// pauseRule the returned channel is waited on without m.mu.
+func (m *monitor) stopRule(key string) <-chan struct{} {
+	r, exist := m.runners[key]
+	if !exist {
+		return nil
+	}
+	delete(m.runners, key)
+	return m.pauseRule(r)
+}
+
+// waitRules blocks until the goroutines of the rules paused or stopped are
+// gone, it must be called without m.mu held.
+func waitRules(doneChs ...<-chan struct{}) {
+	for _, doneCh := range doneChs {
+		if doneCh != nil {
+			<-doneCh
+		}
+	}
+}
+
+// failed reports whether the rule stopped by itself on a terminal error.
+func (m *monitor) failed(key string) bool {
+	m.failureMu.Lock()
+	defer m.failureMu.Unlock()
+	_, exist := m.failures[key]
+	return exist
+}
+
+func (m *monitor) DisableRule(key string) error {
+	m.mu.Lock()
+	r, exist := m.runners[key]
+	if !exist {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, key)
+	}
+	var doneCh <-chan struct{}
+	if !r.paused {
+		doneCh = m.pauseRule(r)
+		logrus.Infof("Rule %s disabled", key)
+	}
+	m.mu.Unlock()
+
+	waitRules(doneCh)
+	return nil
+}
+
+// EnableRule resumes a disabled rule from its cursor, or restarts a failed
+// one.
+func (m *monitor) EnableRule(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, exist := m.runners[key]
+	if !exist {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, key)
+	}
+	if r.paused || m.failed(key) {
+		m.startRule(r.rule, true)
+		logrus.Infof("Rule %s enabled", key)
+	}
+	return nil
+}
+
+// Failures lists the rules stopped by a terminal error, a rule leaves the
+// list when it is disabled, removed or started again by a reload.
+func (m *monitor) Failures() []*RuleError {
+	m.failureMu.Lock()
+	defer m.failureMu.Unlock()
+
+	failures := make([]*RuleError, 0, len(m.failures))
+	for _, failure := range m.failures {
+		failures = append(failures, failure)
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Time.Before(failures[j].Time) })
+	return failures
+}
+
+func (m *monitor) checkSinks(rules []*rule.TrackingRule) error {
+	if m.notifier == nil {
+		return nil
+	}
+
+	configured := make(map[string]bool)
+	for _, name := range m.notifier.SinkNames() {
+		configured[name] = true
+	}
+	for _, rule := range rules {
+		for _, name := range rule.Sinks {
+			if !configured[name] {
+				return fmt.Errorf("rule %s: sink %s is not configured", rule.Key(), name)
+			}
+		}
+	}
+	return nil
+}
+
+// ReloadRules replaces the running rules. Rules not in rules are stopped,
+// new ones are started and changed or failed ones are restarted from their
+// cursor, unchanged rules keep running untouched. The running rules are
+// kept when rules are invalid. Rules auto-tracked by a board rule are kept
+// unless rules has one of the same key.
+func (m *monitor) ReloadRules(rules ...*rule.TrackingRule) error {
+	if err := m.checkSinks(rules); err != nil {
+		logrus.WithError(err).Error("Reload rules failed")
+		return err
+	}
+
+	m.mu.Lock()
+	m.rules = m.mergeAutoTracks(rules)
+	if m.ctx == nil {
+		// not started yet, Start runs the new rules
+		m.mu.Unlock()
+		return nil
+	}
+
+	wanted := make(map[string]*rule.TrackingRule)
 	for _, rule := range m.rules {
-		go m.activateTrackLoop(rule)
+		wanted[rule.Key()] = rule
 	}
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	stopped := make([]<-chan struct{}, 0)
+	for key, r := range m.runners {
+		newRule, exist := wanted[key]
+		if !exist {
+			logrus.Infof("Rule %s removed", key)
+			stopped = append(stopped, m.stopRule(key))
+			continue
+		}
+		if !r.rule.Equal(newRule) || m.failed(key) {
+			logrus.Infof("Rule %s changed", key)
+			// the replacement waits for the old goroutine by itself
+			m.pauseRule(r)
+			m.startRule(newRule, true)
+		}
+	}
 
-	<-stop
-	close(m.stopCh)
+	for key, rule := range wanted {
+		if _, exist := m.runners[key]; !exist {
+			logrus.Infof("Rule %s added", key)
+			m.startRule(rule, false)
+		}
+	}
+	m.mu.Unlock()
 
-	logrus.Info("Shutting down monitor ...")
-	time.Sleep(1 * time.Second)
+	waitRules(stopped...)
 	return nil
 }
+
+func (m *monitor) Start(ctx context.Context) error {
+	m.restoreAutoTracks()
+	if err := m.checkSinks(m.rules); err != nil {
+		logrus.WithError(err).Error("Invalid rules")
+		return err
+	}
+	if m.controlHandler != nil {
+		if err := checkControlAddr(m.controlAddr, m.controlToken); err != nil {
+			logrus.WithError(err).Error("Invalid control API")
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ctx != nil {
+		return errors.New("monitor is already started")
+	}
+	m.ctx, m.cancel = context.WithCancel(ctx)
+
+	for _, rule := range m.rules {
+		m.startRule(rule, false)
+	}
+
+	if m.rulesFile != nil {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.activateWatchLoop(m.ctx, m.rulesFile)
+		}()
+	}
+
+	if m.controlHandler != nil {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			if err := listenAndServeControl(m.ctx, m.controlAddr, m.controlHandler); err != nil {
+				logrus.WithError(err).Error("Control API stopped")
+			}
+		}()
+	}
+
+	for _, board := range m.boards {
+		m.wg.Add(1)
+		go func(board *rule.BoardRule) {
+			defer m.wg.Done()
+			m.activateBoardLoop(m.ctx, board)
+		}(board)
+	}
+
+	for _, job := range m.jobs {
+		m.wg.Add(1)
+		go func(job *Job) {
+			defer m.wg.Done()
+			m.activateJobLoop(m.ctx, job)
+		}(job)
+	}
+	return nil
+}
+
+// Stop cancels every rule and job, use Wait for them to finish.
+func (m *monitor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancel != nil {
+		m.cancel()
+	}
+}
+
+// Wait blocks until every rule and job is gone, which happens once the
+// monitor is stopped, and returns the terminal errors of the rules.
+func (m *monitor) Wait() error {
+	m.wg.Wait()
+	m.bus.Close()
+	if m.notifier != nil {
+		m.notifier.Close()
+	}
+
+	errs := make([]error, 0)
+	for _, failure := range m.Failures() {
+		errs = append(errs, failure)
+	}
+	return errors.Join(errs...)
+}
+
+func (m *monitor) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := m.Start(ctx); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	logrus.Info("Shutting down monitor ...")
+	m.Stop()
+	return m.Wait()
+}
@@ -0,0 +1,141 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/event"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/davidleitw/baha/internal/notify"
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+// replyKeys identify the replies of a floor between polls. The index of a
+// reply is its position on the page unless every reply was loaded from the
+// extend API, so it changes once a floor has enough replies. Identical
+// replies are told apart by their occurrence instead, the same reply posted
+// twice is reported twice.
+func replyKeys(replies []*db.ReplyRecord) []string {
+	occurrences := make(map[string]int)
+	keys := make([]string, 0, len(replies))
+	for _, reply := range replies {
+		key := fmt.Sprintf("%s\x00%s", reply.AuthorId, reply.Content)
+		keys = append(keys, fmt.Sprintf("%s\x00%d", key, occurrences[key]))
+		occurrences[key]++
+	}
+	return keys
+}
+
+// replyTracker remembers the replies seen under each floor so the replies
+// posted between two polls can be told apart.
+type replyTracker struct {
+	seen map[int]map[string]bool
+
+	// lastFloorIndex is the newest floor observed, used when the floors of
+	// the page are not followed by the rule cursor
+	lastFloorIndex int
+}
+
+func newReplyTracker() *replyTracker {
+	return &replyTracker{seen: make(map[int]map[string]bool)}
+}
+
+// observe records the replies of floor and returns those not seen before.
+// The replies of a floor observed for the first time are only returned
+// when the floor itself is fresh, otherwise they are the baseline.
+func (tracker *replyTracker) observe(floor *db.FloorRecord, fresh bool) []*db.ReplyRecord {
+	if floor.FloorIndex > tracker.lastFloorIndex {
+		tracker.lastFloorIndex = floor.FloorIndex
+	}
+
+	seen, exist := tracker.seen[floor.FloorIndex]
+	if !exist {
+		seen = make(map[string]bool)
+		tracker.seen[floor.FloorIndex] = seen
+	}
+
+	newReplies := make([]*db.ReplyRecord, 0)
+	for i, key := range replyKeys(floor.Replies) {
+		reply := floor.Replies[i]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if exist || fresh {
+			newReplies = append(newReplies, reply)
+		}
+	}
+	return newReplies
+}
+
+// retain forgets every floor not in floors, which left the newest page and
+// are not polled anymore.
+func (tracker *replyTracker) retain(floors []*db.FloorRecord) {
+	kept := make(map[int]bool)
+	for _, floor := range floors {
+		kept[floor.FloorIndex] = true
+	}
+	for floorIndex := range tracker.seen {
+		if !kept[floorIndex] {
+			delete(tracker.seen, floorIndex)
+		}
+	}
+}
+
+// handleNewReplies passes the replies posted under a floor of the rule since
+// the previous poll to the rule.
+func (m *monitor) handleNewReplies(rule *rule.TrackingRule, floor *db.FloorRecord, replies []*db.ReplyRecord) {
+	for _, reply := range replies {
+		if rule.IsWatch() {
+			m.matchReply(rule, floor, reply)
+			continue
+		}
+
+		url := rule.GetPageUrl(floor.PageIndex)
+		metrics.MonitorCallbacks.WithLabelValues(rule.Key(), notify.EventNewReply).Inc()
+		rule.NewReplyCallback(floor, reply)
+		m.notifyReply(rule, floor, reply, url)
+		m.publish(rule, &event.Event{Type: event.NewReply, Floor: floor, Reply: reply, Url: url})
+	}
+}
+
+// pollAuthorReplies looks for new replies by the author of the rule on the
+// newest page of the whole building, s_author only filters floors so these
+// are never on the pages of the rule. Replies under older pages are missed.
+// It reports whether a new reply by the author was found.
+func (m *monitor) pollAuthorReplies(rule *rule.TrackingRule, pageRecord *db.PageRecord, tracker *replyTracker, baseline bool) bool {
+	found := false
+
+	targetInfo := craw.TargetInfo{Bsn: rule.Bsn, Sna: rule.Sna}
+	lastFloorIndex := tracker.lastFloorIndex
+	for _, floor := range pageRecord.Floors {
+		fresh := !baseline && floor.FloorIndex > lastFloorIndex
+		for _, reply := range tracker.observe(floor, fresh) {
+			if !strings.EqualFold(reply.AuthorId, rule.AimId) {
+				continue
+			}
+			url := targetInfo.GetPageUrl(floor.PageIndex)
+			metrics.MonitorCallbacks.WithLabelValues(rule.Key(), notify.EventNewReply).Inc()
+			rule.NewReplyCallback(floor, reply)
+			m.notifyReply(rule, floor, reply, url)
+			m.publish(rule, &event.Event{Type: event.NewReply, Floor: floor, Reply: reply, Url: url})
+			found = true
+		}
+	}
+	tracker.retain(pageRecord.Floors)
+	return found
+}
+
+func (m *monitor) notifyReply(rule *rule.TrackingRule, floor *db.FloorRecord, reply *db.ReplyRecord, url string) {
+	if m.notifier == nil {
+		return
+	}
+
+	message := notify.NewReplyMessage(rule.Key(), rule.Bsn, rule.Sna, floor, reply, url)
+	if err := m.notifier.Notify(rule.Sinks, message); err != nil {
+		logrus.WithError(err).Errorf("Notify reply on B%d failed", floor.FloorIndex)
+	}
+}
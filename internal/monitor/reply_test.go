@@ -0,0 +1,72 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/davidleitw/baha/internal/db"
+)
+
+// testFloor is floor 12 with a reply for every "author:content" given, indexed
+// from first.
+func testFloor(first int, replies ...string) *db.FloorRecord {
+	floor := &db.FloorRecord{FloorIndex: 12}
+	for i, reply := range replies {
+		authorId, content, _ := strings.Cut(reply, ":")
+		floor.Replies = append(floor.Replies, &db.ReplyRecord{ReplyIndex: first + i, AuthorId: authorId, Content: content})
+	}
+	return floor
+}
+
+func replyTexts(replies []*db.ReplyRecord) []string {
+	texts := make([]string, 0, len(replies))
+	for _, reply := range replies {
+		texts = append(texts, reply.AuthorId+":"+reply.Content)
+	}
+	return texts
+}
+
+func TestReplyTrackerObserve(t *testing.T) {
+	cases := []struct {
+		name  string
+		polls []*db.FloorRecord
+		fresh bool
+		want  []string
+	}{
+		{"baseline", []*db.FloorRecord{testFloor(0, "a:hi", "b:+1")}, false, nil},
+		{"fresh floor", []*db.FloorRecord{testFloor(0, "a:hi", "b:+1")}, true, []string{"a:hi", "b:+1"}},
+		{"new reply", []*db.FloorRecord{
+			testFloor(0, "a:hi"),
+			testFloor(0, "a:hi", "b:+1"),
+		}, false, []string{"b:+1"}},
+		{"repeated reply", []*db.FloorRecord{
+			testFloor(0, "a:hi", "b:+1"),
+			testFloor(0, "a:hi", "b:+1", "b:+1"),
+		}, false, []string{"b:+1"}},
+		{"same reply by another author", []*db.FloorRecord{
+			testFloor(0, "a:+1"),
+			testFloor(0, "a:+1", "b:+1"),
+		}, false, []string{"b:+1"}},
+		// the floor moved to the extend API, which numbers the replies anew
+		{"reindexed", []*db.FloorRecord{
+			testFloor(0, "a:hi", "b:+1", "b:+1"),
+			testFloor(1, "a:hi", "b:+1", "b:+1"),
+		}, false, nil},
+		// the extend API does not keep the order of the replies
+		{"reordered", []*db.FloorRecord{
+			testFloor(0, "b:+1", "a:hi", "b:+1"),
+			testFloor(0, "a:hi", "b:+1", "b:+1"),
+		}, false, nil},
+	}
+
+	for _, c := range cases {
+		tracker := newReplyTracker()
+		var got []*db.ReplyRecord
+		for i, floor := range c.polls {
+			got = tracker.observe(floor, c.fresh && i == 0)
+		}
+		if strings.Join(replyTexts(got), ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: new replies = %q, want %q", c.name, replyTexts(got), c.want)
+		}
+	}
+}
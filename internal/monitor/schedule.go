@@ -0,0 +1,67 @@
+package monitor
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+// scheduler decides how long a rule sleeps before its next poll. An
+// adaptive rule polls every PokeInterval right after activity and backs off
+// up to MaxInterval while nothing happens, quiet hours skip polling.
+type scheduler struct {
+	rule     *rule.TrackingRule
+	interval time.Duration
+	random   *rand.Rand
+}
+
+func newScheduler(rule *rule.TrackingRule) *scheduler {
+	return &scheduler{
+		rule:     rule,
+		interval: rule.GetInterval(),
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the delay before the next poll, active tells whether the
+// last poll found anything new.
+func (s *scheduler) next(now time.Time, active bool) time.Duration {
+	if active || !s.rule.IsAdaptive() {
+		s.interval = s.rule.GetInterval()
+	} else {
+		s.interval *= rule.DefaultBackoffFactor
+		if s.interval > s.rule.MaxInterval {
+			s.interval = s.rule.MaxInterval
+		}
+	}
+
+	delay := s.jitter(s.interval)
+	if until := s.rule.QuietUntil(now.Add(delay)); !until.IsZero() {
+		logrus.Infof("Rule %s is quiet until %s", s.rule.Key(), until.Format(time.RFC3339))
+		// the first poll after quiet hours starts fast again
+		s.interval = s.rule.GetInterval()
+		delay = until.Sub(now) + time.Duration(float64(s.interval)*s.rule.Jitter*s.random.Float64())
+	}
+	return delay
+}
+
+// initialDelay returns the delay before the first poll, which is put off when the
+// rule starts within its quiet hours.
+func (s *scheduler) initialDelay(now time.Time) time.Duration {
+	if until := s.rule.QuietUntil(now); !until.IsZero() {
+		logrus.Infof("Rule %s is quiet until %s", s.rule.Key(), until.Format(time.RFC3339))
+		return until.Sub(now)
+	}
+	return 0
+}
+
+// jitter spreads d by up to rule.Jitter of it in both directions.
+func (s *scheduler) jitter(d time.Duration) time.Duration {
+	if s.rule.Jitter <= 0 {
+		return d
+	}
+	spread := float64(d) * s.rule.Jitter * (2*s.random.Float64() - 1)
+	return d + time.Duration(spread)
+}
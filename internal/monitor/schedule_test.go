@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/rule"
+)
+
+func newTestScheduler(opts ...rule.RuleOption) *scheduler {
+	s := newScheduler(rule.NewTrackingRule(append([]rule.RuleOption{rule.Bsn(60076), rule.Sna(123), rule.Id("baha")}, opts...)...))
+	s.random = rand.New(rand.NewSource(1))
+	return s
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	cases := []struct {
+		name   string
+		opts   []rule.RuleOption
+		active []bool
+		want   []time.Duration
+	}{
+		{"fixed", []rule.RuleOption{rule.PokeInterval(10 * time.Second)},
+			[]bool{false, false, true}, []time.Duration{10 * time.Second, 10 * time.Second, 10 * time.Second}},
+		{"backs off up to max", []rule.RuleOption{rule.PokeInterval(10 * time.Second), rule.MaxInterval(time.Minute)},
+			[]bool{false, false, false, false}, []time.Duration{20 * time.Second, 40 * time.Second, time.Minute, time.Minute}},
+		{"activity resets", []rule.RuleOption{rule.PokeInterval(10 * time.Second), rule.MaxInterval(time.Minute)},
+			[]bool{false, false, true, false}, []time.Duration{20 * time.Second, 40 * time.Second, 10 * time.Second, 20 * time.Second}},
+		{"max below interval is not adaptive", []rule.RuleOption{rule.PokeInterval(10 * time.Second), rule.MaxInterval(5 * time.Second)},
+			[]bool{false, false}, []time.Duration{10 * time.Second, 10 * time.Second}},
+	}
+
+	now := time.Date(2024, time.January, 10, 12, 0, 0, 0, craw.BahaTimeZone)
+	for _, c := range cases {
+		s := newTestScheduler(c.opts...)
+		for i, active := range c.active {
+			if got := s.next(now, active); got != c.want[i] {
+				t.Errorf("%s: poll %d: next = %s, want %s", c.name, i+1, got, c.want[i])
+			}
+		}
+	}
+}
+
+func TestSchedulerJitterBounds(t *testing.T) {
+	s := newTestScheduler(rule.PokeInterval(10*time.Second), rule.MaxInterval(40*time.Second), rule.Jitter(0.2))
+	now := time.Date(2024, time.January, 10, 12, 0, 0, 0, craw.BahaTimeZone)
+
+	low, high := time.Duration(1<<62), time.Duration(0)
+	for i := 0; i < 1000; i++ {
+		// polls 10s, 20s, 40s, 40s, then starts over after activity
+		active := i%4 == 0
+		delay := s.next(now, active)
+		if min, max := s.interval*8/10, s.interval*12/10; delay < min || delay > max {
+			t.Fatalf("poll %d: next = %s, want within [%s, %s]", i+1, delay, min, max)
+		}
+		if s.interval == 10*time.Second {
+			if delay < low {
+				low = delay
+			}
+			if delay > high {
+				high = delay
+			}
+		}
+	}
+	// the spread goes both ways
+	if low >= 10*time.Second || high <= 10*time.Second {
+		t.Errorf("delays of 10s spread over [%s, %s]", low, high)
+	}
+}
+
+func TestSchedulerQuietHours(t *testing.T) {
+	s := newTestScheduler(rule.PokeInterval(10*time.Second), rule.MaxInterval(time.Minute), rule.Jitter(0.2),
+		rule.QuietHours(rule.QuietWindow{From: 22 * time.Hour, To: 6 * time.Hour}))
+
+	// the first poll waits for the quiet hours to end
+	now := time.Date(2024, time.January, 10, 23, 0, 0, 0, craw.BahaTimeZone)
+	if got := s.initialDelay(now); got != 7*time.Hour {
+		t.Errorf("initial delay = %s, want 7h", got)
+	}
+	if got := s.initialDelay(now.Add(-2 * time.Hour)); got != 0 {
+		t.Errorf("initial delay outside quiet hours = %s", got)
+	}
+
+	// a poll that would land in the window sleeps past its end, plus up to
+	// the jitter of one interval
+	s.next(now.Add(-2*time.Hour), false)
+	now = time.Date(2024, time.January, 10, 21, 59, 55, 0, craw.BahaTimeZone)
+	got := s.next(now, false)
+	if min, max := 8*time.Hour+5*time.Second, 8*time.Hour+7*time.Second; got < min || got >= max {
+		t.Errorf("next before quiet hours = %s, want within [%s, %s)", got, min, max)
+	}
+	// and starts fast again
+	if s.interval != 10*time.Second {
+		t.Errorf("interval after quiet hours = %s", s.interval)
+	}
+}
@@ -0,0 +1,198 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	StateRunning = "running"
+	StatePaused  = "paused"
+	StateFailed  = "failed"
+	// StatePending is a rule added before the monitor is started
+	StatePending = "pending"
+)
+
+// RuleStatus is a snapshot of a rule for the control API.
+type RuleStatus struct {
+	Key      string   `json:"key"`
+	Name     string   `json:"name"`
+	Url      string   `json:"url"`
+	Interval string   `json:"interval"`
+	Sinks    []string `json:"sinks"`
+	State    string   `json:"state"`
+
+	LastPoll       time.Time `json:"last_poll"`
+	NextPoll       time.Time `json:"next_poll"`
+	LastFloorIndex int       `json:"last_floor_index"`
+	FailureCount   int       `json:"failure_count"`
+	LastError      string    `json:"last_error"`
+}
+
+// ruleStatus is updated by the goroutine of the rule and read by the
+// control API.
+type ruleStatus struct {
+	mu     sync.Mutex
+	status RuleStatus
+}
+
+func newRuleStatus(rule *rule.TrackingRule) *ruleStatus {
+	interval := rule.GetInterval().String()
+	if rule.IsAdaptive() {
+		interval += "-" + rule.MaxInterval.String()
+	}
+	return &ruleStatus{status: RuleStatus{
+		Key:      rule.Key(),
+		Name:     rule.Name,
+		Url:      rule.Url,
+		Interval: interval,
+		Sinks:    rule.Sinks,
+	}}
+}
+
+func (status *ruleStatus) polled(err error, lastFloorIndex int) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+
+	status.status.LastPoll = time.Now()
+	status.status.LastFloorIndex = lastFloorIndex
+	if err != nil {
+		status.status.FailureCount++
+		status.status.LastError = err.Error()
+	}
+}
+
+func (status *ruleStatus) scheduled(next time.Time) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.status.NextPoll = next
+}
+
+func (status *ruleStatus) snapshot(state string) *RuleStatus {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+
+	snapshot := status.status
+	snapshot.State = state
+	if state != StateRunning {
+		snapshot.NextPoll = time.Time{}
+	}
+	return &snapshot
+}
+
+// Rules lists every rule of the monitor ordered by key.
+func (m *monitor) Rules() []*RuleStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]*RuleStatus, 0, len(m.rules))
+	if m.ctx == nil {
+		for _, rule := range m.rules {
+			statuses = append(statuses, newRuleStatus(rule).snapshot(StatePending))
+		}
+	}
+	for key, r := range m.runners {
+		state := StateRunning
+		if r.paused {
+			state = StatePaused
+		} else if m.failed(key) {
+			state = StateFailed
+		}
+		statuses = append(statuses, r.status.snapshot(state))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses
+}
+
+// AddRule starts a new rule, a rule with the same key is replaced. A later
+// reload of the rules file drops rules added this way.
+func (m *monitor) AddRule(newRule *rule.TrackingRule) error {
+	if err := m.checkSinks([]*rule.TrackingRule{newRule}); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addRule(newRule)
+	return nil
+}
+
+// addRule must be called with m.mu held.
+func (m *monitor) addRule(newRule *rule.TrackingRule) {
+	rules := make([]*rule.TrackingRule, 0, len(m.rules)+1)
+	for _, rule := range m.rules {
+		if rule.Key() != newRule.Key() {
+			rules = append(rules, rule)
+		}
+	}
+	m.rules = append(rules, newRule)
+
+	if m.ctx != nil {
+		// a replaced rule is not waited for, the new one waits for it
+		if r, exist := m.runners[newRule.Key()]; exist {
+			m.pauseRule(r)
+		}
+		m.startRule(newRule, false)
+	}
+	logrus.Infof("Rule %s added", newRule.Key())
+}
+
+func (m *monitor) RemoveRule(key string) error {
+	m.mu.Lock()
+
+	found := false
+	rules := make([]*rule.TrackingRule, 0, len(m.rules))
+	for _, rule := range m.rules {
+		if rule.Key() == key {
+			found = true
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if !found {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, key)
+	}
+
+	m.rules = rules
+	doneCh := m.stopRule(key)
+	tracked, autoTracked := m.autoTracks[key]
+	delete(m.autoTracks, key)
+	m.mu.Unlock()
+
+	if autoTracked {
+		m.forgetAutoTrack(tracked)
+	}
+	waitRules(doneCh)
+	logrus.Infof("Rule %s removed", key)
+	return nil
+}
+
+// PollNow wakes a running rule up to poll at once instead of waiting for
+// its interval.
+func (m *monitor) PollNow(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, exist := m.runners[key]
+	if !exist {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, key)
+	}
+	if r.paused || m.failed(key) {
+		return fmt.Errorf("rule %s is not running", key)
+	}
+
+	select {
+	case r.pokeCh <- struct{}{}:
+	default:
+		// a poll is already requested
+	}
+	return nil
+}
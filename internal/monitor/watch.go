@@ -0,0 +1,86 @@
+package monitor
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/davidleitw/baha/internal/rule"
+	"github.com/sirupsen/logrus"
+)
+
+// rulesFileCheckInterval is how often the rules file is checked for a
+// change, SIGHUP reloads it at once.
+const rulesFileCheckInterval = 2 * time.Second
+
+type rulesFile struct {
+	path string
+	opts []rule.RuleOption
+
+	modTime time.Time
+	size    int64
+}
+
+// WatchRulesFile must be called before Start, the rules are then reloaded
+// from path whenever the file changes or the process receives SIGHUP. opts
+// are applied to every rule loaded from the file.
+func (m *monitor) WatchRulesFile(path string, opts ...rule.RuleOption) {
+	file := &rulesFile{path: path, opts: opts}
+	if info, err := os.Stat(path); err == nil {
+		file.modTime, file.size = info.ModTime(), info.Size()
+	}
+	m.rulesFile = file
+}
+
+// changed reports whether the file was written since the last check.
+func (file *rulesFile) changed() bool {
+	info, err := os.Stat(file.path)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(file.modTime) && info.Size() == file.size {
+		return false
+	}
+	file.modTime, file.size = info.ModTime(), info.Size()
+	return true
+}
+
+func (m *monitor) reloadRulesFile(file *rulesFile) {
+	rules, err := rule.LoadRulesFile(file.path, file.opts...)
+	if err != nil {
+		logrus.WithError(err).Error("Reload rules file failed, keep the running rules")
+		return
+	}
+
+	if err := m.ReloadRules(rules...); err != nil {
+		return
+	}
+	logrus.Infof("Rules reloaded from %s, %d rules running", file.path, len(rules))
+}
+
+func (m *monitor) activateWatchLoop(ctx context.Context, file *rulesFile) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(rulesFileCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("Stop watching rules file")
+			return
+		case <-hup:
+			logrus.Info("SIGHUP received, reload rules file")
+			file.changed()
+			m.reloadRulesFile(file)
+		case <-ticker.C:
+			if file.changed() {
+				m.reloadRulesFile(file)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SinkWebhook  = "webhook"
+	SinkDiscord  = "discord"
+	SinkTelegram = "telegram"
+	SinkSlack    = "slack"
+	SinkSmtp     = "smtp"
+)
+
+// NewNotifierFromEnv adds a sink for every service configured in the
+// environment, which is expected to be loaded from .env by the caller.
+//
+//	NOTIFY_TEMPLATE                          text/template shared by every sink
+//	NOTIFY_WEBHOOK_URL                       generic JSON webhook
+//	NOTIFY_DISCORD_WEBHOOK_URL               Discord channel webhook
+//	NOTIFY_SLACK_WEBHOOK_URL                 Slack incoming webhook
+//	NOTIFY_TELEGRAM_TOKEN, _CHAT_ID, _API_URL
+//	NOTIFY_SMTP_ADDR, _USERNAME, _PASSWORD, _FROM, _TO (comma separated)
+func NewNotifierFromEnv(opts ...NotifierOption) (*Notifier, error) {
+	notifier := NewNotifier(opts...)
+	tmpl := os.Getenv("NOTIFY_TEMPLATE")
+
+	add := func(sink Sink, err error) error {
+		if err != nil {
+			logrus.WithError(err).Error("create sink failed")
+			return err
+		}
+		notifier.AddSink(sink)
+		logrus.Infof("Notify sink %s enabled", sink.Name())
+		return nil
+	}
+
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		if err := add(NewWebhookSink(SinkWebhook, url, tmpl)); err != nil {
+			return nil, err
+		}
+	}
+
+	if url := os.Getenv("NOTIFY_DISCORD_WEBHOOK_URL"); url != "" {
+		if err := add(NewDiscordSink(SinkDiscord, url, tmpl)); err != nil {
+			return nil, err
+		}
+	}
+
+	if url := os.Getenv("NOTIFY_SLACK_WEBHOOK_URL"); url != "" {
+		if err := add(NewSlackSink(SinkSlack, url, tmpl)); err != nil {
+			return nil, err
+		}
+	}
+
+	if token := os.Getenv("NOTIFY_TELEGRAM_TOKEN"); token != "" {
+		if err := add(NewTelegramSink(SinkTelegram, os.Getenv("NOTIFY_TELEGRAM_API_URL"),
+			token, os.Getenv("NOTIFY_TELEGRAM_CHAT_ID"), tmpl)); err != nil {
+			return nil, err
+		}
+	}
+
+	if addr := os.Getenv("NOTIFY_SMTP_ADDR"); addr != "" {
+		to := make([]string, 0)
+		for _, recipient := range strings.Split(os.Getenv("NOTIFY_SMTP_TO"), ",") {
+			if recipient = strings.TrimSpace(recipient); recipient != "" {
+				to = append(to, recipient)
+			}
+		}
+		if err := add(NewSmtpSink(SinkSmtp, addr, os.Getenv("NOTIFY_SMTP_USERNAME"),
+			os.Getenv("NOTIFY_SMTP_PASSWORD"), os.Getenv("NOTIFY_SMTP_FROM"), to, tmpl)); err != nil {
+			return nil, err
+		}
+	}
+	return notifier, nil
+}
@@ -0,0 +1,340 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/metrics"
+	"github.com/davidleitw/baha/internal/textutil"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	EventNewPost    = "new_post"
+	EventUpdateLast = "update_last"
+	EventMatch      = "match"
+	EventNewReply   = "new_reply"
+	EventNewThread  = "new_thread"
+
+	DefaultRetry        = 3
+	DefaultRetryBackoff = 2 * time.Second
+	DefaultSendTimeout  = 10 * time.Second
+	DefaultQueueSize    = 64
+
+	excerptLength = 200
+	// diffContext is how much unchanged text is kept around every change
+	// of an edit
+	diffContext = 20
+)
+
+var ErrNotifierClosed = errors.New("notifier is closed")
+
+// DefaultTemplate renders a Message as plain text, every sink uses it
+// unless given its own.
+const DefaultTemplate = `
+{{- if eq .Event "update_last"}}✏️ {{.AuthorName}} ({{.AuthorId}}) 編輯了 B{{.FloorIndex}}
+{{- else if eq .Event "new_reply"}}💬 {{.AuthorName}} ({{.AuthorId}}) 在 B{{.FloorIndex}} 留言
+{{- else if eq .Event "new_thread"}}📋 {{.AuthorId}} 開了新串
+{{- else if eq .Event "match"}}🔍 {{.AuthorName}} ({{.AuthorId}}) 在 B{{.FloorIndex}}{{if .IsReply}} 的留言{{end}}提到 {{range $i, $term := .Matches}}{{if $i}}、{{end}}{{$term}}{{end}}
+{{- else}}🆕 {{.AuthorName}} ({{.AuthorId}}) 發表了 B{{.FloorIndex}}
+{{- end}}
+{{if .Diff}}{{.Diff}}{{else}}{{.Excerpt}}{{end}}
+{{.Url}}`
+
+// Message is what every sink renders, built from a floor seen by a rule.
+type Message struct {
+	Event   string    `json:"event"`
+	RuleKey string    `json:"rule_key"`
+	Time    time.Time `json:"time"`
+
+	Bsn        int    `json:"bsn"`
+	Sna        int    `json:"sna"`
+	FloorIndex int    `json:"floor_index"`
+	AuthorName string `json:"author_name"`
+	AuthorId   string `json:"author_id"`
+	Excerpt    string `json:"excerpt"`
+	Url        string `json:"url"`
+
+	// Matches is only set for EventMatch, Excerpt then has the matched
+	// terms highlighted. IsReply tells a reply from a floor, the author and
+	// excerpt are then of the reply.
+	Matches []string `json:"matches,omitempty"`
+	IsReply bool     `json:"is_reply,omitempty"`
+
+	// Title is only set for EventNewThread, which has no floor.
+	Title string `json:"title,omitempty"`
+
+	// Diff is only set for EventUpdateLast, the changes of the edit as
+	// [-deleted-]{+inserted+} with some unchanged text around them.
+	Diff string `json:"diff,omitempty"`
+}
+
+func NewFloorMessage(event, ruleKey string, bsn, sna int, floor *db.FloorRecord, url string) *Message {
+	return &Message{
+		Event:      event,
+		RuleKey:    ruleKey,
+		Time:       time.Now(),
+		Bsn:        bsn,
+		Sna:        sna,
+		FloorIndex: floor.FloorIndex,
+		AuthorName: floor.AuthorName,
+		AuthorId:   floor.AuthorId,
+		Excerpt:    textutil.Excerpt(textutil.PlainText(floor.Content), excerptLength),
+		Url:        url,
+	}
+}
+
+// NewEditMessage is an EventUpdateLast showing what the edit changed.
+func NewEditMessage(ruleKey string, bsn, sna int, floor *db.FloorRecord, diff []textutil.DiffChunk, url string) *Message {
+	message := NewFloorMessage(EventUpdateLast, ruleKey, bsn, sna, floor, url)
+	message.Diff = textutil.Excerpt(textutil.FormatDiff(diff, diffContext), excerptLength)
+	return message
+}
+
+func NewThreadMessage(ruleKey string, thread *db.ThreadRecord, url string) *Message {
+	excerpt := thread.Title
+	if thread.Category != "" {
+		excerpt = fmt.Sprintf("[%s] %s", thread.Category, thread.Title)
+	}
+	return &Message{
+		Event:    EventNewThread,
+		RuleKey:  ruleKey,
+		Time:     time.Now(),
+		Bsn:      thread.Bsn,
+		Sna:      thread.Sna,
+		AuthorId: thread.AuthorId,
+		Excerpt:  excerpt,
+		Url:      url,
+		Title:    thread.Title,
+	}
+}
+
+func NewReplyMessage(ruleKey string, bsn, sna int, floor *db.FloorRecord, reply *db.ReplyRecord, url string) *Message {
+	message := NewFloorMessage(EventNewReply, ruleKey, bsn, sna, floor, url)
+	message.AuthorName = reply.AuthorName
+	message.AuthorId = reply.AuthorId
+	message.Excerpt = textutil.Excerpt(textutil.PlainText(reply.Content), excerptLength)
+	message.IsReply = true
+	return message
+}
+
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, message *Message) error
+}
+
+// render is shared by every sink with a text body.
+func render(tmpl *template.Template, message *Message) (string, error) {
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, message); err != nil {
+		logrus.WithError(err).Error("tmpl.Execute failed")
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		logrus.WithError(err).Errorf("template of %s is invalid", name)
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+type NotifierOption func(*Notifier)
+
+// Retry sends a message up to attempts times, waiting backoff, 2*backoff,
+// ... between attempts.
+func Retry(attempts int, backoff time.Duration) NotifierOption {
+	return func(o *Notifier) {
+		o.attempts = attempts
+		o.backoff = backoff
+	}
+}
+
+func SendTimeout(timeout time.Duration) NotifierOption {
+	return func(o *Notifier) {
+		o.timeout = timeout
+	}
+}
+
+// QueueSize is how many messages may wait for a sink, the oldest waiting
+// message is dropped when a new one does not fit.
+func QueueSize(size int) NotifierOption {
+	return func(o *Notifier) {
+		o.queueSize = size
+	}
+}
+
+// sinkQueue holds the messages waiting for a sink, a worker per sink sends
+// them so a slow or failing sink neither blocks the caller nor the others.
+type sinkQueue struct {
+	sink      Sink
+	messageCh chan *Message
+}
+
+// Notifier routes messages of a rule to the sinks named by that rule.
+type Notifier struct {
+	mu     sync.RWMutex
+	sinks  map[string]*sinkQueue
+	closed bool
+	wg     sync.WaitGroup
+
+	attempts  int
+	backoff   time.Duration
+	timeout   time.Duration
+	queueSize int
+}
+
+func NewNotifier(opts ...NotifierOption) *Notifier {
+	notifier := &Notifier{
+		sinks:     make(map[string]*sinkQueue),
+		attempts:  DefaultRetry,
+		backoff:   DefaultRetryBackoff,
+		timeout:   DefaultSendTimeout,
+		queueSize: DefaultQueueSize,
+	}
+	for _, opt := range opts {
+		opt(notifier)
+	}
+	return notifier
+}
+
+// AddSink starts the worker of sink, a sink added under the name of another
+// replaces it once the messages already waiting for the old one are sent.
+func (notifier *Notifier) AddSink(sink Sink) {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	if notifier.closed {
+		logrus.Warnf("notifier is closed, sink %s is not added", sink.Name())
+		return
+	}
+
+	if old, exist := notifier.sinks[sink.Name()]; exist {
+		close(old.messageCh)
+	}
+	queue := &sinkQueue{sink: sink, messageCh: make(chan *Message, notifier.queueSize)}
+	notifier.sinks[sink.Name()] = queue
+
+	notifier.wg.Add(1)
+	go notifier.work(queue)
+}
+
+func (notifier *Notifier) SinkNames() []string {
+	notifier.mu.RLock()
+	defer notifier.mu.RUnlock()
+
+	names := make([]string, 0, len(notifier.sinks))
+	for name := range notifier.sinks {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (notifier *Notifier) work(queue *sinkQueue) {
+	defer notifier.wg.Done()
+	for message := range queue.messageCh {
+		if err := notifier.send(queue.sink, message); err != nil {
+			logrus.WithError(err).Errorf("Notify %s of %s failed", queue.sink.Name(), message.Event)
+		}
+	}
+}
+
+func (notifier *Notifier) send(sink Sink, message *Message) error {
+	var err error
+	backoff := notifier.backoff
+	for attempt := 1; attempt <= notifier.attempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), notifier.timeout)
+		err = sink.Send(ctx, message)
+		cancel()
+		if err == nil {
+			metrics.NotificationsSent.WithLabelValues(sink.Name(), "success").Inc()
+			return nil
+		}
+
+		logrus.WithError(err).Warnf("Send to %s failed (%d/%d)", sink.Name(), attempt, notifier.attempts)
+		if attempt < notifier.attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	metrics.NotificationsSent.WithLabelValues(sink.Name(), "failure").Inc()
+	return err
+}
+
+// enqueue never blocks, when the queue of the sink is full the oldest
+// waiting message is dropped for message. Must be called with mu held.
+func (queue *sinkQueue) enqueue(message *Message) {
+	for {
+		select {
+		case queue.messageCh <- message:
+			return
+		default:
+		}
+		select {
+		case oldest := <-queue.messageCh:
+			logrus.Warnf("Queue of %s is full, %s of B%d dropped", queue.sink.Name(), oldest.Event, oldest.FloorIndex)
+			metrics.NotificationsDropped.WithLabelValues(queue.sink.Name()).Inc()
+		default:
+		}
+	}
+}
+
+// Notify queues message for the sinks in names, or for every sink when
+// names is empty, and returns without waiting for it to be sent. The only
+// error is a name without a sink, failed sends are retried and logged by
+// the worker of the sink.
+func (notifier *Notifier) Notify(names []string, message *Message) error {
+	notifier.mu.RLock()
+	defer notifier.mu.RUnlock()
+	if notifier.closed {
+		return ErrNotifierClosed
+	}
+
+	queues := make([]*sinkQueue, 0)
+	if len(names) == 0 {
+		for _, queue := range notifier.sinks {
+			queues = append(queues, queue)
+		}
+	}
+	for _, name := range names {
+		queue, exist := notifier.sinks[name]
+		if !exist {
+			logrus.Errorf("sink %s is not configured", name)
+			return fmt.Errorf("sink %s is not configured", name)
+		}
+		queues = append(queues, queue)
+	}
+
+	for _, queue := range queues {
+		queue.enqueue(message)
+	}
+	return nil
+}
+
+// Close stops accepting messages and waits for the ones already queued to
+// be sent.
+func (notifier *Notifier) Close() {
+	notifier.mu.Lock()
+	if notifier.closed {
+		notifier.mu.Unlock()
+		return
+	}
+	notifier.closed = true
+	for _, queue := range notifier.sinks {
+		close(queue.messageCh)
+	}
+	notifier.mu.Unlock()
+
+	notifier.wg.Wait()
+}
@@ -0,0 +1,336 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+)
+
+func testMessage() *Message {
+	floor := &db.FloorRecord{
+		FloorIndex: 12,
+		AuthorName: "巴哈",
+		AuthorId:   "baha",
+		Content:    "<div>今天<b>更新</b>了</div>",
+	}
+	return NewFloorMessage(EventNewPost, "author:baha", 60076, 123, floor, "https://forum.gamer.com.tw/C.php?bsn=60076&snA=123")
+}
+
+// recordServer answers every request with status and keeps the path and
+// decoded JSON body of each.
+type recordServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	status int
+	paths  []string
+	bodies []map[string]interface{}
+}
+
+func newRecordServer(t *testing.T, status int) *recordServer {
+	server := &recordServer{status: status}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		server.mu.Lock()
+		server.paths = append(server.paths, r.URL.Path)
+		server.bodies = append(server.bodies, body)
+		server.mu.Unlock()
+		w.WriteHeader(server.status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *recordServer) last(t *testing.T) (string, map[string]interface{}) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.bodies) == 0 {
+		t.Fatal("no request received")
+	}
+	return server.paths[len(server.paths)-1], server.bodies[len(server.bodies)-1]
+}
+
+func TestWebhookSink(t *testing.T) {
+	server := newRecordServer(t, http.StatusOK)
+	sink, err := NewWebhookSink("hook", server.URL+"/hook", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	path, body := server.last(t)
+	if path != "/hook" {
+		t.Errorf("path = %s", path)
+	}
+	if body["event"] != EventNewPost || body["author_id"] != "baha" || body["floor_index"] != float64(12) {
+		t.Errorf("fields of message missing: %v", body)
+	}
+	if text, _ := body["text"].(string); !strings.Contains(text, "B12") || !strings.Contains(text, "今天更新了") {
+		t.Errorf("text = %q", body["text"])
+	}
+}
+
+func TestDiscordSinkTruncates(t *testing.T) {
+	server := newRecordServer(t, http.StatusNoContent)
+	sink, err := NewDiscordSink("discord", server.URL, "{{.Excerpt}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := testMessage()
+	message.Excerpt = strings.Repeat("字", 3000)
+	if err := sink.Send(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	_, body := server.last(t)
+	content, _ := body["content"].(string)
+	if n := len([]rune(content)); n != 2000 {
+		t.Errorf("content has %d runes, want 2000", n)
+	}
+}
+
+func TestSlackSink(t *testing.T) {
+	server := newRecordServer(t, http.StatusOK)
+	sink, err := NewSlackSink("slack", server.URL, "{{.AuthorName}}: {{.Excerpt}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, body := server.last(t); body["text"] != "巴哈: 今天更新了" {
+		t.Errorf("text = %q", body["text"])
+	}
+}
+
+func TestTelegramSink(t *testing.T) {
+	server := newRecordServer(t, http.StatusOK)
+	sink, err := NewTelegramSink("telegram", server.URL, "TOKEN", "42", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	path, body := server.last(t)
+	if path != "/botTOKEN/sendMessage" {
+		t.Errorf("path = %s", path)
+	}
+	if body["chat_id"] != "42" || body["disable_web_page_preview"] != true {
+		t.Errorf("body = %v", body)
+	}
+}
+
+func TestSinkErrorStatus(t *testing.T) {
+	server := newRecordServer(t, http.StatusInternalServerError)
+	sink, err := NewSlackSink("slack", server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testMessage()); err == nil {
+		t.Error("expect error for 500")
+	}
+}
+
+type smtpMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSmtp accepts a single mail without any extension and passes on the
+// envelope and data it received.
+func fakeSmtp(t *testing.T) (string, <-chan *smtpMail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	mailCh := make(chan *smtpMail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		mail := &smtpMail{}
+		reply("220 fake ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 fake")
+			case strings.HasPrefix(command, "MAIL FROM:"):
+				mail.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(command, "RCPT TO:"):
+				mail.to = append(mail.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				mail.data = data.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 bye")
+				mailCh <- mail
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), mailCh
+}
+
+func TestSmtpSink(t *testing.T) {
+	addr, mailCh := fakeSmtp(t)
+	sink, err := NewSmtpSink("mail", addr, "", "", "bot@example.com", []string{"a@example.com", "b@example.com"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sink.Send(ctx, testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case mail := <-mailCh:
+		if mail.from != "bot@example.com" || len(mail.to) != 2 {
+			t.Errorf("envelope = %s %v", mail.from, mail.to)
+		}
+		if !strings.Contains(mail.data, "Subject: =?utf-8?q?") || !strings.Contains(mail.data, "B12") {
+			t.Errorf("data = %q", mail.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+}
+
+// blockSink blocks every send until release is closed and counts the
+// messages it got.
+type blockSink struct {
+	name    string
+	release chan struct{}
+
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func (sink *blockSink) Name() string {
+	return sink.name
+}
+
+func (sink *blockSink) Send(ctx context.Context, message *Message) error {
+	<-sink.release
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.messages = append(sink.messages, message)
+	return nil
+}
+
+func TestNotifyDoesNotBlock(t *testing.T) {
+	notifier := NewNotifier(QueueSize(2))
+	sink := &blockSink{name: "slow", release: make(chan struct{})}
+	notifier.AddSink(sink)
+
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		message := testMessage()
+		message.FloorIndex = i
+		if err := notifier.Notify([]string{"slow"}, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Notify blocked for %s", elapsed)
+	}
+
+	close(sink.release)
+	notifier.Close()
+
+	// the worker holds one message, the queue keeps the newest two
+	if n := len(sink.messages); n < 2 || n > 3 {
+		t.Fatalf("sent %d messages", n)
+	}
+	if last := sink.messages[len(sink.messages)-1]; last.FloorIndex != 5 {
+		t.Errorf("newest message dropped, last sent B%d", last.FloorIndex)
+	}
+}
+
+type failSink struct {
+	attempts int
+}
+
+func (sink *failSink) Name() string {
+	return "fail"
+}
+
+func (sink *failSink) Send(ctx context.Context, message *Message) error {
+	sink.attempts++
+	return errors.New("unavailable")
+}
+
+func TestNotifyRetriesInWorker(t *testing.T) {
+	notifier := NewNotifier(Retry(3, time.Millisecond))
+	sink := &failSink{}
+	notifier.AddSink(sink)
+
+	if err := notifier.Notify(nil, testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	notifier.Close()
+	if sink.attempts != 3 {
+		t.Errorf("attempts = %d", sink.attempts)
+	}
+
+	if err := notifier.Notify(nil, testMessage()); !errors.Is(err, ErrNotifierClosed) {
+		t.Errorf("Notify after Close = %v", err)
+	}
+}
+
+func TestNotifyUnknownSink(t *testing.T) {
+	notifier := NewNotifier()
+	defer notifier.Close()
+	if err := notifier.Notify([]string{"missing"}, testMessage()); err == nil {
+		t.Error("expect error for unknown sink")
+	}
+}
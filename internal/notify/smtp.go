@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultSubjectTemplate = `[BahaMaster] {{.AuthorName}} B{{.FloorIndex}}`
+
+type smtpSink struct {
+	name     string
+	addr     string
+	username string
+	password string
+	from     string
+	to       []string
+
+	subject *template.Template
+	tmpl    *template.Template
+}
+
+var _ Sink = (*smtpSink)(nil)
+
+// NewSmtpSink mails every message to the recipients in to. STARTTLS and
+// authentication are used when the server offers them, so a plain local
+// stand-in server works as well.
+func NewSmtpSink(name, addr, username, password, from string, to []string, tmpl string) (Sink, error) {
+	parsed, err := parseTemplate(name, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	subject, err := template.New(name + "-subject").Parse(DefaultSubjectTemplate)
+	if err != nil {
+		logrus.WithError(err).Error("subject template is invalid")
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("sink %s has no recipients", name)
+	}
+
+	return &smtpSink{
+		name:     name,
+		addr:     addr,
+		username: username,
+		password: password,
+		from:     from,
+		to:       to,
+		subject:  subject,
+		tmpl:     parsed,
+	}, nil
+}
+
+func (sink *smtpSink) Name() string {
+	return sink.name
+}
+
+func (sink *smtpSink) buildMail(message *Message) ([]byte, error) {
+	subject, err := render(sink.subject, message)
+	if err != nil {
+		return nil, err
+	}
+	body, err := render(sink.tmpl, message)
+	if err != nil {
+		return nil, err
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("From: %s\r\n", sink.from))
+	builder.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(sink.to, ", ")))
+	builder.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject)))
+	builder.WriteString(fmt.Sprintf("Date: %s\r\n", message.Time.Format(time.RFC1123Z)))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(builder.String()), nil
+}
+
+func (sink *smtpSink) Send(ctx context.Context, message *Message) error {
+	mail, err := sink.buildMail(message)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", sink.addr)
+	if err != nil {
+		logrus.WithError(err).Errorf("dial %s failed", sink.addr)
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, _ := net.SplitHostPort(sink.addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		logrus.WithError(err).Error("smtp.NewClient failed")
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			logrus.WithError(err).Error("client.StartTLS failed")
+			return err
+		}
+	}
+
+	if ok, _ := client.Extension("AUTH"); ok && sink.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", sink.username, sink.password, host)); err != nil {
+			logrus.WithError(err).Error("client.Auth failed")
+			return err
+		}
+	}
+
+	if err := client.Mail(sink.from); err != nil {
+		logrus.WithError(err).Error("client.Mail failed")
+		return err
+	}
+	for _, to := range sink.to {
+		if err := client.Rcpt(to); err != nil {
+			logrus.WithError(err).Errorf("client.Rcpt %s failed", to)
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		logrus.WithError(err).Error("client.Data failed")
+		return err
+	}
+	if _, err := writer.Write(mail); err != nil {
+		logrus.WithError(err).Error("writer.Write failed")
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		logrus.WithError(err).Error("writer.Close failed")
+		return err
+	}
+	return client.Quit()
+}
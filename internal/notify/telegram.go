@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const DefaultTelegramApiUrl = "https://api.telegram.org"
+
+type telegramSink struct {
+	name   string
+	apiUrl string
+	token  string
+	chatId string
+	tmpl   *template.Template
+
+	client *resty.Client
+}
+
+var _ Sink = (*telegramSink)(nil)
+
+// NewTelegramSink sends through the Bot API, apiUrl may point to a local
+// stand-in server and defaults to DefaultTelegramApiUrl.
+func NewTelegramSink(name, apiUrl, token, chatId, tmpl string) (Sink, error) {
+	parsed, err := parseTemplate(name, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	if apiUrl == "" {
+		apiUrl = DefaultTelegramApiUrl
+	}
+
+	return &telegramSink{
+		name:   name,
+		apiUrl: strings.TrimSuffix(apiUrl, "/"),
+		token:  token,
+		chatId: chatId,
+		tmpl:   parsed,
+		client: resty.New(),
+	}, nil
+}
+
+func (sink *telegramSink) Name() string {
+	return sink.name
+}
+
+func (sink *telegramSink) Send(ctx context.Context, message *Message) error {
+	text, err := render(sink.tmpl, message)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", sink.apiUrl, sink.token)
+	return postJSON(ctx, sink.client, sink.name, url, map[string]interface{}{
+		"chat_id":                  sink.chatId,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"text/template"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// postJSON is shared by every sink talking to an HTTP endpoint. Webhook
+// URLs and bot tokens are secrets, so only the sink name is logged.
+func postJSON(ctx context.Context, client *resty.Client, name, url string, body interface{}) error {
+	res, err := client.R().SetContext(ctx).SetBody(body).Post(url)
+	if err != nil {
+		// *url.Error repeats the URL, keep the cause only
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		logrus.WithError(err).Errorf("POST to sink %s failed", name)
+		return fmt.Errorf("POST to sink %s failed: %w", name, err)
+	}
+	if res.IsError() {
+		logrus.Errorf("POST to sink %s returned %s", name, res.Status())
+		return fmt.Errorf("POST to sink %s returned %s", name, res.Status())
+	}
+	return nil
+}
+
+type webhookSink struct {
+	name string
+	url  string
+	tmpl *template.Template
+
+	client *resty.Client
+}
+
+var _ Sink = (*webhookSink)(nil)
+
+// NewWebhookSink posts the message as JSON with the rendered template in
+// "text", for any service which wants the raw fields.
+func NewWebhookSink(name, url, tmpl string) (Sink, error) {
+	parsed, err := parseTemplate(name, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookSink{name: name, url: url, tmpl: parsed, client: resty.New()}, nil
+}
+
+func (sink *webhookSink) Name() string {
+	return sink.name
+}
+
+func (sink *webhookSink) Send(ctx context.Context, message *Message) error {
+	text, err := render(sink.tmpl, message)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, sink.client, sink.name, sink.url, struct {
+		*Message
+		Text string `json:"text"`
+	}{Message: message, Text: text})
+}
+
+type discordSink struct {
+	name string
+	url  string
+	tmpl *template.Template
+
+	client *resty.Client
+}
+
+var _ Sink = (*discordSink)(nil)
+
+// NewDiscordSink posts to a Discord channel webhook.
+func NewDiscordSink(name, url, tmpl string) (Sink, error) {
+	parsed, err := parseTemplate(name, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return &discordSink{name: name, url: url, tmpl: parsed, client: resty.New()}, nil
+}
+
+func (sink *discordSink) Name() string {
+	return sink.name
+}
+
+func (sink *discordSink) Send(ctx context.Context, message *Message) error {
+	text, err := render(sink.tmpl, message)
+	if err != nil {
+		return err
+	}
+
+	// Discord rejects content longer than 2000 characters
+	runes := []rune(text)
+	if len(runes) > 2000 {
+		text = string(runes[:1999]) + "…"
+	}
+	return postJSON(ctx, sink.client, sink.name, sink.url, map[string]string{"content": text})
+}
+
+type slackSink struct {
+	name string
+	url  string
+	tmpl *template.Template
+
+	client *resty.Client
+}
+
+var _ Sink = (*slackSink)(nil)
+
+// NewSlackSink posts to a Slack incoming webhook.
+func NewSlackSink(name, url, tmpl string) (Sink, error) {
+	parsed, err := parseTemplate(name, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return &slackSink{name: name, url: url, tmpl: parsed, client: resty.New()}, nil
+}
+
+func (sink *slackSink) Name() string {
+	return sink.name
+}
+
+func (sink *slackSink) Send(ctx context.Context, message *Message) error {
+	text, err := render(sink.tmpl, message)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, sink.client, sink.name, sink.url, map[string]string{"text": text})
+}
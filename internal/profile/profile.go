@@ -0,0 +1,254 @@
+package profile
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/llm"
+	"github.com/davidleitw/baha/internal/textutil"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultMaxChunkTokens = 3000
+
+	mapSystemPrompt = `You read posts written by one user of a Bahamut forum thread and note what they reveal about the user:
+occupation, school, location, hobbies, habits, opinions and notable events.
+Every post is prefixed by its citation like [B12] (floor 12) or [B12-3] (reply 3 under floor 12).
+Write concise bullet points in Traditional Chinese and end every bullet with the citations supporting it.
+Do not invent facts and do not cite anything that is not in the posts.`
+
+	reduceSystemPrompt = `You merge partial profile notes about one user of a Bahamut forum thread into a single profile.
+Remove duplicates, resolve contradictions by preferring later floors, group bullets by topic,
+and keep every citation like [B12] or [B12-3] that supports a kept bullet. Write in Traditional Chinese.`
+)
+
+var citationRegexp = regexp.MustCompile(`\[B(\d+)(?:-(\d+))?\]`)
+
+type Option func(*Summarizer)
+
+// MaxChunkTokens bounds the size of the posts sent in a single call.
+func MaxChunkTokens(tokens int) Option {
+	return func(o *Summarizer) {
+		o.maxChunkTokens = tokens
+	}
+}
+
+// Summarizer builds a profile of one author in a building through a
+// map-reduce over every floor and reply they wrote.
+type Summarizer struct {
+	provider llm.Provider
+	db       db.BuildingDB
+
+	maxChunkTokens int
+}
+
+func NewSummarizer(provider llm.Provider, buildingDb db.BuildingDB, opts ...Option) *Summarizer {
+	summarizer := &Summarizer{
+		provider:       provider,
+		db:             buildingDb,
+		maxChunkTokens: DefaultMaxChunkTokens,
+	}
+	for _, opt := range opts {
+		opt(summarizer)
+	}
+	return summarizer
+}
+
+type post struct {
+	floorIndex int
+	replyIndex int
+	text       string
+
+	// edited is set for a post the previous profile summarized already,
+	// it is summarized again but not counted again
+	edited bool
+}
+
+func (p *post) String() string {
+	if p.replyIndex < 0 {
+		return fmt.Sprintf("[B%d] %s", p.floorIndex, p.text)
+	}
+	return fmt.Sprintf("[B%d-%d] %s", p.floorIndex, p.replyIndex, p.text)
+}
+
+// collectPosts returns the posts of authorId the previous profile has not
+// summarized, every post without one. Besides the floors after the last
+// summarized one, these are the replies left under earlier floors and the
+// floors and replies edited since the previous profile read its posts.
+func (s *Summarizer) collectPosts(bid, authorId string, previous *db.ProfileRecord) ([]*post, error) {
+	afterFloorIndex := 0
+	var changedSince time.Time
+	if previous != nil {
+		afterFloorIndex = previous.LastFloorIndex
+		// profiles saved before SyncedAt was kept
+		changedSince = previous.SyncedAt
+		if changedSince.IsZero() {
+			changedSince = previous.UpdatedAt
+		}
+	}
+
+	floors, err := s.db.GetFloorRecordsByAuthor(bid, authorId, afterFloorIndex, changedSince)
+	if err != nil {
+		logrus.WithError(err).Error("db.GetFloorRecordsByAuthor failed")
+		return nil, err
+	}
+
+	replies, err := s.db.GetReplyRecordsByAuthor(bid, authorId, afterFloorIndex, changedSince)
+	if err != nil {
+		logrus.WithError(err).Error("db.GetReplyRecordsByAuthor failed")
+		return nil, err
+	}
+
+	posts := make([]*post, 0, len(floors)+len(replies))
+	for _, floor := range floors {
+		if text := textutil.PlainText(floor.Content); text != "" {
+			posts = append(posts, &post{
+				floorIndex: floor.FloorIndex,
+				replyIndex: -1,
+				text:       text,
+				edited:     floor.FloorIndex <= afterFloorIndex,
+			})
+		}
+	}
+	for _, reply := range replies {
+		if text := strings.TrimSpace(reply.Content); text != "" {
+			posts = append(posts, &post{
+				floorIndex: reply.FloorIndex,
+				replyIndex: reply.ReplyIndex,
+				text:       text,
+				edited:     reply.FloorIndex <= afterFloorIndex && reply.ObservedAt.Before(changedSince),
+			})
+		}
+	}
+
+	sort.SliceStable(posts, func(i, j int) bool {
+		if posts[i].floorIndex != posts[j].floorIndex {
+			return posts[i].floorIndex < posts[j].floorIndex
+		}
+		return posts[i].replyIndex < posts[j].replyIndex
+	})
+	return posts, nil
+}
+
+func extractCitations(summary string) []*db.Citation {
+	seen := make(map[db.Citation]bool)
+	citations := make([]*db.Citation, 0)
+	for _, match := range citationRegexp.FindAllStringSubmatch(summary, -1) {
+		citation := db.Citation{ReplyIndex: -1}
+		citation.FloorIndex, _ = strconv.Atoi(match[1])
+		if match[2] != "" {
+			citation.ReplyIndex, _ = strconv.Atoi(match[2])
+		}
+		if !seen[citation] {
+			seen[citation] = true
+			citations = append(citations, &citation)
+		}
+	}
+	return citations
+}
+
+// Summarize returns the profile of authorId in building. An existing profile
+// is extended with the posts it has not summarized only, unless full is set.
+func (s *Summarizer) Summarize(ctx context.Context, building *db.BuildingRecord, authorId string, full bool) (*db.ProfileRecord, error) {
+	ctx = llm.WithUsageTags(ctx, building.Id, "profile:"+authorId)
+
+	var previous *db.ProfileRecord
+	if !full {
+		record, err := s.db.GetProfileRecord(building.Id, authorId)
+		if err != nil && err != sql.ErrNoRows {
+			logrus.WithError(err).Error("db.GetProfileRecord failed")
+			return nil, err
+		}
+		previous = record
+	}
+
+	syncedAt := time.Now()
+	posts, err := s.collectPosts(building.Id, authorId, previous)
+	if err != nil {
+		logrus.WithError(err).Error("collectPosts failed")
+		return nil, err
+	}
+
+	if len(posts) == 0 {
+		if previous != nil {
+			logrus.Infof("Profile of %s is up to date at floor %d", authorId, previous.LastFloorIndex)
+			return previous, nil
+		}
+		return nil, fmt.Errorf("no posts by %s in building %s", authorId, building.Id)
+	}
+
+	texts := make([]string, 0, len(posts))
+	for _, p := range posts {
+		texts = append(texts, textutil.Excerpt(p.String(), s.maxChunkTokens))
+	}
+
+	// Map: every chunk of posts becomes partial notes
+	partials := make([]string, 0)
+	chunks := llm.ChunkTexts(texts, s.maxChunkTokens, 1)
+	for i, chunk := range chunks {
+		logrus.Infof("Summarizing chunk %d/%d of %s", i+1, len(chunks), authorId)
+		partial, err := llm.CompleteText(ctx, s.provider, mapSystemPrompt, fmt.Sprintf("User: %s\nPosts:\n%s", authorId, chunk))
+		if err != nil {
+			return nil, err
+		}
+		partials = append(partials, partial)
+	}
+
+	if previous != nil {
+		partials = append([]string{previous.Summary}, partials...)
+	}
+
+	// Reduce: merge notes until a single profile is left
+	for len(partials) > 1 {
+		merged := make([]string, 0)
+		for _, group := range llm.ChunkTexts(partials, s.maxChunkTokens, 2) {
+			summary, err := llm.CompleteText(ctx, s.provider, reduceSystemPrompt, fmt.Sprintf("User: %s\nNotes:\n%s", authorId, group))
+			if err != nil {
+				return nil, err
+			}
+			merged = append(merged, summary)
+		}
+		partials = merged
+	}
+
+	newPosts := 0
+	for _, p := range posts {
+		if !p.edited {
+			newPosts++
+		}
+	}
+
+	record := &db.ProfileRecord{
+		Bid:             building.Id,
+		AuthorId:        authorId,
+		Summary:         partials[0],
+		Citations:       extractCitations(partials[0]),
+		FirstFloorIndex: posts[0].floorIndex,
+		LastFloorIndex:  posts[len(posts)-1].floorIndex,
+		PostCount:       newPosts,
+		UpdatedAt:       time.Now(),
+		SyncedAt:        syncedAt,
+	}
+	if previous != nil {
+		record.FirstFloorIndex = previous.FirstFloorIndex
+		if previous.LastFloorIndex > record.LastFloorIndex {
+			// only earlier posts were left or edited
+			record.LastFloorIndex = previous.LastFloorIndex
+		}
+		record.PostCount += previous.PostCount
+	}
+
+	if err := s.db.SaveProfileRecord(record); err != nil {
+		logrus.WithError(err).Error("db.SaveProfileRecord failed")
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,128 @@
+package profile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/llm"
+)
+
+// countProvider counts the chats of every system prompt.
+type countProvider struct {
+	llm.Provider
+	calls map[string]int
+}
+
+func (provider *countProvider) Chat(ctx context.Context, request *llm.ChatRequest) (*llm.Completion, error) {
+	provider.calls[request.Messages[0].Content]++
+	return provider.Provider.Chat(ctx, request)
+}
+
+// openTestDb opens a new building.db in a temporary directory, the path of
+// the db is relative to the working directory.
+func openTestDb(t *testing.T) db.BuildingDB {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return buildingDb
+}
+
+func syncTestFloor(t *testing.T, buildingDb db.BuildingDB, page *db.PageRecord, floorIndex int, authorId string) {
+	floor := &db.FloorRecord{
+		Bid:        page.Bid,
+		Pid:        page.Pid,
+		FloorIndex: floorIndex,
+		AuthorName: authorId,
+		AuthorId:   authorId,
+		Content:    fmt.Sprintf("post of floor %d", floorIndex),
+	}
+	if _, err := buildingDb.SyncFloorRecord(floor, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSummarizeMapReduce(t *testing.T) {
+	buildingDb := openTestDb(t)
+	page, err := buildingDb.SyncPageRecord(60076, 123, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncTestFloor(t, buildingDb, page, 1, "baha")
+	syncTestFloor(t, buildingDb, page, 2, "someone")
+	syncTestFloor(t, buildingDb, page, 3, "baha")
+	syncTestFloor(t, buildingDb, page, 4, "baha")
+	building, err := buildingDb.GetBuildingRecord(60076, 123)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &countProvider{
+		Provider: llm.NewFakeProvider(
+			&llm.FakeResponse{Match: `Posts:`, Content: "- plays games [B1]", Repeat: true},
+			&llm.FakeResponse{Match: `Notes:`, Content: "- plays games [B1] [B3-2]", Repeat: true},
+		),
+		calls: make(map[string]int),
+	}
+
+	// every post is a chunk of its own, notes are merged two at a time
+	summarizer := NewSummarizer(provider, buildingDb, MaxChunkTokens(20))
+	record, err := summarizer.Summarize(context.Background(), building, "baha", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls[mapSystemPrompt] != 3 || provider.calls[reduceSystemPrompt] != 3 {
+		t.Errorf("calls = %d map, %d reduce", provider.calls[mapSystemPrompt], provider.calls[reduceSystemPrompt])
+	}
+	if record.PostCount != 3 || record.FirstFloorIndex != 1 || record.LastFloorIndex != 4 {
+		t.Errorf("record = %+v", record)
+	}
+	if len(record.Citations) != 2 || record.Citations[1].FloorIndex != 3 || record.Citations[1].ReplyIndex != 2 {
+		t.Errorf("citations = %+v", record.Citations)
+	}
+
+	// the next update summarizes the new floor only and merges it into the
+	// saved profile
+	provider.calls = make(map[string]int)
+	syncTestFloor(t, buildingDb, page, 5, "baha")
+	record, err = summarizer.Summarize(context.Background(), building, "baha", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls[mapSystemPrompt] != 1 || provider.calls[reduceSystemPrompt] != 1 {
+		t.Errorf("incremental calls = %d map, %d reduce", provider.calls[mapSystemPrompt], provider.calls[reduceSystemPrompt])
+	}
+	if record.PostCount != 4 || record.FirstFloorIndex != 1 || record.LastFloorIndex != 5 {
+		t.Errorf("incremental record = %+v", record)
+	}
+
+	saved, err := buildingDb.GetProfileRecord(building.Id, "baha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Summary != record.Summary || saved.PostCount != 4 {
+		t.Errorf("saved = %+v", saved)
+	}
+
+	// nothing new is left, no call is made
+	provider.calls = make(map[string]int)
+	if _, err := summarizer.Summarize(context.Background(), building, "baha", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.calls) != 0 {
+		t.Errorf("calls for an up to date profile = %v", provider.calls)
+	}
+}
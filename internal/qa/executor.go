@@ -0,0 +1,140 @@
+package qa
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultRowLimit     = 200
+	DefaultQueryTimeout = 5 * time.Second
+)
+
+type QueryResult struct {
+	Columns   []string
+	Rows      [][]string
+	Truncated bool
+}
+
+// String renders the result as a plain text table for the terminal and for
+// the answer prompt.
+func (result *QueryResult) String() string {
+	var builder strings.Builder
+	builder.WriteString(strings.Join(result.Columns, " | "))
+	builder.WriteString("\n")
+	for _, row := range result.Rows {
+		builder.WriteString(strings.Join(row, " | "))
+		builder.WriteString("\n")
+	}
+	if result.Truncated {
+		builder.WriteString(fmt.Sprintf("... (truncated to %d rows)\n", len(result.Rows)))
+	}
+	return builder.String()
+}
+
+type ExecutorOption func(*Executor)
+
+func RowLimit(limit int) ExecutorOption {
+	return func(o *Executor) {
+		o.RowLimit = limit
+	}
+}
+
+func QueryTimeout(timeout time.Duration) ExecutorOption {
+	return func(o *Executor) {
+		o.Timeout = timeout
+	}
+}
+
+func AllowedTables(tables ...string) ExecutorOption {
+	return func(o *Executor) {
+		o.AllowedTables = tables
+	}
+}
+
+// Executor runs validated SELECT statements on a read-only connection.
+type Executor struct {
+	driver *sql.DB
+
+	RowLimit      int
+	Timeout       time.Duration
+	AllowedTables []string
+}
+
+func NewExecutor(driver *sql.DB, opts ...ExecutorOption) *Executor {
+	executor := &Executor{
+		driver:        driver,
+		RowLimit:      DefaultRowLimit,
+		Timeout:       DefaultQueryTimeout,
+		AllowedTables: DefaultAllowedTables,
+	}
+	for _, opt := range opts {
+		opt(executor)
+	}
+	return executor
+}
+
+func (executor *Executor) Execute(ctx context.Context, query string) (*QueryResult, error) {
+	query, err := ValidateSelect(query, executor.AllowedTables)
+	if err != nil {
+		logrus.WithError(err).Warn("ValidateSelect rejected query")
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, executor.Timeout)
+	defer cancel()
+
+	// Fetch one extra row to know whether the result was truncated
+	limited := fmt.Sprintf("SELECT * FROM (\n%s\n) LIMIT %d;", query, executor.RowLimit+1)
+	rows, err := executor.driver.QueryContext(ctx, limited)
+	if err != nil {
+		logrus.WithError(err).Error("driver.QueryContext failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		logrus.WithError(err).Error("rows.Columns failed")
+		return nil, err
+	}
+
+	result := &QueryResult{Columns: columns, Rows: make([][]string, 0)}
+	for rows.Next() {
+		if len(result.Rows) == executor.RowLimit {
+			result.Truncated = true
+			break
+		}
+
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			logrus.WithError(err).Error("rows.Scan failed")
+			return nil, err
+		}
+
+		row := make([]string, len(columns))
+		for i, value := range values {
+			if value.Valid {
+				row[i] = value.String
+			} else {
+				row[i] = "NULL"
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		logrus.WithError(err).Error("rows.Err")
+		return nil, err
+	}
+	return result, nil
+}
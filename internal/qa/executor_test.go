@@ -0,0 +1,107 @@
+package qa
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+)
+
+// openTestArchive archives a few floors into a new building.db in a
+// temporary directory and opens it read-only like cmd/ask does.
+func openTestArchive(t *testing.T, authorIds ...string) *sql.DB {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		t.Fatal(err)
+	}
+	page, err := buildingDb.SyncPageRecord(60076, 123, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, authorId := range authorIds {
+		floor := &db.FloorRecord{Bid: page.Bid, Pid: page.Pid, FloorIndex: i + 1, AuthorName: authorId, AuthorId: authorId, Content: "hi"}
+		if _, err := buildingDb.SyncFloorRecord(floor, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	driver, err := db.OpenReadOnly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { driver.Close() })
+	return driver
+}
+
+func TestExecuteRowLimit(t *testing.T) {
+	executor := NewExecutor(openTestArchive(t, "baha", "someone", "baha"), RowLimit(2))
+
+	result, err := executor.Execute(context.Background(), "SELECT floor_index, author_id FROM floor_record ORDER BY floor_index;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 2 || !result.Truncated {
+		t.Errorf("result = %s", result)
+	}
+	if strings.Join(result.Columns, ",") != "floor_index,author_id" || strings.Join(result.Rows[1], ",") != "2,someone" {
+		t.Errorf("result = %s", result)
+	}
+
+	result, err = executor.Execute(context.Background(), "SELECT COUNT(*) FROM floor_record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 1 || result.Truncated || result.Rows[0][0] != "3" {
+		t.Errorf("result = %s", result)
+	}
+}
+
+func TestExecuteTimeout(t *testing.T) {
+	// the counter is named by a column list, which the guard does not read
+	// as a WITH name, so it is allowed explicitly
+	executor := NewExecutor(openTestArchive(t), QueryTimeout(50*time.Millisecond), AllowedTables("counter"))
+
+	start := time.Now()
+	_, err := executor.Execute(context.Background(),
+		"WITH RECURSIVE counter(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM counter WHERE x < 1000000000) SELECT COUNT(*) FROM counter")
+	if err == nil {
+		t.Fatal("expect the query to be interrupted")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("query ran for %s", elapsed)
+	}
+}
+
+func TestExecuteRejects(t *testing.T) {
+	driver := openTestArchive(t, "baha")
+	executor := NewExecutor(driver)
+
+	if _, err := executor.Execute(context.Background(), "DELETE FROM floor_record"); err == nil {
+		t.Error("expect the guard to reject DELETE")
+	}
+	if _, err := executor.Execute(context.Background(), "SELECT * FROM llm_usage_record"); err == nil {
+		t.Error("expect the guard to reject a table outside the allow-list")
+	}
+
+	// the connection refuses writes even past the guard
+	if _, err := driver.Exec("DELETE FROM floor_record"); err == nil {
+		t.Error("expect the read-only connection to refuse writes")
+	}
+	result, err := executor.Execute(context.Background(), "SELECT COUNT(*) FROM floor_record")
+	if err != nil || result.Rows[0][0] != "1" {
+		t.Errorf("floors after rejected writes = %v %v", result, err)
+	}
+}
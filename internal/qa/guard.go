@@ -0,0 +1,188 @@
+package qa
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var (
+	// DefaultAllowedTables are the archive tables a generated query may read.
+	DefaultAllowedTables = []string{"floor_record", "reply_record", "page_record", "building_record", "user_nickname_record"}
+
+	forbiddenKeywords = map[string]bool{
+		"insert": true, "update": true, "delete": true, "replace": true, "upsert": true,
+		"create": true, "drop": true, "alter": true, "truncate": true,
+		"attach": true, "detach": true, "pragma": true, "vacuum": true,
+		"reindex": true, "analyze": true, "begin": true, "commit": true,
+		"rollback": true, "savepoint": true, "release": true,
+		"load_extension": true, "readfile": true, "writefile": true,
+	}
+
+	// clauseKeywords end a comma separated FROM list.
+	clauseKeywords = map[string]bool{
+		"where": true, "group": true, "order": true, "limit": true, "having": true,
+		"union": true, "except": true, "intersect": true, "window": true,
+		"join": true, "inner": true, "left": true, "right": true, "full": true,
+		"cross": true, "natural": true, "on": true, "using": true,
+	}
+)
+
+type sqlToken struct {
+	text    string
+	isWord  bool
+	isQuote bool
+}
+
+// tokenizeSql splits a query into words, quoted identifiers and single
+// character punctuation. String literals and comments are dropped.
+func tokenizeSql(query string) ([]sqlToken, error) {
+	tokens := make([]sqlToken, 0)
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			continue
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			j := i + 2
+			for j+1 < len(runes) && !(runes[j] == '*' && runes[j+1] == '/') {
+				j++
+			}
+			if j+1 >= len(runes) {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			i = j + 1
+		case r == '\'':
+			j := i + 1
+			for ; j < len(runes); j++ {
+				if runes[j] == '\'' {
+					if j+1 < len(runes) && runes[j+1] == '\'' {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string literal")
+			}
+			tokens = append(tokens, sqlToken{text: "''"})
+			i = j
+		case r == '"' || r == '`' || r == '[':
+			closing := r
+			if r == '[' {
+				closing = ']'
+			}
+			j := i + 1
+			for j < len(runes) && runes[j] != closing {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated quoted identifier")
+			}
+			tokens = append(tokens, sqlToken{text: strings.ToLower(string(runes[i+1 : j])), isWord: true, isQuote: true})
+			i = j
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && (runes[j] == '_' || runes[j] == '$' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{text: strings.ToLower(string(runes[i:j])), isWord: true})
+			i = j - 1
+		default:
+			tokens = append(tokens, sqlToken{text: string(r)})
+		}
+	}
+	return tokens, nil
+}
+
+// ValidateSelect accepts a single read-only SELECT (optionally with a WITH
+// clause) which reads only from allowedTables, and returns it without the
+// trailing semicolon.
+func ValidateSelect(query string, allowedTables []string) (string, error) {
+	query = strings.TrimSpace(query)
+	query = strings.TrimSpace(strings.TrimSuffix(query, ";"))
+	if query == "" {
+		return "", fmt.Errorf("empty query")
+	}
+
+	tokens, err := tokenizeSql(query)
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) == 0 {
+		return "", fmt.Errorf("empty query")
+	}
+
+	if first := tokens[0].text; first != "select" && first != "with" {
+		return "", fmt.Errorf("only SELECT statements are allowed, got %q", first)
+	}
+
+	allowed := make(map[string]bool, len(allowedTables))
+	for _, table := range allowedTables {
+		allowed[strings.ToLower(table)] = true
+	}
+
+	// Names defined by WITH are readable like tables
+	for i, token := range tokens {
+		if token.isWord && i+2 < len(tokens) && tokens[i+1].text == "as" && tokens[i+2].text == "(" {
+			allowed[token.text] = true
+		}
+	}
+
+	for i, token := range tokens {
+		if token.text == ";" {
+			return "", fmt.Errorf("only a single statement is allowed")
+		}
+		if token.isWord && !token.isQuote && forbiddenKeywords[token.text] {
+			return "", fmt.Errorf("keyword %q is not allowed", strings.ToUpper(token.text))
+		}
+
+		if !token.isWord || (token.text != "from" && token.text != "join") || token.isQuote {
+			continue
+		}
+
+		// Walk the table list following FROM / JOIN
+		for j := i + 1; j < len(tokens); {
+			if tokens[j].text == "(" {
+				break
+			}
+			if !tokens[j].isWord {
+				return "", fmt.Errorf("unexpected token %q after %s", tokens[j].text, strings.ToUpper(token.text))
+			}
+
+			table := tokens[j].text
+			if j+2 < len(tokens) && tokens[j+1].text == "." && tokens[j+2].isWord {
+				table = tokens[j+2].text
+				j += 2
+			}
+			if j+1 < len(tokens) && tokens[j+1].text == "(" {
+				return "", fmt.Errorf("table-valued function %q is not allowed", table)
+			}
+			if !allowed[table] {
+				return "", fmt.Errorf("table %q is not allowed", table)
+			}
+
+			// Skip an optional alias, continue on a comma separated list
+			j++
+			if j < len(tokens) && tokens[j].text == "as" {
+				j++
+			}
+			if j < len(tokens) && tokens[j].isWord && !clauseKeywords[tokens[j].text] {
+				j++
+			}
+			if token.text == "join" || j >= len(tokens) || tokens[j].text != "," {
+				break
+			}
+			j++
+		}
+	}
+
+	return query, nil
+}
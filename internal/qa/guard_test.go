@@ -0,0 +1,76 @@
+package qa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSelect(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{"count", "SELECT COUNT(*) FROM floor_record;", ""},
+		{"mixed case", "SeLeCt * FrOm FLOOR_RECORD", ""},
+		{"join with alias", "select f.content from floor_record f join reply_record r on r.fid = f.fid", ""},
+		{"table list", "SELECT * FROM floor_record, page_record AS p WHERE p.pid = floor_record.pid", ""},
+		{"schema prefix", "SELECT * FROM main.floor_record", ""},
+		{"quoted table", `SELECT * FROM "floor_record"`, ""},
+		{"quoted column named like a keyword", `SELECT "delete" FROM floor_record`, ""},
+		{"subquery", "SELECT * FROM (SELECT bid FROM floor_record) WHERE bid = 'x'", ""},
+
+		{"keyword in literal", "SELECT * FROM floor_record WHERE content LIKE '%drop table%'", ""},
+		{"escaped quote in literal", "SELECT * FROM floor_record WHERE content = 'it''s; DELETE'", ""},
+		{"keyword in block comment", "SELECT /* DELETE FROM floor_record */ COUNT(*) FROM floor_record", ""},
+		{"statement in line comment", "SELECT * FROM floor_record -- ; DROP TABLE floor_record", ""},
+
+		{"with", "WITH b AS (SELECT id FROM building_record) SELECT * FROM b", ""},
+		{"chained with", "WITH a AS (SELECT 1), b AS (SELECT * FROM a) SELECT * FROM b", ""},
+		{"with reading a hidden table", "WITH a AS (SELECT * FROM llm_usage_record) SELECT * FROM a", `table "llm_usage_record" is not allowed`},
+
+		{"empty", "", "empty query"},
+		{"only a comment", "-- nothing", "empty query"},
+		{"delete", "DELETE FROM floor_record", "only SELECT statements are allowed"},
+		{"pragma", "PRAGMA table_info(floor_record)", "only SELECT statements are allowed"},
+		{"second statement", "SELECT 1; DROP TABLE floor_record", "only a single statement is allowed"},
+		{"statement after literal", "SELECT 'x'; DELETE FROM floor_record", "only a single statement is allowed"},
+		{"keyword inside select", "SELECT * FROM floor_record WHERE 1 = (SELECT 1 FROM (DELETE FROM floor_record))", `keyword "DELETE" is not allowed`},
+		{"attach", "SELECT * FROM floor_record WHERE ATTACH", `keyword "ATTACH" is not allowed`},
+		{"load extension", "SELECT load_extension('x')", `keyword "LOAD_EXTENSION" is not allowed`},
+		{"readfile", "SELECT readfile('/etc/passwd')", `keyword "READFILE" is not allowed`},
+
+		{"hidden table", "SELECT * FROM llm_cache_record", `table "llm_cache_record" is not allowed`},
+		{"hidden joined table", `SELECT * FROM floor_record JOIN "monitor_rule" ON 1`, `table "monitor_rule" is not allowed`},
+		{"hidden table in list", "SELECT * FROM floor_record, monitor_rule", `table "monitor_rule" is not allowed`},
+		{"hidden table in subquery", "SELECT * FROM (SELECT * FROM monitor_rule)", `table "monitor_rule" is not allowed`},
+		{"hidden table in union", "SELECT bid FROM floor_record UNION SELECT key FROM monitor_rule", `table "monitor_rule" is not allowed`},
+		{"schema table", "SELECT sql FROM sqlite_master", `table "sqlite_master" is not allowed`},
+		{"table valued function", "SELECT * FROM pragma_table_info('floor_record')", `table-valued function "pragma_table_info" is not allowed`},
+
+		{"unterminated comment", "SELECT * FROM floor_record /* unterminated", "unterminated comment"},
+		{"unterminated literal", "SELECT 'unterminated FROM floor_record", "unterminated string literal"},
+		{"unterminated identifier", `SELECT * FROM "floor_record`, "unterminated quoted identifier"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ValidateSelect(c.query, DefaultAllowedTables)
+			if c.err == "" {
+				if err != nil {
+					t.Errorf("ValidateSelect(%q) = %v", c.query, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("ValidateSelect(%q) = %v, want %q", c.query, err, c.err)
+			}
+		})
+	}
+}
+
+func TestValidateSelectTrims(t *testing.T) {
+	if query, err := ValidateSelect("  SELECT 1 ; ", nil); err != nil || query != "SELECT 1" {
+		t.Errorf("trailing semicolon: %q %v", query, err)
+	}
+}
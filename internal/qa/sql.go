@@ -0,0 +1,124 @@
+package qa
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/llm"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxSqlAttempts = 2
+
+	sqlSystemPrompt = `You translate questions about a Bahamut forum archive into a single SQLite SELECT statement.
+Rules:
+- Output only the SQL inside one ` + "```sql" + ` block, nothing else.
+- Use only the tables in the schema below. Never modify data.
+- A building is one thread; floors belong to a building through floor_record.bid = building_record.id.
+- Replies (留言) belong to a floor through reply_record.fid = floor_record.fid.
+- Users change nicknames (author_name), author_id never changes. To find posts by a nickname, match author_id
+  against user_nickname_record.author_id of that nickname, which lists every nickname a user went by.
+- user_nickname_record.author_id is lower-cased, floor_record.author_id and reply_record.author_id keep the case
+  the id was registered with. Always compare author ids case-insensitively, e.g.
+  floor_record.author_id COLLATE NOCASE IN (SELECT author_id FROM user_nickname_record WHERE nickname = 'x' COLLATE NOCASE)
+  or lower(floor_record.author_id) = lower('Id').
+- Floor content is raw HTML, use LIKE '%%keyword%%' for text matching.
+- Prefer aggregates (COUNT, GROUP BY) for counting questions.
+
+Schema:
+%s`
+
+	answerSystemPrompt = `You answer questions about a Bahamut forum archive. You are given the question, the SQL that was executed and its result.
+Answer in the language of the question, using only numbers and facts present in the result. If the result is empty, say so.`
+)
+
+var sqlBlockRegexp = regexp.MustCompile("(?s)```(?:sql)?\\s*(.*?)```")
+
+type SqlAnswer struct {
+	Question string
+	Sql      string
+	Result   *QueryResult
+	Answer   string
+}
+
+// SqlAnswerer answers questions by letting the model write a query which is
+// run by the sandboxed Executor, so aggregates come from sqlite instead of the model.
+type SqlAnswerer struct {
+	provider llm.Provider
+	executor *Executor
+	schema   string
+}
+
+func NewSqlAnswerer(provider llm.Provider, executor *Executor) (*SqlAnswerer, error) {
+	schema, err := db.GetTableSchema(executor.driver, executor.AllowedTables...)
+	if err != nil {
+		logrus.WithError(err).Error("db.GetTableSchema failed")
+		return nil, err
+	}
+
+	return &SqlAnswerer{provider: provider, executor: executor, schema: schema}, nil
+}
+
+func extractSql(content string) string {
+	if matches := sqlBlockRegexp.FindStringSubmatch(content); len(matches) == 2 {
+		return strings.TrimSpace(matches[1])
+	}
+	return strings.TrimSpace(content)
+}
+
+// Ask generates, validates and executes a query for the question. building
+// is optional and narrows the question to one building.
+func (answerer *SqlAnswerer) Ask(ctx context.Context, question string, building *db.BuildingRecord) (*SqlAnswer, error) {
+	userPrompt := question
+	if building != nil {
+		userPrompt = fmt.Sprintf("The question is about building id '%s' (title: %s, bsn=%d, snA=%d).\nQuestion: %s",
+			building.Id, building.BuildingTitle, building.Bsn, building.Sna, question)
+	}
+
+	messages := []llm.Message{
+		{Role: llm.RoleSystem, Content: fmt.Sprintf(sqlSystemPrompt, answerer.schema)},
+		{Role: llm.RoleUser, Content: userPrompt},
+	}
+
+	answer := &SqlAnswer{Question: question}
+	for attempt := 1; ; attempt++ {
+		completion, err := llm.Complete(ctx, answerer.provider, messages)
+		if err != nil {
+			logrus.WithError(err).Error("llm.Complete failed")
+			return nil, err
+		}
+
+		answer.Sql = extractSql(completion.Content)
+		result, err := answerer.executor.Execute(ctx, answer.Sql)
+		if err == nil {
+			answer.Result = result
+			break
+		}
+
+		if attempt == maxSqlAttempts {
+			logrus.WithError(err).Error("executor.Execute failed")
+			return answer, err
+		}
+
+		// Give the model one chance to fix its query
+		messages = append(messages,
+			llm.Message{Role: llm.RoleAssistant, Content: completion.Content},
+			llm.Message{Role: llm.RoleUser, Content: fmt.Sprintf("The query was rejected: %v. Please fix it.", err)},
+		)
+	}
+
+	completion, err := llm.Complete(ctx, answerer.provider, []llm.Message{
+		{Role: llm.RoleSystem, Content: answerSystemPrompt},
+		{Role: llm.RoleUser, Content: fmt.Sprintf("Question: %s\n\nSQL:\n%s\n\nResult:\n%s", question, answer.Sql, answer.Result)},
+	})
+	if err != nil {
+		logrus.WithError(err).Error("llm.Complete failed")
+		return answer, err
+	}
+	answer.Answer = completion.Content
+	return answer, nil
+}
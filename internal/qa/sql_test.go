@@ -0,0 +1,111 @@
+package qa
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/llm"
+)
+
+func TestSqlAnswererAsk(t *testing.T) {
+	provider := llm.NewFakeProvider(
+		&llm.FakeResponse{Match: "^How many", Content: "```sql\nDELETE FROM floor_record;\n```"},
+		&llm.FakeResponse{Match: "rejected", Content: "```sql\nSELECT COUNT(*) AS floors FROM floor_record WHERE author_id = 'baha';\n```"},
+		&llm.FakeResponse{Match: "^Question:", Content: "baha posted 2 floors."},
+	)
+	answerer, err := NewSqlAnswerer(provider, NewExecutor(openTestArchive(t, "baha", "someone", "baha")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(answerer.schema, "CREATE TABLE") || strings.Contains(answerer.schema, "llm_cache_record") {
+		t.Errorf("schema = %s", answerer.schema)
+	}
+
+	// the rejected DELETE is sent back once for a fix
+	answer, err := answerer.Ask(context.Background(), "How many floors did baha post?", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(answer.Sql, "SELECT COUNT(*)") {
+		t.Errorf("sql = %q", answer.Sql)
+	}
+	if len(answer.Result.Rows) != 1 || answer.Result.Rows[0][0] != "2" {
+		t.Errorf("result = %s", answer.Result)
+	}
+	if answer.Answer != "baha posted 2 floors." {
+		t.Errorf("answer = %q", answer.Answer)
+	}
+}
+
+func TestSqlAnswererGivesUp(t *testing.T) {
+	provider := llm.NewFakeProvider(&llm.FakeResponse{Content: "DROP TABLE floor_record", Repeat: true})
+	answerer, err := NewSqlAnswerer(provider, NewExecutor(openTestArchive(t, "baha")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := answerer.Ask(context.Background(), "drop everything", nil)
+	if err == nil {
+		t.Fatal("expect error for a query rejected twice")
+	}
+	if answer == nil || answer.Sql != "DROP TABLE floor_record" || answer.Result != nil {
+		t.Errorf("answer = %+v", answer)
+	}
+}
+
+// recordProvider keeps the system prompt of every chat.
+type recordProvider struct {
+	llm.Provider
+	systems []string
+}
+
+func (provider *recordProvider) Chat(ctx context.Context, request *llm.ChatRequest) (*llm.Completion, error) {
+	provider.systems = append(provider.systems, request.Messages[0].Content)
+	return provider.Provider.Chat(ctx, request)
+}
+
+func TestSqlAnswererMixedCaseId(t *testing.T) {
+	driver := openTestArchive(t, "BahaFan", "someone", "BahaFan")
+	// nicknames are kept under the lower-cased id
+	buildingDb := db.NewBuildingDb()
+	if err := buildingDb.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := buildingDb.SaveNicknameRecord(&db.NicknameRecord{AuthorId: "BahaFan", Nickname: "巴哈迷", FirstSeenAt: time.Now(), LastSeenAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &recordProvider{Provider: llm.NewFakeProvider(
+		&llm.FakeResponse{Match: "^How many", Content: "```sql\nSELECT COUNT(*) FROM floor_record\n" +
+			"WHERE author_id COLLATE NOCASE IN (SELECT author_id FROM user_nickname_record WHERE nickname = '巴哈迷' COLLATE NOCASE);\n```"},
+		&llm.FakeResponse{Match: "^Question:", Content: "2"},
+	)}
+	answerer, err := NewSqlAnswerer(provider, NewExecutor(driver))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := answerer.Ask(context.Background(), "How many floors did 巴哈迷 post?", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(provider.systems[0], "lower-cased") || !strings.Contains(provider.systems[0], "COLLATE NOCASE") {
+		t.Errorf("prompt does not ask for case-insensitive ids:\n%s", provider.systems[0])
+	}
+	if answer.Result.Rows[0][0] != "2" {
+		t.Errorf("result = %s", answer.Result)
+	}
+
+	// the plain comparison the prompt warns about misses every floor
+	result, err := answerer.executor.Execute(context.Background(),
+		"SELECT COUNT(*) FROM floor_record WHERE author_id IN (SELECT author_id FROM user_nickname_record WHERE nickname = '巴哈迷')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Rows[0][0] != "0" {
+		t.Errorf("case-sensitive match = %s", result)
+	}
+}
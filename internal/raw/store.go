@@ -0,0 +1,131 @@
+package raw
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultDir = "data/raw"
+
+// Store keeps raw responses gzip compressed on disk, named by the sha256 of
+// their content so a page fetched again unchanged is stored once. Every
+// fetch is indexed in raw_page_record by url and time.
+type Store struct {
+	dir string
+	db  db.RawDB
+}
+
+func NewStore(dir string, rawDb db.RawDB) *Store {
+	return &Store{dir: dir, db: rawDb}
+}
+
+func (store *Store) path(hash string) string {
+	return filepath.Join(store.dir, hash[:2], hash+".gz")
+}
+
+// Save keeps the body fetched from url at fetchedAt.
+func (store *Store) Save(url string, body []byte, fetchedAt time.Time) (*db.RawPageRecord, error) {
+	sum := sha256.Sum256(body)
+	record := &db.RawPageRecord{
+		Url:       url,
+		FetchedAt: fetchedAt,
+		Hash:      hex.EncodeToString(sum[:]),
+		Size:      len(body),
+	}
+
+	if _, err := os.Stat(store.path(record.Hash)); os.IsNotExist(err) {
+		if err := store.write(record.Hash, body); err != nil {
+			logrus.WithError(err).Error("store.write failed")
+			return nil, err
+		}
+	}
+
+	if err := store.db.CreateRawPageRecord(record); err != nil {
+		logrus.WithError(err).Error("db.CreateRawPageRecord failed")
+		return nil, err
+	}
+	return record, nil
+}
+
+// write compresses into a temporary file first, so a crash never leaves a
+// truncated file under the hash.
+func (store *Store) write(hash string, body []byte) error {
+	path := store.path(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logrus.WithError(err).Error("os.MkdirAll failed")
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		logrus.WithError(err).Error("os.CreateTemp failed")
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer := gzip.NewWriter(file)
+	if _, err := writer.Write(body); err != nil {
+		file.Close()
+		logrus.WithError(err).Error("gzip.Writer.Write failed")
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		file.Close()
+		logrus.WithError(err).Error("gzip.Writer.Close failed")
+		return err
+	}
+	if err := file.Close(); err != nil {
+		logrus.WithError(err).Error("file.Close failed")
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Load returns the body of the response, checked against its hash.
+func (store *Store) Load(record *db.RawPageRecord) ([]byte, error) {
+	file, err := os.Open(store.path(record.Hash))
+	if err != nil {
+		logrus.WithError(err).Errorf("os.Open raw page of %s failed", record.Url)
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		logrus.WithError(err).Error("gzip.NewReader failed")
+		return nil, err
+	}
+	defer reader.Close()
+
+	var body bytes.Buffer
+	if _, err := io.Copy(&body, reader); err != nil {
+		logrus.WithError(err).Error("io.Copy failed")
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body.Bytes())
+	if hex.EncodeToString(sum[:]) != record.Hash {
+		return nil, fmt.Errorf("raw page %s of %s is corrupted", record.Hash, record.Url)
+	}
+	return body.Bytes(), nil
+}
+
+// LoadLatest returns the body of the latest response fetched from url.
+func (store *Store) LoadLatest(url string) ([]byte, error) {
+	record, err := store.db.GetLatestRawPageRecord(url)
+	if err != nil {
+		logrus.WithError(err).Errorf("db.GetLatestRawPageRecord of %s failed", url)
+		return nil, err
+	}
+	return store.Load(record)
+}
@@ -0,0 +1,169 @@
+package rule
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/sirupsen/logrus"
+)
+
+type BoardOption func(*BoardRule)
+
+// TitleKeywords only passes threads whose title contains one of the
+// keywords, matched case-insensitively.
+func TitleKeywords(keywords ...string) BoardOption {
+	return func(o *BoardRule) {
+		o.TitleKeywords = keywords
+	}
+}
+
+// TitlePatterns works like TitleKeywords with regular expressions.
+func TitlePatterns(patterns ...*regexp.Regexp) BoardOption {
+	return func(o *BoardRule) {
+		o.TitlePatterns = patterns
+	}
+}
+
+// ThreadAuthors passes every thread opened by one of the ids, whatever its
+// title is.
+func ThreadAuthors(ids ...string) BoardOption {
+	return func(o *BoardRule) {
+		o.Authors = ids
+	}
+}
+
+func BoardInterval(interval time.Duration) BoardOption {
+	return func(o *BoardRule) {
+		o.PokeInterval = interval
+	}
+}
+
+func BoardSinks(names ...string) BoardOption {
+	return func(o *BoardRule) {
+		o.Sinks = names
+	}
+}
+
+// AutoTrack adds a tracking rule following the author of every thread the
+// board rule passes, opts are applied to the new rule.
+func AutoTrack(opts ...RuleOption) BoardOption {
+	return func(o *BoardRule) {
+		o.AutoTrack = true
+		o.TrackOptions = opts
+	}
+}
+
+func NewThreadCallback(callback func(*db.ThreadRecord)) BoardOption {
+	return func(o *BoardRule) {
+		o.NewThreadCallback = callback
+	}
+}
+
+func DefaultNewThreadCallback() BoardOption {
+	return func(o *BoardRule) {
+		o.NewThreadCallback = func(thread *db.ThreadRecord) {
+			logrus.Infof("New Thread by %s: %s", thread.AuthorId, thread.Title)
+		}
+	}
+}
+
+// BoardRule watches the thread list of a board for new threads. A thread
+// passes when its title matches a keyword or pattern or it is opened by
+// one of Authors, every new thread passes when none is given. Sticky
+// threads are never new.
+type BoardRule struct {
+	Name string
+	Bsn  int
+	Url  string
+
+	// LastSna is the newest thread seen, threads are numbered in the order
+	// they are opened
+	LastSna int
+
+	TitleKeywords []string
+	TitlePatterns []*regexp.Regexp
+	Authors       []string
+
+	PokeInterval time.Duration
+	Sinks        []string
+
+	AutoTrack    bool
+	TrackOptions []RuleOption
+
+	NewThreadCallback func(*db.ThreadRecord)
+
+	matchers []*regexp.Regexp
+}
+
+func NewBoardRule(name string, bsn int, opts ...BoardOption) *BoardRule {
+	rule := &BoardRule{Name: name, Bsn: bsn}
+	for _, opt := range opts {
+		opt(rule)
+	}
+
+	if rule.NewThreadCallback == nil {
+		DefaultNewThreadCallback()(rule)
+	}
+
+	if rule.Name == "" || rule.Bsn == 0 {
+		logrus.Errorf("Name or Bsn of board rule is not set")
+		return nil
+	}
+
+	rule.matchers = make([]*regexp.Regexp, 0, len(rule.TitleKeywords)+len(rule.TitlePatterns))
+	for _, keyword := range rule.TitleKeywords {
+		rule.matchers = append(rule.matchers, regexp.MustCompile("(?i)"+regexp.QuoteMeta(keyword)))
+	}
+	rule.matchers = append(rule.matchers, rule.TitlePatterns...)
+
+	rule.Url = craw.GetBoardPageUrl(rule.Bsn, 1)
+	return rule
+}
+
+// Key identifies the board rule across restarts of the monitor.
+func (rule *BoardRule) Key() string {
+	return fmt.Sprintf("board-%d-%s", rule.Bsn, rule.Name)
+}
+
+func (rule *BoardRule) GetInterval() time.Duration {
+	if rule.PokeInterval == 0 {
+		return DefaultInterval
+	}
+	return rule.PokeInterval
+}
+
+// MatchThread reports whether a new thread passes the rule.
+func (rule *BoardRule) MatchThread(thread *db.ThreadRecord) bool {
+	if len(rule.matchers) == 0 && len(rule.Authors) == 0 {
+		return true
+	}
+
+	for _, author := range rule.Authors {
+		if strings.EqualFold(author, thread.AuthorId) {
+			return true
+		}
+	}
+	for _, matcher := range rule.matchers {
+		if matcher.MatchString(thread.Title) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrackingRule builds the rule auto-tracking a thread, following its
+// author with the sinks of the board rule.
+func (rule *BoardRule) TrackingRule(thread *db.ThreadRecord) *TrackingRule {
+	opts := []RuleOption{
+		Name(thread.Title),
+		Bsn(thread.Bsn),
+		Sna(thread.Sna),
+		Id(thread.AuthorId),
+		Sinks(rule.Sinks...),
+	}
+	return NewTrackingRule(append(opts, rule.TrackOptions...)...)
+}
@@ -0,0 +1,627 @@
+package rule
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleConfig is one entry of a rules file. The building is given either by
+// bsn and sna or by url, which may also carry the author as s_author.
+//
+//	rules:
+//	  - name: cs
+//	    url: https://forum.gamer.com.tw/C.php?bsn=60076&snA=3146926
+//	    author: leichitw
+//	    interval: 10s
+//	    max_interval: 5m
+//	    jitter: 0.2
+//	    quiet_hours: ['02:00-08:00']
+//	    max_failure: 20
+//	    sinks: [discord]
+//	    keywords: [面試, offer]
+//	    track_replies: [to_author, by_author]
+//	  - name: hiring
+//	    bsn: 60076
+//	    sna: 3146926
+//	    patterns: ['徵才|內推']
+//	    replies: true
+//	    exclude_authors: [spammer]
+//
+// A rule without author is a watch rule, which matches every floor of the
+// building against its keywords and patterns and needs a unique name.
+//
+// With max_interval the interval backs off while nothing happens and drops
+// back to interval after activity, quiet_hours are given in Baha time.
+type RuleConfig struct {
+	Name        string   `yaml:"name" json:"name,omitempty"`
+	Bsn         int      `yaml:"bsn" json:"bsn,omitempty"`
+	Sna         int      `yaml:"sna" json:"sna,omitempty"`
+	Url         string   `yaml:"url" json:"url,omitempty"`
+	Author      string   `yaml:"author" json:"author,omitempty"`
+	Interval    string   `yaml:"interval" json:"interval,omitempty"`
+	MaxFailure  int      `yaml:"max_failure" json:"max_failure,omitempty"`
+	Sinks       []string `yaml:"sinks" json:"sinks,omitempty"`
+	Keywords    []string `yaml:"keywords" json:"keywords,omitempty"`
+	SkipReplay  bool     `yaml:"skip_replay" json:"skip_replay,omitempty"`
+	SyncLocalDb bool     `yaml:"sync_local_db" json:"sync_local_db,omitempty"`
+
+	MaxInterval string   `yaml:"max_interval" json:"max_interval,omitempty"`
+	Jitter      float64  `yaml:"jitter" json:"jitter,omitempty"`
+	QuietHours  []string `yaml:"quiet_hours" json:"quiet_hours,omitempty"`
+
+	Patterns       []string `yaml:"patterns" json:"patterns,omitempty"`
+	IncludeAuthors []string `yaml:"include_authors" json:"include_authors,omitempty"`
+	ExcludeAuthors []string `yaml:"exclude_authors" json:"exclude_authors,omitempty"`
+	Replies        bool     `yaml:"replies" json:"replies,omitempty"`
+	TrackReplies   []string `yaml:"track_replies" json:"track_replies,omitempty"`
+}
+
+var replyModeNames = map[string]ReplyMode{
+	"to_author": RepliesToAuthor,
+	"by_author": RepliesByAuthor,
+}
+
+// BoardConfig is one entry of the boards section of a rules file, a board
+// rule watching the thread list of a board for new threads.
+//
+//	boards:
+//	  - name: cs-jobs
+//	    bsn: 60076
+//	    title_keywords: [徵才, 內推]
+//	    authors: [leichitw]
+//	    interval: 1m
+//	    sinks: [discord]
+//	    auto_track: true
+//
+// With auto_track a tracking rule following the author is added for every
+// thread passing the board rule. Boards are read once when the monitor
+// starts, a reload of the file leaves them alone.
+type BoardConfig struct {
+	Name          string   `yaml:"name" json:"name,omitempty"`
+	Bsn           int      `yaml:"bsn" json:"bsn,omitempty"`
+	Url           string   `yaml:"url" json:"url,omitempty"`
+	TitleKeywords []string `yaml:"title_keywords" json:"title_keywords,omitempty"`
+	TitlePatterns []string `yaml:"title_patterns" json:"title_patterns,omitempty"`
+	Authors       []string `yaml:"authors" json:"authors,omitempty"`
+	Interval      string   `yaml:"interval" json:"interval,omitempty"`
+	Sinks         []string `yaml:"sinks" json:"sinks,omitempty"`
+	AutoTrack     bool     `yaml:"auto_track" json:"auto_track,omitempty"`
+}
+
+// DigestConfig is one entry of the digests section of a rules file, a
+// digest of a building written every interval.
+//
+//	digests:
+//	  - name: cs-daily
+//	    url: https://forum.gamer.com.tw/C.php?bsn=60076&snA=3146926
+//	    interval: 24h
+//	    window: 24h
+//	  - name: cs-pages
+//	    bsn: 60076
+//	    sna: 3146926
+//	    interval: 6h
+//	    last_pages: 2
+//
+// A digest covers the floors posted within window before it runs, window
+// defaults to interval, or the newest last_pages archived pages instead.
+// Digests are read once when the monitor starts.
+type DigestConfig struct {
+	Name      string `yaml:"name" json:"name,omitempty"`
+	Bsn       int    `yaml:"bsn" json:"bsn,omitempty"`
+	Sna       int    `yaml:"sna" json:"sna,omitempty"`
+	Url       string `yaml:"url" json:"url,omitempty"`
+	Interval  string `yaml:"interval" json:"interval,omitempty"`
+	Window    string `yaml:"window" json:"window,omitempty"`
+	LastPages int    `yaml:"last_pages" json:"last_pages,omitempty"`
+}
+
+// DigestRule is a validated DigestConfig, either Window or LastPages is set.
+type DigestRule struct {
+	Name      string
+	Bsn       int
+	Sna       int
+	Interval  time.Duration
+	Window    time.Duration
+	LastPages int
+}
+
+// MinDigestInterval keeps a misconfigured digest from spending the budget.
+const MinDigestInterval = time.Minute
+
+type RulesFile struct {
+	Rules   []*RuleConfig   `yaml:"rules"`
+	Boards  []*BoardConfig  `yaml:"boards"`
+	Digests []*DigestConfig `yaml:"digests"`
+}
+
+func (config *RuleConfig) options() ([]RuleOption, error) {
+	bsn, sna, author := config.Bsn, config.Sna, config.Author
+	if config.Url != "" {
+		if bsn != 0 || sna != 0 {
+			return nil, errors.New("url and bsn/sna are mutually exclusive")
+		}
+		targetInfo, err := craw.GetTargetInfoFromUrl(config.Url)
+		if err != nil {
+			return nil, fmt.Errorf("url is invalid: %w", err)
+		}
+		bsn, sna = targetInfo.Bsn, targetInfo.Sna
+		if author == "" {
+			author = getAuthorFromUrl(config.Url)
+		}
+	}
+
+	if bsn <= 0 {
+		return nil, errors.New("bsn is missing")
+	}
+	if sna <= 0 {
+		return nil, errors.New("sna is missing")
+	}
+	watch := author == ""
+	if watch && len(config.Keywords) == 0 && len(config.Patterns) == 0 {
+		return nil, errors.New("author is missing, or keywords/patterns for a watch rule")
+	}
+	if watch && config.Name == "" {
+		return nil, errors.New("name is missing, a watch rule needs one")
+	}
+	if !watch && (len(config.IncludeAuthors) > 0 || len(config.ExcludeAuthors) > 0 || config.Replies) {
+		return nil, errors.New("include_authors, exclude_authors and replies need a watch rule without author")
+	}
+	if watch && len(config.TrackReplies) > 0 {
+		return nil, errors.New("track_replies needs an author, use replies for a watch rule")
+	}
+	if config.MaxFailure < 0 {
+		return nil, fmt.Errorf("max_failure %d is negative", config.MaxFailure)
+	}
+
+	opts := []RuleOption{
+		Name(config.Name),
+		Bsn(bsn),
+		Sna(sna),
+		Id(author),
+		MaxFailure(config.MaxFailure),
+		SkipReplay(config.SkipReplay),
+		SyncLocalDb(config.SyncLocalDb),
+	}
+
+	interval := DefaultInterval
+	if config.Interval != "" {
+		var err error
+		interval, err = time.ParseDuration(config.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("interval %q is invalid, expect a duration such as 30s", config.Interval)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("interval %s is shorter than 1s", interval)
+		}
+		opts = append(opts, PokeInterval(interval))
+	}
+
+	if config.MaxInterval != "" {
+		maxInterval, err := time.ParseDuration(config.MaxInterval)
+		if err != nil {
+			return nil, fmt.Errorf("max_interval %q is invalid, expect a duration such as 5m", config.MaxInterval)
+		}
+		if maxInterval < interval {
+			return nil, fmt.Errorf("max_interval %s is shorter than interval %s", maxInterval, interval)
+		}
+		opts = append(opts, MaxInterval(maxInterval))
+	}
+
+	if config.Jitter < 0 || config.Jitter >= 1 {
+		return nil, fmt.Errorf("jitter %v is out of range, expect a fraction in [0, 1)", config.Jitter)
+	}
+	if config.Jitter > 0 {
+		opts = append(opts, Jitter(config.Jitter))
+	}
+
+	quietHours := make([]QuietWindow, 0, len(config.QuietHours))
+	for _, text := range config.QuietHours {
+		quiet, err := ParseQuietWindow(text)
+		if err != nil {
+			return nil, err
+		}
+		quietHours = append(quietHours, quiet)
+	}
+	if len(quietHours) > 0 {
+		opts = append(opts, QuietHours(quietHours...))
+	}
+
+	if len(config.Sinks) > 0 {
+		opts = append(opts, Sinks(config.Sinks...))
+	}
+
+	keywords := make([]string, 0, len(config.Keywords))
+	for _, keyword := range config.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword == "" {
+			return nil, errors.New("keywords contains an empty keyword")
+		}
+		keywords = append(keywords, keyword)
+	}
+	if len(keywords) > 0 {
+		opts = append(opts, Keywords(keywords...))
+	}
+
+	patterns := make([]*regexp.Regexp, 0, len(config.Patterns))
+	for _, expr := range config.Patterns {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q is invalid: %w", expr, err)
+		}
+		if pattern.MatchString("") {
+			return nil, fmt.Errorf("pattern %q matches empty text", expr)
+		}
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) > 0 {
+		opts = append(opts, Patterns(patterns...))
+	}
+
+	var replyMode ReplyMode
+	for _, name := range config.TrackReplies {
+		mode, exist := replyModeNames[name]
+		if !exist {
+			return nil, fmt.Errorf("track_replies %q is unknown, expect to_author or by_author", name)
+		}
+		replyMode |= mode
+	}
+	if replyMode != 0 {
+		opts = append(opts, TrackReplies(replyMode))
+	}
+
+	if watch {
+		opts = append(opts,
+			IncludeAuthors(config.IncludeAuthors...),
+			ExcludeAuthors(config.ExcludeAuthors...),
+			WatchReplies(config.Replies),
+		)
+	}
+	return opts, nil
+}
+
+// NewRule validates the config and builds its rule, opts are applied after
+// the settings of the config.
+func (config *RuleConfig) NewRule(opts ...RuleOption) (*TrackingRule, error) {
+	ruleOpts, err := config.options()
+	if err != nil {
+		return nil, err
+	}
+
+	rule := NewTrackingRule(append(ruleOpts, opts...)...)
+	if rule == nil {
+		return nil, errors.New("rule is invalid")
+	}
+	return rule, nil
+}
+
+func getAuthorFromUrl(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsedURL.Query().Get("s_author")
+}
+
+// NewBoardRule validates the config and builds its board rule, opts are
+// applied to the tracking rules it adds.
+func (config *BoardConfig) NewBoardRule(opts ...RuleOption) (*BoardRule, error) {
+	bsn := config.Bsn
+	if config.Url != "" {
+		if bsn != 0 {
+			return nil, errors.New("url and bsn are mutually exclusive")
+		}
+		targetInfo, err := craw.GetTargetInfoFromUrl(config.Url)
+		if err != nil {
+			return nil, fmt.Errorf("url is invalid: %w", err)
+		}
+		bsn = targetInfo.Bsn
+	}
+	if bsn <= 0 {
+		return nil, errors.New("bsn is missing")
+	}
+	if config.Name == "" {
+		return nil, errors.New("name is missing")
+	}
+
+	boardOpts := make([]BoardOption, 0)
+	if config.Interval != "" {
+		interval, err := time.ParseDuration(config.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("interval %q is invalid, expect a duration such as 1m", config.Interval)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("interval %s is shorter than 1s", interval)
+		}
+		boardOpts = append(boardOpts, BoardInterval(interval))
+	}
+
+	keywords := make([]string, 0, len(config.TitleKeywords))
+	for _, keyword := range config.TitleKeywords {
+		if keyword = strings.TrimSpace(keyword); keyword == "" {
+			return nil, errors.New("title_keywords contains an empty keyword")
+		}
+		keywords = append(keywords, keyword)
+	}
+	if len(keywords) > 0 {
+		boardOpts = append(boardOpts, TitleKeywords(keywords...))
+	}
+
+	patterns := make([]*regexp.Regexp, 0, len(config.TitlePatterns))
+	for _, expr := range config.TitlePatterns {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("title pattern %q is invalid: %w", expr, err)
+		}
+		if pattern.MatchString("") {
+			return nil, fmt.Errorf("title pattern %q matches empty text", expr)
+		}
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) > 0 {
+		boardOpts = append(boardOpts, TitlePatterns(patterns...))
+	}
+
+	if len(config.Authors) > 0 {
+		boardOpts = append(boardOpts, ThreadAuthors(config.Authors...))
+	}
+	if len(config.Sinks) > 0 {
+		boardOpts = append(boardOpts, BoardSinks(config.Sinks...))
+	}
+	if config.AutoTrack {
+		boardOpts = append(boardOpts, AutoTrack(opts...))
+	}
+
+	board := NewBoardRule(config.Name, bsn, boardOpts...)
+	if board == nil {
+		return nil, errors.New("board rule is invalid")
+	}
+	return board, nil
+}
+
+// NewDigestRule validates the config and builds its digest rule.
+func (config *DigestConfig) NewDigestRule() (*DigestRule, error) {
+	bsn, sna := config.Bsn, config.Sna
+	if config.Url != "" {
+		if bsn != 0 || sna != 0 {
+			return nil, errors.New("url and bsn/sna are mutually exclusive")
+		}
+		targetInfo, err := craw.GetTargetInfoFromUrl(config.Url)
+		if err != nil {
+			return nil, fmt.Errorf("url is invalid: %w", err)
+		}
+		bsn, sna = targetInfo.Bsn, targetInfo.Sna
+	}
+	if bsn <= 0 {
+		return nil, errors.New("bsn is missing")
+	}
+	if sna <= 0 {
+		return nil, errors.New("sna is missing")
+	}
+	if config.Name == "" {
+		return nil, errors.New("name is missing")
+	}
+
+	if config.Interval == "" {
+		return nil, errors.New("interval is missing")
+	}
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("interval %q is invalid, expect a duration such as 24h", config.Interval)
+	}
+	if interval < MinDigestInterval {
+		return nil, fmt.Errorf("interval %s is shorter than %s", interval, MinDigestInterval)
+	}
+
+	digest := &DigestRule{Name: config.Name, Bsn: bsn, Sna: sna, Interval: interval}
+	if config.LastPages < 0 {
+		return nil, fmt.Errorf("last_pages %d is negative", config.LastPages)
+	}
+	if config.LastPages > 0 {
+		if config.Window != "" {
+			return nil, errors.New("window and last_pages are mutually exclusive")
+		}
+		digest.LastPages = config.LastPages
+		return digest, nil
+	}
+
+	digest.Window = interval
+	if config.Window != "" {
+		if digest.Window, err = time.ParseDuration(config.Window); err != nil {
+			return nil, fmt.Errorf("window %q is invalid, expect a duration such as 24h", config.Window)
+		}
+		if digest.Window <= 0 {
+			return nil, fmt.Errorf("window %s is not positive", digest.Window)
+		}
+	}
+	return digest, nil
+}
+
+func decodeRulesFile(data []byte) (*RulesFile, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var file RulesFile
+	if err := decoder.Decode(&file); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("rules file is malformed: %w", err)
+	}
+	return &file, nil
+}
+
+// ParseRules reads rules in YAML or JSON, opts are applied to every rule
+// after its own settings. Every invalid entry is reported, each prefixed by
+// its position in the file.
+func ParseRules(data []byte, opts ...RuleOption) ([]*TrackingRule, error) {
+	file, err := decodeRulesFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*TrackingRule, 0, len(file.Rules))
+	errs := make([]error, 0)
+	seen := make(map[string]string)
+	for i, config := range file.Rules {
+		position := fmt.Sprintf("rules[%d]", i)
+		if config == nil {
+			errs = append(errs, fmt.Errorf("%s: entry is empty", position))
+			continue
+		}
+		if config.Name != "" {
+			position = fmt.Sprintf("rules[%d] (%s)", i, config.Name)
+		}
+
+		rule, err := config.NewRule(opts...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", position, err))
+			continue
+		}
+
+		if previous, exist := seen[rule.Key()]; exist {
+			errs = append(errs, fmt.Errorf("%s: duplicates %s", position, previous))
+			continue
+		}
+		seen[rule.Key()] = position
+		rules = append(rules, rule)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return rules, nil
+}
+
+func LoadRulesFile(path string, opts ...RuleOption) ([]*TrackingRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logrus.WithError(err).Errorf("os.ReadFile %s failed", path)
+		return nil, err
+	}
+
+	rules, err := ParseRules(data, opts...)
+	if err != nil {
+		logrus.WithError(err).Errorf("rules file %s is invalid", path)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// ParseBoardRules reads the boards section of a rules file like ParseRules
+// does with the rules, opts are applied to the rules auto-tracked by them.
+func ParseBoardRules(data []byte, opts ...RuleOption) ([]*BoardRule, error) {
+	file, err := decodeRulesFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	boards := make([]*BoardRule, 0, len(file.Boards))
+	errs := make([]error, 0)
+	seen := make(map[string]string)
+	for i, config := range file.Boards {
+		position := fmt.Sprintf("boards[%d]", i)
+		if config == nil {
+			errs = append(errs, fmt.Errorf("%s: entry is empty", position))
+			continue
+		}
+		if config.Name != "" {
+			position = fmt.Sprintf("boards[%d] (%s)", i, config.Name)
+		}
+
+		board, err := config.NewBoardRule(opts...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", position, err))
+			continue
+		}
+
+		if previous, exist := seen[board.Key()]; exist {
+			errs = append(errs, fmt.Errorf("%s: duplicates %s", position, previous))
+			continue
+		}
+		seen[board.Key()] = position
+		boards = append(boards, board)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return boards, nil
+}
+
+func LoadBoardRulesFile(path string, opts ...RuleOption) ([]*BoardRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logrus.WithError(err).Errorf("os.ReadFile %s failed", path)
+		return nil, err
+	}
+
+	boards, err := ParseBoardRules(data, opts...)
+	if err != nil {
+		logrus.WithError(err).Errorf("boards of rules file %s are invalid", path)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return boards, nil
+}
+
+// ParseDigestRules reads the digests section of a rules file like
+// ParseRules does with the rules.
+func ParseDigestRules(data []byte) ([]*DigestRule, error) {
+	file, err := decodeRulesFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make([]*DigestRule, 0, len(file.Digests))
+	errs := make([]error, 0)
+	seen := make(map[string]string)
+	for i, config := range file.Digests {
+		position := fmt.Sprintf("digests[%d]", i)
+		if config == nil {
+			errs = append(errs, fmt.Errorf("%s: entry is empty", position))
+			continue
+		}
+		if config.Name != "" {
+			position = fmt.Sprintf("digests[%d] (%s)", i, config.Name)
+		}
+
+		digest, err := config.NewDigestRule()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", position, err))
+			continue
+		}
+
+		if previous, exist := seen[digest.Name]; exist {
+			errs = append(errs, fmt.Errorf("%s: duplicates %s", position, previous))
+			continue
+		}
+		seen[digest.Name] = position
+		digests = append(digests, digest)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return digests, nil
+}
+
+func LoadDigestRulesFile(path string) ([]*DigestRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logrus.WithError(err).Errorf("os.ReadFile %s failed", path)
+		return nil, err
+	}
+
+	digests, err := ParseDigestRules(data)
+	if err != nil {
+		logrus.WithError(err).Errorf("digests of rules file %s are invalid", path)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return digests, nil
+}
@@ -0,0 +1,207 @@
+package rule
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	data := []byte(`
+rules:
+  - name: cs
+    url: https://forum.gamer.com.tw/C.php?bsn=60076&snA=3146926&s_author=leichitw
+    interval: 10s
+    max_interval: 5m
+    jitter: 0.2
+    quiet_hours: ['02:00-08:00']
+    sinks: [discord]
+    keywords: [' 面試 ', offer]
+    track_replies: [to_author, by_author]
+  - name: hiring
+    bsn: 60076
+    sna: 3146926
+    patterns: ['徵才|內推']
+    replies: true
+    exclude_authors: [spammer]
+`)
+	rules, err := ParseRules(data, MaxFailure(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules", len(rules))
+	}
+
+	author := rules[0]
+	if author.Key() != "60076-3146926-leichitw" || author.IsWatch() {
+		t.Errorf("author rule key = %s", author.Key())
+	}
+	if author.GetInterval() != 10*time.Second || author.MaxInterval != 5*time.Minute || author.Jitter != 0.2 {
+		t.Errorf("author rule schedule = %s, %s, %v", author.GetInterval(), author.MaxInterval, author.Jitter)
+	}
+	if len(author.QuietHours) != 1 || author.QuietHours[0].String() != "02:00-08:00" {
+		t.Errorf("author rule quiet hours = %v", author.QuietHours)
+	}
+	if strings.Join(author.Keywords, ",") != "面試,offer" || author.ReplyMode != RepliesToAuthor|RepliesByAuthor {
+		t.Errorf("author rule keywords = %q, reply mode = %d", author.Keywords, author.ReplyMode)
+	}
+	// opts are applied after the settings of every entry
+	if author.GetMaxFailure() != 3 {
+		t.Errorf("author rule max failure = %d", author.GetMaxFailure())
+	}
+
+	watch := rules[1]
+	if watch.Key() != "60076-3146926-watch-hiring" || !watch.TracksReplies() || len(watch.Patterns) != 1 {
+		t.Errorf("watch rule = %+v", watch)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	cases := []struct {
+		entry string
+		err   string
+	}{
+		{"{name: a, sna: 1, author: x}", "rules[0] (a): bsn is missing"},
+		{"{bsn: 1, author: x}", "rules[0]: sna is missing"},
+		{"{url: 'https://forum.gamer.com.tw/C.php?bsn=1&snA=2', bsn: 1, author: x}", "url and bsn/sna are mutually exclusive"},
+		{"{url: 'https://forum.gamer.com.tw/C.php?bsn=x&snA=2', author: x}", "url is invalid"},
+		{"{bsn: 1, sna: 1}", "author is missing, or keywords/patterns for a watch rule"},
+		{"{bsn: 1, sna: 1, keywords: [a]}", "name is missing, a watch rule needs one"},
+		{"{bsn: 1, sna: 1, author: x, replies: true}", "include_authors, exclude_authors and replies need a watch rule without author"},
+		{"{name: a, bsn: 1, sna: 1, keywords: [a], track_replies: [to_author]}", "track_replies needs an author"},
+		{"{bsn: 1, sna: 1, author: x, max_failure: -1}", "max_failure -1 is negative"},
+		{"{bsn: 1, sna: 1, author: x, interval: soon}", `interval "soon" is invalid, expect a duration such as 30s`},
+		{"{bsn: 1, sna: 1, author: x, interval: 500ms}", "interval 500ms is shorter than 1s"},
+		{"{bsn: 1, sna: 1, author: x, max_interval: later}", `max_interval "later" is invalid`},
+		{"{bsn: 1, sna: 1, author: x, interval: 1m, max_interval: 30s}", "max_interval 30s is shorter than interval 1m0s"},
+		{"{bsn: 1, sna: 1, author: x, jitter: 1}", "jitter 1 is out of range"},
+		{"{bsn: 1, sna: 1, author: x, jitter: -0.1}", "jitter -0.1 is out of range"},
+		{"{bsn: 1, sna: 1, author: x, quiet_hours: ['2-8']}", `quiet hours "2-8" is invalid, expect HH:MM-HH:MM`},
+		{"{bsn: 1, sna: 1, author: x, quiet_hours: ['08:00-08:00']}", `quiet hours "08:00-08:00" is empty`},
+		{"{bsn: 1, sna: 1, author: x, keywords: ['  ']}", "keywords contains an empty keyword"},
+		{"{name: a, bsn: 1, sna: 1, patterns: ['(']}", `pattern "(" is invalid`},
+		{"{name: a, bsn: 1, sna: 1, patterns: ['a*']}", `pattern "a*" matches empty text`},
+		{"{bsn: 1, sna: 1, author: x, track_replies: [everyone]}", `track_replies "everyone" is unknown`},
+		{"{bsn: 1, sna: 1, author: x, keyword: [a]}", "rules file is malformed"},
+		{"", "rules[0]: entry is empty"},
+	}
+
+	for _, c := range cases {
+		if _, err := ParseRules([]byte("rules:\n  - " + c.entry)); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: err = %v, want %q", c.entry, err, c.err)
+		}
+	}
+}
+
+func TestParseRulesReportsEveryEntry(t *testing.T) {
+	data := []byte(`
+rules:
+  - {bsn: 1, sna: 1, author: x}
+  - {name: dup, bsn: 1, sna: 1, author: x}
+  - {name: bad, bsn: 1, sna: 1, author: y, interval: soon}
+  - {name: w, bsn: 1, sna: 1, keywords: [a]}
+  - {name: w, bsn: 1, sna: 1, patterns: [b]}
+`)
+	_, err := ParseRules(data)
+	if err == nil {
+		t.Fatal("expect errors")
+	}
+	want := []string{
+		"rules[1] (dup): duplicates rules[0]",
+		`rules[2] (bad): interval "soon" is invalid`,
+		"rules[4] (w): duplicates rules[3] (w)",
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("err = %v", err)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]) {
+			t.Errorf("error %d = %q, want %q", i, line, want[i])
+		}
+	}
+}
+
+func TestLoadRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"rules": [{"bsn": 1, "sna": 2, "author": "x", "interval": "0s"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// JSON is read as YAML and the path leads the error
+	if _, err := LoadRulesFile(path); err == nil || !strings.HasPrefix(err.Error(), path+": rules[0]: interval 0s is shorter than 1s") {
+		t.Errorf("err = %v", err)
+	}
+
+	if _, err := LoadRulesFile(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
+
+func TestParseDigestRules(t *testing.T) {
+	data := []byte(`
+digests:
+  - name: cs-daily
+    url: https://forum.gamer.com.tw/C.php?bsn=60076&snA=3146926
+    interval: 24h
+  - name: cs-pages
+    bsn: 60076
+    sna: 3146926
+    interval: 6h
+    last_pages: 2
+  - name: cs-week
+    bsn: 60076
+    sna: 3146926
+    interval: 24h
+    window: 168h
+`)
+	digests, err := ParseDigestRules(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DigestRule{
+		{Name: "cs-daily", Bsn: 60076, Sna: 3146926, Interval: 24 * time.Hour, Window: 24 * time.Hour},
+		{Name: "cs-pages", Bsn: 60076, Sna: 3146926, Interval: 6 * time.Hour, LastPages: 2},
+		{Name: "cs-week", Bsn: 60076, Sna: 3146926, Interval: 24 * time.Hour, Window: 168 * time.Hour},
+	}
+	if len(digests) != len(want) {
+		t.Fatalf("got %d digests", len(digests))
+	}
+	for i, digest := range digests {
+		if *digest != want[i] {
+			t.Errorf("digests[%d] = %+v, want %+v", i, *digest, want[i])
+		}
+	}
+}
+
+func TestParseDigestRulesErrors(t *testing.T) {
+	cases := []struct {
+		entry string
+		err   string
+	}{
+		{"{name: a, sna: 1, interval: 1h}", "digests[0] (a): bsn is missing"},
+		{"{name: a, bsn: 1, interval: 1h}", "sna is missing"},
+		{"{bsn: 1, sna: 1, interval: 1h}", "digests[0]: name is missing"},
+		{"{name: a, url: 'https://forum.gamer.com.tw/C.php?bsn=1&snA=2', bsn: 1, interval: 1h}", "url and bsn/sna are mutually exclusive"},
+		{"{name: a, bsn: 1, sna: 1}", "interval is missing"},
+		{"{name: a, bsn: 1, sna: 1, interval: daily}", `interval "daily" is invalid`},
+		{"{name: a, bsn: 1, sna: 1, interval: 10s}", "interval 10s is shorter than 1m0s"},
+		{"{name: a, bsn: 1, sna: 1, interval: 1h, window: -1h}", "window -1h0m0s is not positive"},
+		{"{name: a, bsn: 1, sna: 1, interval: 1h, window: 1h, last_pages: 2}", "window and last_pages are mutually exclusive"},
+		{"{name: a, bsn: 1, sna: 1, interval: 1h, last_pages: -1}", "last_pages -1 is negative"},
+		{"{name: a, bsn: 1, sna: 1, interval: 1h, pages: 2}", "field pages not found"},
+	}
+
+	for _, c := range cases {
+		if _, err := ParseDigestRules([]byte("digests:\n  - " + c.entry)); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: err = %v, want %q", c.entry, err, c.err)
+		}
+	}
+
+	_, err := ParseDigestRules([]byte("digests:\n  - {name: a, bsn: 1, sna: 1, interval: 1h}\n  - {name: a, bsn: 1, sna: 2, interval: 1h}"))
+	if err == nil || !strings.Contains(err.Error(), "digests[1] (a): duplicates digests[0] (a)") {
+		t.Errorf("duplicate name: err = %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package rule
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/davidleitw/baha/internal/db"
+	"github.com/davidleitw/baha/internal/textutil"
+)
+
+const (
+	DefaultHighlightLength = 200
+
+	highlightOpen  = "【"
+	highlightClose = "】"
+
+	// highlightContext is how many runes before the first match are kept
+	// when the text has to be cut.
+	highlightContext = 40
+)
+
+// Span is a matched byte range [Start, End) of a text.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Match is a floor, or a reply under it, matched by the keywords or
+// patterns of a rule.
+type Match struct {
+	Floor *db.FloorRecord
+	// Reply is nil when the floor itself matched
+	Reply *db.ReplyRecord
+
+	Text  string
+	Terms []string
+	Spans []Span
+}
+
+func (match *Match) AuthorName() string {
+	if match.Reply != nil {
+		return match.Reply.AuthorName
+	}
+	return match.Floor.AuthorName
+}
+
+func (match *Match) AuthorId() string {
+	if match.Reply != nil {
+		return match.Reply.AuthorId
+	}
+	return match.Floor.AuthorId
+}
+
+// Highlight wraps every matched span in 【】 and cuts the text to about
+// limit runes around the first match.
+func (match *Match) Highlight(limit int) string {
+	var builder strings.Builder
+	last := 0
+	for _, span := range match.Spans {
+		builder.WriteString(match.Text[last:span.Start])
+		builder.WriteString(highlightOpen)
+		builder.WriteString(match.Text[span.Start:span.End])
+		builder.WriteString(highlightClose)
+		last = span.End
+	}
+	builder.WriteString(match.Text[last:])
+	highlighted := builder.String()
+
+	prefix := ""
+	if len(match.Spans) > 0 {
+		// no marker comes before the first match, its offset is the same
+		start := len([]rune(match.Text[:match.Spans[0].Start]))
+		if start > highlightContext && len([]rune(highlighted)) > limit {
+			highlighted = string([]rune(highlighted)[start-highlightContext:])
+			prefix = "…"
+		}
+	}
+	return prefix + textutil.Excerpt(highlighted, limit)
+}
+
+// FindMatches returns the spans of text matched by the keywords and
+// patterns of the rule, sorted and merged, and the distinct matched terms.
+func (rule *TrackingRule) FindMatches(text string) ([]string, []Span) {
+	spans := make([]Span, 0)
+	terms := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, matcher := range rule.matchers {
+		for _, loc := range matcher.FindAllStringIndex(text, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			spans = append(spans, Span{Start: loc[0], End: loc[1]})
+			if term := text[loc[0]:loc[1]]; !seen[strings.ToLower(term)] {
+				seen[strings.ToLower(term)] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	merged := make([]Span, 0, len(spans))
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span.Start <= merged[n-1].End {
+			if span.End > merged[n-1].End {
+				merged[n-1].End = span.End
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return terms, merged
+}
+
+// MatchText reports whether text passes the keywords and patterns of the
+// rule, any text passes when the rule has none.
+func (rule *TrackingRule) MatchText(text string) bool {
+	if len(rule.matchers) == 0 {
+		return true
+	}
+	for _, matcher := range rule.matchers {
+		if matcher.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchAuthor reports whether posts by id pass the author filters of the
+// rule.
+func (rule *TrackingRule) MatchAuthor(id string) bool {
+	for _, excluded := range rule.ExcludeAuthors {
+		if strings.EqualFold(excluded, id) {
+			return false
+		}
+	}
+	if len(rule.IncludeAuthors) == 0 {
+		return true
+	}
+	for _, included := range rule.IncludeAuthors {
+		if strings.EqualFold(included, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchFloor matches the plain text of floor, or of reply when it is not
+// nil, it returns nil when the author is filtered out or nothing matched.
+func (rule *TrackingRule) MatchFloor(floor *db.FloorRecord, reply *db.ReplyRecord) *Match {
+	match := &Match{Floor: floor, Reply: reply}
+	if reply != nil {
+		match.Text = textutil.PlainText(reply.Content)
+	} else {
+		match.Text = textutil.PlainText(floor.Content)
+	}
+
+	if !rule.MatchAuthor(match.AuthorId()) {
+		return nil
+	}
+
+	match.Terms, match.Spans = rule.FindMatches(match.Text)
+	if len(match.Spans) == 0 {
+		return nil
+	}
+	return match
+}
@@ -0,0 +1,130 @@
+package rule
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/davidleitw/baha/internal/db"
+)
+
+func newWatchRule(keywords []string, patterns ...string) *TrackingRule {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, expr := range patterns {
+		compiled = append(compiled, regexp.MustCompile(expr))
+	}
+	return NewTrackingRule(Bsn(60076), Sna(123), Name("watch"), Keywords(keywords...), Patterns(compiled...))
+}
+
+func TestFindMatches(t *testing.T) {
+	cases := []struct {
+		name     string
+		keywords []string
+		patterns []string
+		text     string
+		terms    []string
+		spans    []Span
+	}{
+		{"keyword ignores case", []string{"offer"}, nil, "got an OFFER", []string{"OFFER"}, []Span{{7, 12}}},
+		{"terms are distinct without case", []string{"offer"}, nil, "offer, Offer", []string{"offer"}, []Span{{0, 5}, {7, 12}}},
+		{"keyword is literal", []string{"c++"}, nil, "c++ or cpp", []string{"c++"}, []Span{{0, 3}}},
+		{"pattern", nil, []string{"徵才|內推"}, "內推徵才", []string{"內推", "徵才"}, []Span{{0, 12}}},
+		{"pattern is case sensitive", nil, []string{"Go"}, "go Go", []string{"Go"}, []Span{{3, 5}}},
+		{"overlapping spans merge", []string{"abc", "bcd"}, nil, "xabcdx", []string{"abc", "bcd"}, []Span{{1, 5}}},
+		{"spans are sorted", []string{"b", "a"}, nil, "a b", []string{"b", "a"}, []Span{{0, 1}, {2, 3}}},
+		{"no match", []string{"offer"}, []string{"徵才"}, "nothing here", []string{}, []Span{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			terms, spans := newWatchRule(c.keywords, c.patterns...).FindMatches(c.text)
+			if strings.Join(terms, ",") != strings.Join(c.terms, ",") {
+				t.Errorf("terms = %q, want %q", terms, c.terms)
+			}
+			if fmt.Sprint(spans) != fmt.Sprint(c.spans) {
+				t.Errorf("spans = %v, want %v", spans, c.spans)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	rule := newWatchRule([]string{"offer"}, "內推")
+	cases := []struct {
+		name  string
+		text  string
+		limit int
+		want  string
+	}{
+		{"short", "got an offer today", 200, "got an 【offer】 today"},
+		{"cut after the match", "offer " + strings.Repeat("b", 100), 20, "【offer】 " + strings.Repeat("b", 12) + "…"},
+		{"match within the context", strings.Repeat("a", 30) + "offer" + strings.Repeat("b", 100), 50,
+			strings.Repeat("a", 30) + "【offer】" + strings.Repeat("b", 13) + "…"},
+		{"cut before the match", strings.Repeat("a", 100) + "offer" + strings.Repeat("b", 100), 60,
+			"…" + strings.Repeat("a", 40) + "【offer】" + strings.Repeat("b", 13) + "…"},
+		// the context is counted in runes, not bytes
+		{"cut before a wide match", strings.Repeat("字", 100) + "內推" + strings.Repeat("字", 10), 50,
+			"…" + strings.Repeat("字", 40) + "【內推】" + strings.Repeat("字", 6) + "…"},
+		{"long text fits the limit", strings.Repeat("a", 100) + "offer", 200, strings.Repeat("a", 100) + "【offer】"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			match := &Match{Text: c.text}
+			match.Terms, match.Spans = rule.FindMatches(c.text)
+			if got := match.Highlight(c.limit); got != c.want {
+				t.Errorf("highlight = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMatchAuthor(t *testing.T) {
+	cases := []struct {
+		include []string
+		exclude []string
+		id      string
+		want    bool
+	}{
+		{nil, nil, "anyone", true},
+		{nil, []string{"Spammer"}, "spammer", false},
+		{[]string{"BahaUser"}, nil, "bahauser", true},
+		{[]string{"BahaUser"}, nil, "someone", false},
+		{[]string{"bahauser"}, []string{"bahauser"}, "BahaUser", false},
+	}
+
+	for _, c := range cases {
+		rule := NewTrackingRule(Bsn(60076), Sna(123), Name("watch"), Keywords("a"), IncludeAuthors(c.include...), ExcludeAuthors(c.exclude...))
+		if got := rule.MatchAuthor(c.id); got != c.want {
+			t.Errorf("include %v, exclude %v: MatchAuthor(%s) = %v", c.include, c.exclude, c.id, got)
+		}
+	}
+}
+
+func TestMatchFloor(t *testing.T) {
+	rule := NewTrackingRule(Bsn(60076), Sna(123), Name("watch"), Keywords("offer"), ExcludeAuthors("spammer"))
+	floor := &db.FloorRecord{AuthorId: "poster", AuthorName: "樓主", Content: "<div>got an <b>offer</b></div>"}
+	reply := &db.ReplyRecord{AuthorId: "replier", AuthorName: "路人", Content: "no offer yet"}
+
+	match := rule.MatchFloor(floor, nil)
+	if match == nil || match.Text != "got an offer" || match.AuthorId() != "poster" || match.Highlight(200) != "got an 【offer】" {
+		t.Errorf("floor match = %+v", match)
+	}
+	match = rule.MatchFloor(floor, reply)
+	if match == nil || match.Text != "no offer yet" || match.AuthorName() != "路人" {
+		t.Errorf("reply match = %+v", match)
+	}
+
+	if match := rule.MatchFloor(&db.FloorRecord{AuthorId: "poster", Content: "nothing"}, nil); match != nil {
+		t.Errorf("unmatched floor = %+v", match)
+	}
+	if match := rule.MatchFloor(&db.FloorRecord{AuthorId: "Spammer", Content: "offer"}, nil); match != nil {
+		t.Errorf("excluded author = %+v", match)
+	}
+
+	// an author rule without keywords passes every text
+	if !NewTrackingRule(Bsn(60076), Sna(123), Id("poster")).MatchText("anything") {
+		t.Error("expect text to pass a rule without keywords")
+	}
+}
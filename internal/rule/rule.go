@@ -2,6 +2,8 @@ package rule
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/davidleitw/baha/internal/db"
@@ -22,12 +24,43 @@ func SyncLocalDb(sync bool) RuleOption {
 	}
 }
 
+// SkipReplay starts the rule from the newest floor instead of replaying the
+// floors posted since the cursor stored by the previous run.
+func SkipReplay(skip bool) RuleOption {
+	return func(o *TrackingRule) {
+		o.SkipReplay = skip
+	}
+}
+
 func PokeInterval(interval time.Duration) RuleOption {
 	return func(o *TrackingRule) {
 		o.PokeInterval = interval
 	}
 }
 
+// MaxInterval makes the polling adaptive, the interval starts from
+// PokeInterval after activity and backs off up to MaxInterval while nothing
+// happens.
+func MaxInterval(interval time.Duration) RuleOption {
+	return func(o *TrackingRule) {
+		o.MaxInterval = interval
+	}
+}
+
+// Jitter spreads every interval randomly by up to the given fraction, so
+// rules started together do not poll together.
+func Jitter(fraction float64) RuleOption {
+	return func(o *TrackingRule) {
+		o.Jitter = fraction
+	}
+}
+
+func QuietHours(windows ...QuietWindow) RuleOption {
+	return func(o *TrackingRule) {
+		o.QuietHours = windows
+	}
+}
+
 func DefaultBsn() RuleOption {
 	return func(o *TrackingRule) {
 		o.Bsn = DefaultAsylumBsn
@@ -80,13 +113,118 @@ func DefaultUpdateLastCallback() RuleOption {
 	}
 }
 
+// Sinks routes the notifications of the rule to the named sinks, every
+// configured sink is used when none is given.
+func Sinks(names ...string) RuleOption {
+	return func(o *TrackingRule) {
+		o.Sinks = names
+	}
+}
+
+func Name(name string) RuleOption {
+	return func(o *TrackingRule) {
+		o.Name = name
+	}
+}
+
+// Keywords only passes floors containing at least one of the keywords to
+// the callbacks, every floor passes when none is given. Keywords are
+// matched case-insensitively.
+func Keywords(keywords ...string) RuleOption {
+	return func(o *TrackingRule) {
+		o.Keywords = keywords
+	}
+}
+
+// Patterns works like Keywords with regular expressions, a floor passes
+// when it matches any keyword or pattern.
+func Patterns(patterns ...*regexp.Regexp) RuleOption {
+	return func(o *TrackingRule) {
+		o.Patterns = patterns
+	}
+}
+
+// IncludeAuthors restricts a watch rule to floors and replies by the
+// given ids.
+func IncludeAuthors(ids ...string) RuleOption {
+	return func(o *TrackingRule) {
+		o.IncludeAuthors = ids
+	}
+}
+
+// ExcludeAuthors drops floors and replies by the given ids from a watch
+// rule.
+func ExcludeAuthors(ids ...string) RuleOption {
+	return func(o *TrackingRule) {
+		o.ExcludeAuthors = ids
+	}
+}
+
+// WatchReplies makes a watch rule match the replies under every floor as
+// well.
+func WatchReplies(watch bool) RuleOption {
+	return func(o *TrackingRule) {
+		o.WatchReplies = watch
+	}
+}
+
+// ReplyMode selects which replies (留言) an author rule reports, modes may
+// be combined.
+type ReplyMode uint8
+
+const (
+	// RepliesToAuthor reports new replies under the floors of AimId.
+	RepliesToAuthor ReplyMode = 1 << iota
+	// RepliesByAuthor reports new replies written by AimId under any floor
+	// of the building.
+	RepliesByAuthor
+)
+
+func TrackReplies(mode ReplyMode) RuleOption {
+	return func(o *TrackingRule) {
+		o.ReplyMode = mode
+	}
+}
+
+func NewReplyCallback(callback func(*db.FloorRecord, *db.ReplyRecord)) RuleOption {
+	return func(o *TrackingRule) {
+		o.NewReplyCallback = callback
+	}
+}
+
+func DefaultNewReplyCallback() RuleOption {
+	return func(o *TrackingRule) {
+		o.NewReplyCallback = func(floor *db.FloorRecord, reply *db.ReplyRecord) {
+			logrus.Infof("New Reply on B%d by %s: %s", floor.FloorIndex, reply.AuthorId, reply.Content)
+		}
+	}
+}
+
+func MatchCallback(callback func(*Match)) RuleOption {
+	return func(o *TrackingRule) {
+		o.MatchCallback = callback
+	}
+}
+
+func DefaultMatchCallback() RuleOption {
+	return func(o *TrackingRule) {
+		o.MatchCallback = func(match *Match) {
+			logrus.Infof("Match %v: %s", match.Terms, match.Highlight(DefaultHighlightLength))
+		}
+	}
+}
+
 func MaxFailure(failure int) RuleOption {
 	return func(o *TrackingRule) {
 		o.MaxFailure = failure
 	}
 }
 
+// TrackingRule follows the floors of AimId in a building. Without AimId it
+// is a watch rule instead, which matches every floor of the building, and
+// optionally their replies, against its keywords and patterns.
 type TrackingRule struct {
+	Name        string
 	Bsn         int
 	Sna         int
 	AimId       string
@@ -97,11 +235,27 @@ type TrackingRule struct {
 	LastFloorRecord *db.FloorRecord
 
 	SyncLocalDb  bool
+	SkipReplay   bool
 	PokeInterval time.Duration
+	MaxInterval  time.Duration
+	Jitter       float64
+	QuietHours   []QuietWindow
 	MaxFailure   int
+	Sinks        []string
+
+	Keywords       []string
+	Patterns       []*regexp.Regexp
+	IncludeAuthors []string
+	ExcludeAuthors []string
+	WatchReplies   bool
+	ReplyMode      ReplyMode
 
 	NewPostCallback    func(*db.FloorRecord)
 	UpdateLastCallback func(*db.FloorRecord)
+	NewReplyCallback   func(*db.FloorRecord, *db.ReplyRecord)
+	MatchCallback      func(*Match)
+
+	matchers []*regexp.Regexp
 }
 
 func NewTrackingRule(opts ...RuleOption) *TrackingRule {
@@ -118,14 +272,42 @@ func NewTrackingRule(opts ...RuleOption) *TrackingRule {
 		DefaultUpdateLastCallback()(rule)
 	}
 
+	if rule.NewReplyCallback == nil {
+		DefaultNewReplyCallback()(rule)
+	}
+
+	if rule.MatchCallback == nil {
+		DefaultMatchCallback()(rule)
+	}
+
 	if rule.Bsn == 0 || rule.Sna == 0 {
 		logrus.Errorf("Bsn or Sna is not set")
 		return nil
 	}
 
+	rule.matchers = make([]*regexp.Regexp, 0, len(rule.Keywords)+len(rule.Patterns))
+	for _, keyword := range rule.Keywords {
+		rule.matchers = append(rule.matchers, regexp.MustCompile("(?i)"+regexp.QuoteMeta(keyword)))
+	}
+	rule.matchers = append(rule.matchers, rule.Patterns...)
+
 	if rule.AimId == "" {
-		logrus.Errorf("AimId is not set")
-		return nil
+		if len(rule.matchers) == 0 {
+			logrus.Errorf("AimId is not set and there is no keyword or pattern to watch")
+			return nil
+		}
+		if rule.Name == "" {
+			logrus.Errorf("Name of watch rule is not set")
+			return nil
+		}
+		if rule.ReplyMode != 0 {
+			logrus.Errorf("ReplyMode needs AimId, use WatchReplies for a watch rule")
+			return nil
+		}
+
+		rule.Url = fmt.Sprintf("https://forum.gamer.com.tw/C.php?bsn=%d&snA=%d", rule.Bsn, rule.Sna)
+		rule.LastPageUrl = rule.Url + "&last=1#down"
+		return rule
 	}
 
 	rule.Url = fmt.Sprintf("https://forum.gamer.com.tw/C.php?bsn=%d&snA=%d&s_author=%s", rule.Bsn, rule.Sna, rule.AimId)
@@ -134,6 +316,82 @@ func NewTrackingRule(opts ...RuleOption) *TrackingRule {
 	return rule
 }
 
+// IsWatch reports whether the rule watches the whole building instead of
+// following AimId.
+func (rule *TrackingRule) IsWatch() bool {
+	return rule.AimId == ""
+}
+
+// TracksReplies reports whether the replies under the floors of the rule
+// are diffed between polls.
+func (rule *TrackingRule) TracksReplies() bool {
+	if rule.IsWatch() {
+		return rule.WatchReplies
+	}
+	return rule.ReplyMode&RepliesToAuthor != 0
+}
+
+// TracksAuthorReplies reports whether replies by AimId are looked for in
+// the whole building.
+func (rule *TrackingRule) TracksAuthorReplies() bool {
+	return !rule.IsWatch() && rule.ReplyMode&RepliesByAuthor != 0
+}
+
+// BuildingLastPageUrl is the newest page of the whole building, which the
+// monitor shares between the rules of the building and where RepliesByAuthor
+// looks for replies by AimId.
+func (rule *TrackingRule) BuildingLastPageUrl() string {
+	return fmt.Sprintf("https://forum.gamer.com.tw/C.php?bsn=%d&snA=%d&last=1#down", rule.Bsn, rule.Sna)
+}
+
+// Key identifies the rule across restarts of the monitor. A building has
+// one rule per author but may have several watch rules, told apart by name.
+func (rule *TrackingRule) Key() string {
+	if rule.IsWatch() {
+		return fmt.Sprintf("%d-%d-watch-%s", rule.Bsn, rule.Sna, rule.Name)
+	}
+	return fmt.Sprintf("%d-%d-%s", rule.Bsn, rule.Sna, rule.AimId)
+}
+
+// Equal reports whether both rules are configured the same, the tracking
+// state and callbacks are not compared.
+func (rule *TrackingRule) Equal(other *TrackingRule) bool {
+	return rule.Name == other.Name &&
+		rule.Key() == other.Key() &&
+		rule.SyncLocalDb == other.SyncLocalDb &&
+		rule.SkipReplay == other.SkipReplay &&
+		rule.GetInterval() == other.GetInterval() &&
+		rule.MaxInterval == other.MaxInterval &&
+		rule.Jitter == other.Jitter &&
+		fmt.Sprint(rule.QuietHours) == fmt.Sprint(other.QuietHours) &&
+		rule.GetMaxFailure() == other.GetMaxFailure() &&
+		rule.WatchReplies == other.WatchReplies &&
+		rule.ReplyMode == other.ReplyMode &&
+		joinStrings(rule.Sinks) == joinStrings(other.Sinks) &&
+		joinStrings(rule.Keywords) == joinStrings(other.Keywords) &&
+		joinStrings(rule.IncludeAuthors) == joinStrings(other.IncludeAuthors) &&
+		joinStrings(rule.ExcludeAuthors) == joinStrings(other.ExcludeAuthors) &&
+		joinPatterns(rule.Patterns) == joinPatterns(other.Patterns)
+}
+
+func joinStrings(values []string) string {
+	return strings.Join(values, "\x00")
+}
+
+func joinPatterns(patterns []*regexp.Regexp) string {
+	values := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		values = append(values, pattern.String())
+	}
+	return joinStrings(values)
+}
+
+// GetPageUrl is the page of the floors by AimId, pages are counted among
+// those floors only.
+func (rule *TrackingRule) GetPageUrl(page int) string {
+	return fmt.Sprintf("%s&page=%d", rule.Url, page)
+}
+
 func (rule *TrackingRule) GetInterval() time.Duration {
 	if rule.PokeInterval == 0 {
 		return DefaultInterval
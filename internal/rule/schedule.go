@@ -0,0 +1,96 @@
+package rule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+)
+
+// DefaultBackoffFactor multiplies the interval of an adaptive rule after
+// every poll without activity, up to MaxInterval.
+const DefaultBackoffFactor = 2
+
+// QuietWindow is a daily window in Baha time (Asia/Taipei) when a rule does
+// not poll, From and To are offsets from midnight and the window wraps
+// around midnight when To is before From.
+type QuietWindow struct {
+	From time.Duration
+	To   time.Duration
+}
+
+// ParseQuietWindow reads a window such as "01:00-07:30".
+func ParseQuietWindow(text string) (QuietWindow, error) {
+	from, to, found := strings.Cut(text, "-")
+	if !found {
+		return QuietWindow{}, fmt.Errorf("quiet hours %q is invalid, expect HH:MM-HH:MM", text)
+	}
+
+	fromTime, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return QuietWindow{}, fmt.Errorf("quiet hours %q is invalid, expect HH:MM-HH:MM", text)
+	}
+	toTime, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return QuietWindow{}, fmt.Errorf("quiet hours %q is invalid, expect HH:MM-HH:MM", text)
+	}
+
+	quiet := QuietWindow{
+		From: time.Duration(fromTime.Hour())*time.Hour + time.Duration(fromTime.Minute())*time.Minute,
+		To:   time.Duration(toTime.Hour())*time.Hour + time.Duration(toTime.Minute())*time.Minute,
+	}
+	if quiet.From == quiet.To {
+		return QuietWindow{}, fmt.Errorf("quiet hours %q is empty", text)
+	}
+	return quiet, nil
+}
+
+func (quiet QuietWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(quiet.From) + "-" + format(quiet.To)
+}
+
+// End returns when the window containing t ends, or the zero time when t
+// is outside the window.
+func (quiet QuietWindow) End(t time.Time) time.Time {
+	local := t.In(craw.BahaTimeZone)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, craw.BahaTimeZone)
+	offset := local.Sub(midnight)
+
+	if quiet.From < quiet.To {
+		if offset >= quiet.From && offset < quiet.To {
+			return midnight.Add(quiet.To)
+		}
+		return time.Time{}
+	}
+
+	// wraps around midnight
+	if offset >= quiet.From {
+		return midnight.AddDate(0, 0, 1).Add(quiet.To)
+	}
+	if offset < quiet.To {
+		return midnight.Add(quiet.To)
+	}
+	return time.Time{}
+}
+
+// QuietUntil returns when the quiet hours of the rule containing t end, or
+// the zero time when the rule may poll at t.
+func (rule *TrackingRule) QuietUntil(t time.Time) time.Time {
+	until := time.Time{}
+	for _, quiet := range rule.QuietHours {
+		if end := quiet.End(t); end.After(until) {
+			until = end
+		}
+	}
+	return until
+}
+
+// IsAdaptive reports whether the interval of the rule backs off while
+// nothing happens.
+func (rule *TrackingRule) IsAdaptive() bool {
+	return rule.MaxInterval > rule.GetInterval()
+}
@@ -0,0 +1,109 @@
+package rule
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidleitw/baha/internal/craw"
+)
+
+func TestParseQuietWindow(t *testing.T) {
+	cases := []struct {
+		text string
+		want QuietWindow
+		err  string
+	}{
+		{"01:00-07:30", QuietWindow{From: time.Hour, To: 7*time.Hour + 30*time.Minute}, ""},
+		{" 22:00 - 06:00 ", QuietWindow{From: 22 * time.Hour, To: 6 * time.Hour}, ""},
+		{"23:30-00:00", QuietWindow{From: 23*time.Hour + 30*time.Minute, To: 0}, ""},
+		{"0100-0730", QuietWindow{}, "is invalid, expect HH:MM-HH:MM"},
+		{"01:00", QuietWindow{}, "is invalid, expect HH:MM-HH:MM"},
+		{"25:00-07:00", QuietWindow{}, "is invalid, expect HH:MM-HH:MM"},
+		{"01:00-07:60", QuietWindow{}, "is invalid, expect HH:MM-HH:MM"},
+		{"08:00-08:00", QuietWindow{}, `quiet hours "08:00-08:00" is empty`},
+	}
+
+	for _, c := range cases {
+		quiet, err := ParseQuietWindow(c.text)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: err = %v, want %q", c.text, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.text, err)
+			continue
+		}
+		if quiet != c.want {
+			t.Errorf("%q = %+v, want %+v", c.text, quiet, c.want)
+		}
+		// String gives back the text the window was read from
+		if again, err := ParseQuietWindow(quiet.String()); err != nil || again != quiet {
+			t.Errorf("%q: String() = %q does not read back", c.text, quiet.String())
+		}
+	}
+}
+
+// bahaTime is the given day and time in Baha time.
+func bahaTime(day, hour, minute int) time.Time {
+	return time.Date(2024, time.January, day, hour, minute, 0, 0, craw.BahaTimeZone)
+}
+
+func TestQuietWindowEnd(t *testing.T) {
+	day := QuietWindow{From: 2 * time.Hour, To: 8 * time.Hour}
+	night := QuietWindow{From: 22 * time.Hour, To: 6 * time.Hour}
+	cases := []struct {
+		name  string
+		quiet QuietWindow
+		t     time.Time
+		want  time.Time
+	}{
+		{"before", day, bahaTime(10, 1, 59), time.Time{}},
+		{"from is inside", day, bahaTime(10, 2, 0), bahaTime(10, 8, 0)},
+		{"inside", day, bahaTime(10, 7, 59), bahaTime(10, 8, 0)},
+		{"to is outside", day, bahaTime(10, 8, 0), time.Time{}},
+		{"wrap before", night, bahaTime(10, 21, 59), time.Time{}},
+		{"wrap before midnight", night, bahaTime(10, 22, 0), bahaTime(11, 6, 0)},
+		{"wrap after midnight", night, bahaTime(11, 3, 0), bahaTime(11, 6, 0)},
+		{"wrap to is outside", night, bahaTime(11, 6, 0), time.Time{}},
+		{"wrap to the next month", night, time.Date(2024, time.January, 31, 23, 0, 0, 0, craw.BahaTimeZone),
+			time.Date(2024, time.February, 1, 6, 0, 0, 0, craw.BahaTimeZone)},
+		// windows are in Baha time whatever the zone of t, 15:00 UTC is 23:00 in Taipei
+		{"other zone", night, time.Date(2024, time.January, 10, 15, 0, 0, 0, time.UTC), bahaTime(11, 6, 0)},
+	}
+
+	for _, c := range cases {
+		if got := c.quiet.End(c.t); !got.Equal(c.want) {
+			t.Errorf("%s: End(%s) = %s, want %s", c.name, c.t, got, c.want)
+		}
+	}
+}
+
+func TestQuietUntil(t *testing.T) {
+	trackingRule := NewTrackingRule(Bsn(60076), Sna(123), Id("baha"), QuietHours(
+		QuietWindow{From: 22 * time.Hour, To: 6 * time.Hour},
+		QuietWindow{From: 5 * time.Hour, To: 9 * time.Hour},
+	))
+	cases := []struct {
+		t    time.Time
+		want time.Time
+	}{
+		{bahaTime(10, 12, 0), time.Time{}},
+		{bahaTime(10, 23, 0), bahaTime(11, 6, 0)},
+		// overlapping windows run until the later end
+		{bahaTime(11, 5, 30), bahaTime(11, 9, 0)},
+		{bahaTime(11, 7, 0), bahaTime(11, 9, 0)},
+	}
+
+	for _, c := range cases {
+		if got := trackingRule.QuietUntil(c.t); !got.Equal(c.want) {
+			t.Errorf("QuietUntil(%s) = %s, want %s", c.t, got, c.want)
+		}
+	}
+
+	if got := NewTrackingRule(Bsn(60076), Sna(123), Id("baha")).QuietUntil(bahaTime(10, 3, 0)); !got.IsZero() {
+		t.Errorf("QuietUntil without quiet hours = %s", got)
+	}
+}
@@ -0,0 +1,178 @@
+package textutil
+
+import (
+	"strings"
+)
+
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffDelete DiffOp = "delete"
+	DiffInsert DiffOp = "insert"
+)
+
+type DiffChunk struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxDiffCells bounds the table compared at once, larger blocks are shown
+// as replaced whole.
+const maxDiffCells = 1 << 20
+
+// Diff compares two plain texts line by line, then character by character
+// within every block of changed lines. Joining the equal and deleted
+// chunks gives old back, the equal and inserted ones give new.
+func Diff(old, new string) []DiffChunk {
+	lineChunks := diffTokens(splitLines(old), splitLines(new))
+
+	chunks := make([]DiffChunk, 0, len(lineChunks))
+	for i := 0; i < len(lineChunks); i++ {
+		chunk := lineChunks[i]
+		if chunk.Op == DiffDelete && i+1 < len(lineChunks) && lineChunks[i+1].Op == DiffInsert {
+			chunks = append(chunks, diffTokens(splitRunes(chunk.Text), splitRunes(lineChunks[i+1].Text))...)
+			i++
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+	return mergeChunks(chunks)
+}
+
+// splitLines keeps the line breaks so the lines join back to text.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.SplitAfter(text, "\n")
+}
+
+func splitRunes(text string) []string {
+	tokens := make([]string, 0, len(text))
+	for _, r := range text {
+		tokens = append(tokens, string(r))
+	}
+	return tokens
+}
+
+// diffTokens diffs two token sequences by their longest common subsequence,
+// deletions come before insertions within a change.
+func diffTokens(a, b []string) []DiffChunk {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	chunks := []DiffChunk{{Op: DiffEqual, Text: strings.Join(a[:prefix], "")}}
+	chunks = append(chunks, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	chunks = append(chunks, DiffChunk{Op: DiffEqual, Text: strings.Join(a[len(a)-suffix:], "")})
+	return mergeChunks(chunks)
+}
+
+func diffMiddle(a, b []string) []DiffChunk {
+	if len(a) == 0 || len(b) == 0 || len(a)*len(b) > maxDiffCells {
+		return []DiffChunk{
+			{Op: DiffDelete, Text: strings.Join(a, "")},
+			{Op: DiffInsert, Text: strings.Join(b, "")},
+		}
+	}
+
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	chunks := make([]DiffChunk, 0)
+	var deleted, inserted strings.Builder
+	flush := func() {
+		if deleted.Len() > 0 {
+			chunks = append(chunks, DiffChunk{Op: DiffDelete, Text: deleted.String()})
+			deleted.Reset()
+		}
+		if inserted.Len() > 0 {
+			chunks = append(chunks, DiffChunk{Op: DiffInsert, Text: inserted.String()})
+			inserted.Reset()
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			chunks = append(chunks, DiffChunk{Op: DiffEqual, Text: a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			deleted.WriteString(a[i])
+			i++
+		default:
+			inserted.WriteString(b[j])
+			j++
+		}
+	}
+	flush()
+	return chunks
+}
+
+// mergeChunks drops empty chunks and joins neighbours of the same op.
+func mergeChunks(chunks []DiffChunk) []DiffChunk {
+	merged := make([]DiffChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if chunk.Text == "" {
+			continue
+		}
+		if last := len(merged) - 1; last >= 0 && merged[last].Op == chunk.Op {
+			merged[last].Text += chunk.Text
+			continue
+		}
+		merged = append(merged, chunk)
+	}
+	return merged
+}
+
+// FormatDiff renders the changes of chunks as [-deleted-]{+inserted+},
+// keeping up to context runes of unchanged text around every change.
+func FormatDiff(chunks []DiffChunk, context int) string {
+	var builder strings.Builder
+	for i, chunk := range chunks {
+		switch chunk.Op {
+		case DiffDelete:
+			builder.WriteString("[-" + chunk.Text + "-]")
+		case DiffInsert:
+			builder.WriteString("{+" + chunk.Text + "+}")
+		default:
+			runes := []rune(chunk.Text)
+			first, last := i == 0, i == len(chunks)-1
+			switch {
+			case first && last:
+			case first && len(runes) > context:
+				builder.WriteString("…" + string(runes[len(runes)-context:]))
+			case last && len(runes) > context:
+				builder.WriteString(string(runes[:context]) + "…")
+			case !first && !last && len(runes) > 2*context:
+				builder.WriteString(string(runes[:context]) + "…" + string(runes[len(runes)-context:]))
+			default:
+				builder.WriteString(chunk.Text)
+			}
+		}
+	}
+	return builder.String()
+}
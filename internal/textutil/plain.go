@@ -0,0 +1,38 @@
+package textutil
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// PlainText turns the HTML of a floor into readable text, one line per
+// line break or block element with blank lines dropped.
+func PlainText(html string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return strings.TrimSpace(html)
+	}
+
+	doc.Find("br").ReplaceWithHtml("\n")
+	doc.Find("div, p, li, blockquote").Each(func(i int, s *goquery.Selection) {
+		s.AppendHtml("\n")
+	})
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(doc.Text(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Excerpt cuts text to at most limit runes, ending with an ellipsis when cut.
+func Excerpt(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}